@@ -0,0 +1,71 @@
+package payloader
+
+import (
+	"errors"
+	"fmt"
+	"github.com/domsolutions/gopayloader/pkgs/payloader/control"
+	"github.com/domsolutions/gopayloader/pkgs/payloader/output/cli"
+	"github.com/spf13/cobra"
+	"strconv"
+)
+
+var ctlAddr string
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl stats|pause|resume|rate <rps>|conns <n>",
+	Short: "Control a run started with --control-addr",
+	Long: `Control a run started with --control-addr while it's running;
+  stats        show live stats
+  pause        stop sending requests until resumed
+  resume       resume sending requests
+  rate <rps>   cap the requests per second across all connections, 0 removes the cap
+  conns <n>    send requests over only n of the connections, or n workers with --concurrency or --vus, not for runs with -r`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client := control.NewClient(ctlAddr)
+
+		var snapshot *control.Snapshot
+		var err error
+		switch args[0] {
+		case "stats":
+			snapshot, err = client.Stats()
+		case "pause":
+			snapshot, err = client.Pause()
+		case "resume":
+			snapshot, err = client.Resume()
+		case "rate":
+			if len(args) != 2 {
+				return errors.New("rate takes the requests per second i.e. ctl rate 500")
+			}
+			rps, parseErr := strconv.ParseFloat(args[1], 64)
+			if parseErr != nil {
+				return fmt.Errorf("invalid rate %s", args[1])
+			}
+			snapshot, err = client.SetRate(rps)
+		case "conns":
+			if len(args) != 2 {
+				return errors.New("conns takes the number of connections i.e. ctl conns 10")
+			}
+			n, parseErr := strconv.Atoi(args[1])
+			if parseErr != nil {
+				return fmt.Errorf("invalid number of connections %s", args[1])
+			}
+			snapshot, err = client.SetConns(n)
+		default:
+			return fmt.Errorf("unknown command %s, expected one of stats, pause, resume, rate, conns", args[0])
+		}
+		if err != nil {
+			// the arguments were fine, the run's API couldn't be reached or refused the change
+			cmd.SilenceUsage = true
+			return err
+		}
+
+		cli.DisplaySnapshot(snapshot)
+		return nil
+	},
+}
+
+func init() {
+	ctlCmd.Flags().StringVar(&ctlAddr, "addr", "localhost:9999", "Address of the run's control API")
+	rootCmd.AddCommand(ctlCmd)
+}
@@ -1,7 +1,9 @@
 package payloader
 
 import (
+	"errors"
 	"github.com/domsolutions/gopayloader/version"
+	"github.com/domsolutions/gopayloader/wrapper"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -13,8 +15,21 @@ var rootCmd = &cobra.Command{
 	Long:  ``,
 }
 
+const (
+	// exitAborted is the exit code when an abort condition stopped the run
+	exitAborted = 2
+	// exitInterrupted is the exit code when the user interrupted the run, as a shell reports SIGINT
+	exitInterrupted = 130
+)
+
 func Execute() {
 	err := rootCmd.Execute()
+	if errors.Is(err, wrapper.ErrAborted) {
+		os.Exit(exitAborted)
+	}
+	if errors.Is(err, wrapper.ErrInterrupted) {
+		os.Exit(exitInterrupted)
+	}
 	if err != nil {
 		os.Exit(1)
 	}
@@ -17,6 +17,7 @@ const (
 	argTime            = "time"
 	argMTLSKey         = "mtls-key"
 	argMTLSCert        = "mtls-cert"
+	argMTLSDir         = "mtls-dir"
 	argReadTimeout     = "read-timeout"
 	argWriteTimeout    = "write-timeout"
 	argVerbose         = "verbose"
@@ -34,13 +35,61 @@ const (
 	argBodyFile        = "body-file"
 	argClient          = "client"
 	argParallel        = "parallel"
+	argPipeline        = "pipeline"
+	argHandshake       = "handshake"
+	argSessionResume   = "session-resumption"
+	argResolve         = "resolve"
+	argResolver        = "resolver"
+	argSourceIP        = "source-ip"
+	argCACert          = "ca-cert"
+	argSNI             = "sni"
+	argTLSMin          = "tls-min"
+	argTLSMax          = "tls-max"
+	argTLSCiphers      = "tls-ciphers"
+	argTLSCurves       = "tls-curves"
+	argALPN            = "alpn"
+	argH2MaxStreams    = "h2-max-streams"
+	argH2Conns         = "h2-conns"
+	argH2MaxHeaderList = "h2-max-header-list-size"
+	argH2MaxFrameSize  = "h2-max-frame-size"
+	argH2StreamWindow  = "h2-stream-window"
+	argH2ConnWindow    = "h2-conn-window"
+	argH2ReadIdle      = "h2-read-idle-timeout"
+	argH2PingTimeout   = "h2-ping-timeout"
+	argQUICHandshake   = "quic-handshake-timeout"
+	argQUICIdle        = "quic-idle-timeout"
+	argQUICKeepAlive   = "quic-keep-alive"
+	argQUICMaxStreams  = "quic-max-incoming-streams"
+	argQUIC0RTT        = "quic-0rtt"
+	argFollowRedirects = "follow-redirects"
+	argNoRedirects     = "no-redirects"
+	argConcurrency     = "concurrency"
+	argMaxInflight     = "max-inflight"
+	argVUs             = "vus"
+	argThinkTime       = "think-time"
+	argArrival         = "arrival"
+	argWarmup          = "warmup"
+	argWarmupRequests  = "warmup-requests"
+	argAbortErrorRate  = "abort-on-error-rate"
+	argAbortWindow     = "abort-window"
+	argAbortP99        = "abort-on-p99"
+	argAbortErrors     = "abort-after-errors"
+	argGracePeriod     = "grace-period"
+	argControlAddr     = "control-addr"
+	argRetryAttempts   = "retry-attempts"
+	argRetryOn         = "retry-on"
+	argRetryBackoff    = "retry-backoff"
+	argRetryMaxBackoff = "retry-max-backoff"
+	argRetryJitter     = "retry-jitter"
+	argHonorRetryAfter = "honor-retry-after"
 )
 
 var (
 	client           string
 	method           string
-	mTLSCert         string
-	mTLSKey          string
+	mTLSCerts        *[]string
+	mTLSKeys         *[]string
+	mTLSDir          string
 	duration         time.Duration
 	readTimeout      time.Duration
 	writeTimeout     time.Duration
@@ -62,6 +111,53 @@ var (
 	body             string
 	bodyFile         string
 	parallel         bool
+	pipeline         uint
+	handshake        bool
+	sessionResume    bool
+	resolve          *[]string
+	resolver         string
+	sourceIPs        *[]string
+	caCert           string
+	sni              string
+	tlsMin           string
+	tlsMax           string
+	tlsCiphers       *[]string
+	tlsCurves        *[]string
+	alpn             *[]string
+	h2MaxStreams     uint
+	h2Conns          uint
+	h2MaxHeaderList  uint32
+	h2MaxFrameSize   uint32
+	h2StreamWindow   uint32
+	h2ConnWindow     uint32
+	h2ReadIdle       time.Duration
+	h2PingTimeout    time.Duration
+	quicHandshake    time.Duration
+	quicIdle         time.Duration
+	quicKeepAlive    time.Duration
+	quicMaxStreams   int64
+	quic0RTT         bool
+	followRedirects  uint
+	noRedirects      bool
+	concurrency      uint
+	maxInflight      uint
+	vus              uint
+	thinkTime        string
+	arrival          string
+	warmup           time.Duration
+	warmupRequests   int64
+	abortErrorRate   string
+	abortWindow      time.Duration
+	abortP99         time.Duration
+	abortErrors      uint64
+	gracePeriod      time.Duration
+	controlAddr      string
+	retryAttempts    uint
+	retryOn          []string
+	retryBackoff     time.Duration
+	retryMaxBackoff  time.Duration
+	retryJitter      float64
+	honorRetryAfter  bool
 )
 
 var runCmd = &cobra.Command{
@@ -76,9 +172,13 @@ var runCmd = &cobra.Command{
 	Long: ``,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		reqURI := args[0]
-		return wrapper.RunGoPayLoader(reqURI,
-			mTLSCert,
-			mTLSKey,
+		if noRedirects {
+			followRedirects = 0
+		}
+		err := wrapper.RunGoPayLoader(reqURI,
+			*mTLSCerts,
+			*mTLSKeys,
+			mTLSDir,
 			disableKeepAlive,
 			reqs,
 			conns,
@@ -101,15 +201,88 @@ var runCmd = &cobra.Command{
 			body,
 			bodyFile,
 			client,
-			parallel)
+			parallel,
+			pipeline,
+			handshake,
+			sessionResume,
+			*resolve,
+			resolver,
+			*sourceIPs,
+			caCert,
+			sni,
+			tlsMin,
+			tlsMax,
+			*tlsCiphers,
+			*tlsCurves,
+			*alpn,
+			h2MaxStreams,
+			h2Conns,
+			h2MaxHeaderList,
+			h2MaxFrameSize,
+			h2StreamWindow,
+			h2ConnWindow,
+			h2ReadIdle,
+			h2PingTimeout,
+			quicHandshake,
+			quicIdle,
+			quicKeepAlive,
+			quicMaxStreams,
+			quic0RTT,
+			followRedirects,
+			concurrency,
+			maxInflight,
+			vus,
+			thinkTime,
+			arrival,
+			warmup,
+			warmupRequests,
+			abortErrorRate,
+			abortWindow,
+			abortP99,
+			abortErrors,
+			gracePeriod,
+			controlAddr,
+			retryAttempts,
+			retryOn,
+			retryBackoff,
+			retryMaxBackoff,
+			retryJitter,
+			honorRetryAfter)
+		if errors.Is(err, wrapper.ErrAborted) || errors.Is(err, wrapper.ErrInterrupted) {
+			// results were displayed, usage isn't relevant
+			cmd.SilenceUsage = true
+		}
+		return err
 	},
 }
 
 func init() {
 	runCmd.Flags().Int64VarP(&reqs, argRequests, "r", 0, "Number of requests")
 	runCmd.Flags().UintVarP(&conns, argConnections, "c", 1, "Number of simultaneous connections")
+	runCmd.Flags().UintVar(&concurrency, argConcurrency, 0, "Maximum requests in flight, shared round-robin over --connections, defaults to one per connection")
+	runCmd.Flags().UintVar(&vus, argVUs, 0, "Number of virtual users, each sends a request at a time over --connections round-robin pausing for --think-time in between")
+	runCmd.Flags().StringVar(&thinkTime, argThinkTime, "", "Pause between a virtual user's requests i.e. 500ms, constant:500ms, uniform:100ms,900ms, exponential:500ms (mean), normal:500ms,100ms (mean,stddev)")
+	runCmd.Flags().DurationVar(&warmup, argWarmup, 0, "Send requests for this long before the run, warm-up requests are excluded from the results")
+	runCmd.Flags().Int64Var(&warmupRequests, argWarmupRequests, 0, "Send this many requests before the run, warm-up requests are excluded from the results")
+	runCmd.Flags().UintVar(&retryAttempts, argRetryAttempts, 0, "Send requests failing with a --retry-on response code or error up to this many times, the first attempt included")
+	runCmd.Flags().StringSliceVar(&retryOn, argRetryOn, []string{"502", "503", "504", "reset"}, "Response codes, 5xx, reset (connection reset or closed) and timeout to retry on")
+	runCmd.Flags().DurationVar(&retryBackoff, argRetryBackoff, 100*time.Millisecond, "Backoff before the first retry, doubled for each retry after")
+	runCmd.Flags().DurationVar(&retryMaxBackoff, argRetryMaxBackoff, 5*time.Second, "Max backoff between retries")
+	runCmd.Flags().Float64Var(&retryJitter, argRetryJitter, 1, "Fraction of each backoff drawn at random from 0 to 1, 1 is full jitter")
+	runCmd.Flags().BoolVar(&honorRetryAfter, argHonorRetryAfter, false, "Hold back requests over a connection for the Retry-After of 429 and 503 responses")
+	runCmd.Flags().StringVar(&controlAddr, argControlAddr, "", "Serve an HTTP API on this address i.e. localhost:9999 to pause, resume, change the rate or active connections and fetch live stats, see the ctl command")
+	runCmd.Flags().DurationVar(&gracePeriod, argGracePeriod, 10*time.Second, "How long in-flight requests are given to complete when the run is interrupted or aborted")
+	runCmd.Flags().StringVar(&abortErrorRate, argAbortErrorRate, "", "Stop the run when the error rate over --abort-window reaches this percentage i.e. 20%")
+	runCmd.Flags().DurationVar(&abortWindow, argAbortWindow, 10*time.Second, "Rolling window --abort-on-error-rate and --abort-on-p99 are checked over, in whole seconds")
+	runCmd.Flags().DurationVar(&abortP99, argAbortP99, 0, "Stop the run when p99 latency over --abort-window reaches this i.e. 2s")
+	runCmd.Flags().Uint64Var(&abortErrors, argAbortErrors, 0, "Stop the run after this many failed requests")
+	runCmd.Flags().StringVar(&arrival, argArrival, "", "Arrival process of requests sent over a duration, uniform (default), poisson, bursty:<on>,<off> i.e. bursty:1s,4s or csv:<path> of timestamp,rps rows")
 	runCmd.Flags().BoolVarP(&disableKeepAlive, argKeepAlive, "k", false, "Disable keep-alive connections")
 	runCmd.Flags().BoolVar(&parallel, argParallel, false, "Sends reqs in parallel per connection with HTTP/2 or HTTP/3")
+	runCmd.Flags().UintVar(&maxInflight, argMaxInflight, 0, "Maximum requests in flight per connection with --parallel, the next request waits for a free slot, unlimited by default")
+	runCmd.Flags().UintVar(&pipeline, argPipeline, 0, "Pipeline up to N HTTP/1.1 requests per connection with "+worker.HttpClientFastHTTP1+" client")
+	runCmd.Flags().BoolVar(&handshake, argHandshake, false, "Benchmark TLS handshakes only, each request opens a TCP+TLS connection, completes the handshake and closes it")
+	runCmd.Flags().BoolVar(&sessionResume, argSessionResume, false, "Cache TLS session tickets to resume sessions on new connections")
 
 	runCmd.Flags().BoolVar(&skipVerify, argVerifySigner, false, "Skip verify SSL cert signer")
 	runCmd.Flags().DurationVarP(&duration, argTime, "t", 0, "Execution time window, if used with -r will uniformly distribute reqs within time window, without -r reqs are unlimited")
@@ -117,12 +290,38 @@ func init() {
 	runCmd.Flags().DurationVar(&writeTimeout, argWriteTimeout, 10*time.Second, "Write timeout")
 	runCmd.Flags().StringVarP(&method, argMethod, "m", "GET", "request method")
 	runCmd.Flags().StringVarP(&body, argBody, "b", "", "request body")
-	runCmd.Flags().StringVar(&bodyFile, argBodyFile, "", "read request body from file")
-	runCmd.Flags().BoolVarP(&verbose, argVerbose, "v", false, "verbose - slows down RPS slightly for long running tests")
+	runCmd.Flags().StringVar(&bodyFile, argBodyFile, "", "read request body from file, files over 1MB are streamed from disk rather than held in memory")
+	runCmd.Flags().BoolVarP(&verbose, argVerbose, "v", false, "verbose - shows progress and debug messages while running")
 	runCmd.Flags().DurationVar(&ticker, argTicker, time.Second, "How often to print results while running in verbose mode")
 	headers = runCmd.Flags().StringSliceP(argHeaders, "H", []string{}, "headers to send in request, can have multiple i.e -H 'content-type:application/json' -H' connection:close'")
-	runCmd.Flags().StringVar(&mTLSCert, argMTLSCert, "", "mTLS cert path")
-	runCmd.Flags().StringVar(&mTLSKey, argMTLSKey, "", "mTLS cert private key path")
+	resolve = runCmd.Flags().StringArray(argResolve, []string{}, "Resolve host:port to given IPs, connections are spread round-robin across them, can have multiple i.e --resolve example.com:443:10.0.0.1,10.0.0.2")
+	runCmd.Flags().StringVar(&resolver, argResolver, "", "DNS server address used to resolve hosts not in --resolve i.e. 8.8.8.8:53")
+	sourceIPs = runCmd.Flags().StringSlice(argSourceIP, []string{}, "Local addresses to bind connections to, assigned round-robin to connections i.e --source-ip 10.0.0.5,10.0.0.6")
+	runCmd.Flags().StringVar(&caCert, argCACert, "", "CA bundle path used to verify the server cert")
+	runCmd.Flags().StringVar(&sni, argSNI, "", "TLS server name (SNI) override")
+	runCmd.Flags().StringVar(&tlsMin, argTLSMin, "", "Min TLS version i.e. 1.2")
+	runCmd.Flags().StringVar(&tlsMax, argTLSMax, "", "Max TLS version i.e. 1.3")
+	tlsCiphers = runCmd.Flags().StringSlice(argTLSCiphers, []string{}, "TLS 1.0-1.2 cipher suites i.e. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS 1.3 suites aren't configurable")
+	tlsCurves = runCmd.Flags().StringSlice(argTLSCurves, []string{}, "TLS curve preferences i.e. X25519,P-256")
+	alpn = runCmd.Flags().StringSlice(argALPN, []string{}, "TLS ALPN protocols the client speaks i.e. http/1.1, "+worker.HttpClientNetHTTP2+" and "+worker.HttpClientNetHTTP3+" clients always offer h2 and h3")
+	runCmd.Flags().UintVar(&h2MaxStreams, argH2MaxStreams, 0, "Max concurrent streams per HTTP/2 connection, defaults to the server's limit")
+	runCmd.Flags().UintVar(&h2Conns, argH2Conns, 1, "Number of HTTP/2 connections to spread each connection's streams over round-robin")
+	runCmd.Flags().Uint32Var(&h2MaxHeaderList, argH2MaxHeaderList, 0, "HTTP/2 SETTINGS_MAX_HEADER_LIST_SIZE in bytes, defaults to 10MB")
+	runCmd.Flags().Uint32Var(&h2MaxFrameSize, argH2MaxFrameSize, 0, "HTTP/2 SETTINGS_MAX_FRAME_SIZE in bytes, defaults to 16KB")
+	runCmd.Flags().Uint32Var(&h2StreamWindow, argH2StreamWindow, 0, "HTTP/2 initial stream flow control window in bytes, 4KB to 4MB, defaults to 4MB")
+	runCmd.Flags().Uint32Var(&h2ConnWindow, argH2ConnWindow, 0, "HTTP/2 initial connection flow control window in bytes, 65535 to 1GB, defaults to 1GB")
+	runCmd.Flags().DurationVar(&h2ReadIdle, argH2ReadIdle, 0, "Send an HTTP/2 ping health check after no frames are received for this long")
+	runCmd.Flags().DurationVar(&h2PingTimeout, argH2PingTimeout, 0, "Close the HTTP/2 connection if a ping isn't answered within this time, defaults to 15s")
+	runCmd.Flags().UintVar(&followRedirects, argFollowRedirects, 10, "Follow up to N redirects to the same host, redirect responses are reported as is with 0")
+	runCmd.Flags().BoolVar(&noRedirects, argNoRedirects, false, "Don't follow redirects, same as --follow-redirects 0")
+	runCmd.Flags().DurationVar(&quicHandshake, argQUICHandshake, 0, "QUIC handshake idle timeout, defaults to 5s")
+	runCmd.Flags().DurationVar(&quicIdle, argQUICIdle, 0, "Close QUIC connections idle for this long, defaults to 30s")
+	runCmd.Flags().DurationVar(&quicKeepAlive, argQUICKeepAlive, 0, "Send QUIC keep-alive packets this often, disabled by default")
+	runCmd.Flags().Int64Var(&quicMaxStreams, argQUICMaxStreams, 0, "Max server initiated QUIC streams, -1 allows none")
+	runCmd.Flags().BoolVar(&quic0RTT, argQUIC0RTT, false, "Send GET requests as 0-RTT early data when QUIC sessions are resumed")
+	mTLSCerts = runCmd.Flags().StringArray(argMTLSCert, []string{}, "mTLS cert path, can have multiple paired with --mtls-key in order, identities are assigned round-robin to connections")
+	mTLSKeys = runCmd.Flags().StringArray(argMTLSKey, []string{}, "mTLS cert private key path, can have multiple")
+	runCmd.Flags().StringVar(&mTLSDir, argMTLSDir, "", "Directory of mTLS identities, <name>.crt or <name>.pem paired with <name>.key or <name>-key.pem")
 
 	runCmd.Flags().StringVar(&client, argClient, worker.HttpClientFastHTTP1, worker.HttpClientFastHTTP1+` for fast http/1.1 requests
 `+worker.HttpClientNetHTTP+` for standard net/http requests using http/1.1
@@ -140,6 +339,10 @@ func init() {
 
 	runCmd.MarkFlagsRequiredTogether(argMTLSCert, argMTLSKey)
 	runCmd.MarkFlagsMutuallyExclusive(argBody, argBodyFile)
+	runCmd.MarkFlagsMutuallyExclusive(argPipeline, argParallel)
+	runCmd.MarkFlagsMutuallyExclusive(argFollowRedirects, argNoRedirects)
+	runCmd.MarkFlagsMutuallyExclusive(argConcurrency, argVUs)
+	runCmd.MarkFlagsMutuallyExclusive(argWarmup, argWarmupRequests)
 	runCmd.MarkFlagsMutuallyExclusive(argJWTsFilename, argJWTKid)
 	runCmd.MarkFlagsMutuallyExclusive(argJWTsFilename, argJWTAud)
 	runCmd.MarkFlagsMutuallyExclusive(argJWTsFilename, argJWTIss)
@@ -2,77 +2,184 @@ package config
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
+	"github.com/domsolutions/gopayloader/pkgs/http-clients/dialer"
+	"github.com/domsolutions/gopayloader/pkgs/http-clients/nethttp"
 	"github.com/domsolutions/gopayloader/pkgs/payloader/worker"
+	"net"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type Config struct {
-	Ctx                 context.Context
-	ReqURI              string
-	DisableKeepAlive    bool
-	ReqTarget           int64
-	Conns               uint
-	Duration            time.Duration
-	MTLSKey             string
-	MTLSCert            string
-	SkipVerify          bool
-	ReadTimeout         time.Duration
-	WriteTimeout        time.Duration
-	Method              string
-	Verbose             bool
-	VerboseTicker       time.Duration
-	JwtKID              string
-	JwtKey              string
-	JwtSub              string
-	JwtCustomClaimsJSON string
-	JwtIss              string
-	JwtAud              string
-	JwtHeader           string
-	JwtsFilename        string
-	SendJWT             bool
-	Headers             []string
-	Body                string
-	BodyFile            string
-	Client              string
-	Parallel            bool
-}
-
-func NewConfig(ctx context.Context, reqURI, mTLScert, mTLSKey string, disableKeepAlive bool, reqs int64, conns uint, totalTime time.Duration, skipVerify bool, readTimeout, writeTimeout time.Duration, method string, verbose bool, ticker time.Duration, jwtKID, jwtKey, jwtSub, jwtCustomClaimsJSON, jwtIss, jwtAud, jwtHeader, jwtsFilename string, headers []string, body, bodyFile string, client string, parallel bool) *Config {
+	Ctx                    context.Context
+	ReqURI                 string
+	DisableKeepAlive       bool
+	ReqTarget              int64
+	Conns                  uint
+	Duration               time.Duration
+	MTLSKeys               []string
+	MTLSCerts              []string
+	MTLSDir                string
+	MTLSIdentities         []http_clients.MTLSIdentity
+	SkipVerify             bool
+	ReadTimeout            time.Duration
+	WriteTimeout           time.Duration
+	Method                 string
+	Verbose                bool
+	VerboseTicker          time.Duration
+	JwtKID                 string
+	JwtKey                 string
+	JwtSub                 string
+	JwtCustomClaimsJSON    string
+	JwtIss                 string
+	JwtAud                 string
+	JwtHeader              string
+	JwtsFilename           string
+	SendJWT                bool
+	Headers                []string
+	Body                   string
+	BodyFile               string
+	Client                 string
+	Parallel               bool
+	Pipeline               uint
+	Handshake              bool
+	SessionResumption      bool
+	Resolve                []string
+	Resolver               string
+	SourceIPs              []string
+	CACert                 string
+	SNI                    string
+	TLSMin                 string
+	TLSMax                 string
+	TLSCiphers             []string
+	TLSCurves              []string
+	ALPN                   []string
+	H2MaxStreams           uint
+	H2Conns                uint
+	H2MaxHeaderListSize    uint32
+	H2MaxFrameSize         uint32
+	H2StreamWindow         uint32
+	H2ConnWindow           uint32
+	H2ReadIdleTimeout      time.Duration
+	H2PingTimeout          time.Duration
+	QUICHandshakeTimeout   time.Duration
+	QUICIdleTimeout        time.Duration
+	QUICKeepAlive          time.Duration
+	QUICMaxIncomingStreams int64
+	QUIC0RTT               bool
+	MaxRedirects           uint
+	Concurrency            uint
+	MaxInflight            uint
+	VUs                    uint
+	ThinkTime              string
+	ThinkTimeDist          *http_clients.ThinkTime
+	Arrival                string
+	ArrivalProcess         *http_clients.Arrival
+	Warmup                 time.Duration
+	WarmupRequests         int64
+	AbortErrorRate         string
+	AbortErrorRatio        float64
+	AbortWindow            time.Duration
+	AbortP99               time.Duration
+	AbortAfterErrors       uint64
+	GracePeriod            time.Duration
+	ControlAddr            string
+	RetryAttempts          uint
+	RetryOn                []string
+	RetryBackoff           time.Duration
+	RetryMaxBackoff        time.Duration
+	RetryJitter            float64
+	RetryPolicy            *http_clients.RetryPolicy
+	HonorRetryAfter        bool
+	parsed                 bool
+}
+
+func NewConfig(ctx context.Context, reqURI string, mTLSCerts, mTLSKeys []string, mTLSDir string, disableKeepAlive bool, reqs int64, conns uint, totalTime time.Duration, skipVerify bool, readTimeout, writeTimeout time.Duration, method string, verbose bool, ticker time.Duration, jwtKID, jwtKey, jwtSub, jwtCustomClaimsJSON, jwtIss, jwtAud, jwtHeader, jwtsFilename string, headers []string, body, bodyFile string, client string, parallel bool, pipeline uint, handshake, sessionResumption bool, resolve []string, resolver string, sourceIPs []string, caCert, sni, tlsMin, tlsMax string, tlsCiphers, tlsCurves, alpn []string, h2MaxStreams, h2Conns uint, h2MaxHeaderListSize, h2MaxFrameSize, h2StreamWindow, h2ConnWindow uint32, h2ReadIdleTimeout, h2PingTimeout time.Duration, quicHandshakeTimeout, quicIdleTimeout, quicKeepAlive time.Duration, quicMaxIncomingStreams int64, quic0RTT bool, maxRedirects, concurrency, maxInflight, vus uint, thinkTime, arrival string, warmup time.Duration, warmupRequests int64, abortErrorRate string, abortWindow, abortP99 time.Duration, abortAfterErrors uint64, gracePeriod time.Duration, controlAddr string, retryAttempts uint, retryOn []string, retryBackoff, retryMaxBackoff time.Duration, retryJitter float64, honorRetryAfter bool) *Config {
 	return &Config{
-		Ctx:                 ctx,
-		ReqURI:              reqURI,
-		MTLSKey:             mTLSKey,
-		MTLSCert:            mTLScert,
-		DisableKeepAlive:    disableKeepAlive,
-		ReqTarget:           reqs,
-		Conns:               conns,
-		Duration:            totalTime,
-		SkipVerify:          skipVerify,
-		ReadTimeout:         readTimeout,
-		WriteTimeout:        writeTimeout,
-		Method:              method,
-		Verbose:             verbose,
-		VerboseTicker:       ticker,
-		JwtKID:              jwtKID,
-		JwtKey:              jwtKey,
-		JwtSub:              jwtSub,
-		JwtCustomClaimsJSON: jwtCustomClaimsJSON,
-		JwtIss:              jwtIss,
-		JwtAud:              jwtAud,
-		JwtHeader:           jwtHeader,
-		JwtsFilename:        jwtsFilename,
-		Headers:             headers,
-		Body:                body,
-		BodyFile:            bodyFile,
-		Client:              client,
-		Parallel:            parallel,
+		Ctx:                    ctx,
+		ReqURI:                 reqURI,
+		MTLSKeys:               mTLSKeys,
+		MTLSCerts:              mTLSCerts,
+		MTLSDir:                mTLSDir,
+		DisableKeepAlive:       disableKeepAlive,
+		ReqTarget:              reqs,
+		Conns:                  conns,
+		Duration:               totalTime,
+		SkipVerify:             skipVerify,
+		ReadTimeout:            readTimeout,
+		WriteTimeout:           writeTimeout,
+		Method:                 method,
+		Verbose:                verbose,
+		VerboseTicker:          ticker,
+		JwtKID:                 jwtKID,
+		JwtKey:                 jwtKey,
+		JwtSub:                 jwtSub,
+		JwtCustomClaimsJSON:    jwtCustomClaimsJSON,
+		JwtIss:                 jwtIss,
+		JwtAud:                 jwtAud,
+		JwtHeader:              jwtHeader,
+		JwtsFilename:           jwtsFilename,
+		Headers:                headers,
+		Body:                   body,
+		BodyFile:               bodyFile,
+		Client:                 client,
+		Parallel:               parallel,
+		Pipeline:               pipeline,
+		Handshake:              handshake,
+		SessionResumption:      sessionResumption,
+		Resolve:                resolve,
+		Resolver:               resolver,
+		SourceIPs:              sourceIPs,
+		CACert:                 caCert,
+		SNI:                    sni,
+		TLSMin:                 tlsMin,
+		TLSMax:                 tlsMax,
+		TLSCiphers:             tlsCiphers,
+		TLSCurves:              tlsCurves,
+		ALPN:                   alpn,
+		H2MaxStreams:           h2MaxStreams,
+		H2Conns:                h2Conns,
+		H2MaxHeaderListSize:    h2MaxHeaderListSize,
+		H2MaxFrameSize:         h2MaxFrameSize,
+		H2StreamWindow:         h2StreamWindow,
+		H2ConnWindow:           h2ConnWindow,
+		H2ReadIdleTimeout:      h2ReadIdleTimeout,
+		H2PingTimeout:          h2PingTimeout,
+		QUICHandshakeTimeout:   quicHandshakeTimeout,
+		QUICIdleTimeout:        quicIdleTimeout,
+		QUICKeepAlive:          quicKeepAlive,
+		QUICMaxIncomingStreams: quicMaxIncomingStreams,
+		QUIC0RTT:               quic0RTT,
+		MaxRedirects:           maxRedirects,
+		Concurrency:            concurrency,
+		MaxInflight:            maxInflight,
+		VUs:                    vus,
+		ThinkTime:              thinkTime,
+		Arrival:                arrival,
+		Warmup:                 warmup,
+		WarmupRequests:         warmupRequests,
+		AbortErrorRate:         abortErrorRate,
+		AbortWindow:            abortWindow,
+		AbortP99:               abortP99,
+		AbortAfterErrors:       abortAfterErrors,
+		GracePeriod:            gracePeriod,
+		ControlAddr:            controlAddr,
+		RetryAttempts:          retryAttempts,
+		RetryOn:                retryOn,
+		RetryBackoff:           retryBackoff,
+		RetryMaxBackoff:        retryMaxBackoff,
+		RetryJitter:            retryJitter,
+		HonorRetryAfter:        honorRetryAfter,
 	}
 }
 
@@ -84,11 +191,34 @@ const regEx = `https?:\/\/(.)*(?::\d+)`
 
 var regExHostURI = regexp.MustCompile(regEx)
 
-var allowedMethods = [4]string{
+var allowedMethods = [5]string{
 	"GET",
 	"PUT",
 	"POST",
 	"DELETE",
+	"HEAD",
+}
+
+// CustomDialer returns true when connections must be dialed through gopayloader's own dialer rather than the client's
+func (c *Config) CustomDialer() bool {
+	return len(c.Resolve) > 0 || c.Resolver != "" || len(c.SourceIPs) > 0
+}
+
+// Workers returns the number of workers sending requests, each has a single request in flight at a time unless
+// running in parallel. Without --concurrency or --vus every connection gets its own worker.
+func (c *Config) Workers() uint {
+	if c.VUs > 0 {
+		return c.VUs
+	}
+	if c.Concurrency > 0 {
+		return c.Concurrency
+	}
+	return c.Conns
+}
+
+// SharesConns returns true when workers share a pool of connections rather than owning one each
+func (c *Config) SharesConns() bool {
+	return c.Concurrency > 0 || c.VUs > 0
 }
 
 // Converts jwtCustomClaimsJSON from string to map[string]interface{}
@@ -107,10 +237,39 @@ func JwtCustomClaimsJSONStringToMap(jwtCustomClaimsJSON string) (map[string]inte
 	return jwtCustomClaimsMap, nil
 }
 
+// Parse fills the fields parsed from the options, it's run before Validate which checks the parsed config. Parsing an
+// already parsed config does nothing.
+func (c *Config) Parse() error {
+	if c.parsed {
+		return nil
+	}
+	if err := c.parseMTLS(); err != nil {
+		return err
+	}
+	if err := c.parseThinkTime(); err != nil {
+		return err
+	}
+	if err := c.parseArrival(); err != nil {
+		return err
+	}
+	if err := c.parseAbort(); err != nil {
+		return err
+	}
+	if err := c.parseRetry(); err != nil {
+		return err
+	}
+	c.SendJWT = c.JwtKey != "" || c.JwtsFilename != ""
+	c.parsed = true
+	return nil
+}
+
 func (c *Config) Validate() error {
 	if _, err := url.ParseRequestURI(c.ReqURI); err != nil {
 		return fmt.Errorf("config: invalid request uri, got error %v", err)
 	}
+	if err := c.validateArrival(); err != nil {
+		return err
+	}
 	if int64(c.Conns) > c.ReqTarget && c.Duration == 0 {
 		return errConnLimit
 	}
@@ -125,23 +284,8 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("url not in correct format %s needs to be like protocol://host:port/path i.e. https://localhost:443/some-path", c.ReqURI)
 	}
 
-	if c.MTLSKey != "" {
-		_, err := os.OpenFile(c.MTLSKey, os.O_RDONLY, os.ModePerm)
-		if err != nil {
-			if os.IsNotExist(err) {
-				return errors.New("config: mTLS private key does not exist")
-			}
-			return fmt.Errorf("config: mTLS private key error checking file exists; %v", err)
-		}
-	}
-	if c.MTLSCert != "" {
-		_, err := os.OpenFile(c.MTLSCert, os.O_RDONLY, os.ModePerm)
-		if err != nil {
-			if os.IsNotExist(err) {
-				return errors.New("config: mTLS cert does not exist")
-			}
-			return fmt.Errorf("config: mTLS cert error checking file exists; %v", err)
-		}
+	if err := c.validateMTLS(); err != nil {
+		return err
 	}
 
 	// Require JwtHeader if JwtKey or JwtsFilename is present
@@ -165,7 +309,6 @@ func (c *Config) Validate() error {
 		if c.ReqTarget == 0 {
 			return errors.New("can only send jwts when request number is specified")
 		}
-		c.SendJWT = true
 	}
 
 	if c.JwtsFilename != "" {
@@ -179,7 +322,6 @@ func (c *Config) Validate() error {
 		if c.ReqTarget == 0 {
 			return errors.New("can only send jwts when request number is specified")
 		}
-		c.SendJWT = true
 	}
 
 	if len(c.Headers) > 0 {
@@ -190,20 +332,106 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	for _, r := range c.Resolve {
+		if _, _, err := dialer.ParseOverride(r); err != nil {
+			return fmt.Errorf("config: %v", err)
+		}
+	}
+
+	for _, ip := range c.SourceIPs {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("config: invalid source ip %s", ip)
+		}
+	}
+
 	if len(c.BodyFile) > 0 {
-		_, err := os.OpenFile(c.BodyFile, os.O_RDONLY, os.ModePerm)
+		f, err := os.OpenFile(c.BodyFile, os.O_RDONLY, os.ModePerm)
 		if err != nil {
 			if os.IsNotExist(err) {
 				return errors.New("config: body file does not exist")
 			}
 			return fmt.Errorf("config: body file error checking file exists; %v", err)
 		}
+		f.Close()
+	}
+
+	if c.Handshake {
+		if !strings.HasPrefix(c.ReqURI, "https://") {
+			return errors.New("config: handshake mode requires https request uri")
+		}
+		if c.SendJWT {
+			return errors.New("config: can't send jwts in handshake mode, no HTTP requests are sent")
+		}
+		if c.Parallel {
+			return errors.New("config: can't run parallel in handshake mode")
+		}
+	}
+
+	if err := c.validateTLS(); err != nil {
+		return err
+	}
+
+	if c.Pipeline > 1 {
+		if c.Client != worker.HttpClientFastHTTP1 {
+			return fmt.Errorf("config: can only pipeline requests with %s client", worker.HttpClientFastHTTP1)
+		}
+		if c.Handshake {
+			return errors.New("config: can't pipeline requests in handshake mode")
+		}
+		if c.DisableKeepAlive {
+			return errors.New("config: can't pipeline requests with keep-alive disabled")
+		}
+	}
+
+	if err := c.validateHTTP2(); err != nil {
+		return err
+	}
+
+	if c.tunesQUIC() && c.Client != worker.HttpClientNetHTTP3 {
+		return fmt.Errorf("config: QUIC options can only be used with %s client", worker.HttpClientNetHTTP3)
+	}
+	if c.QUICKeepAlive != 0 && c.QUICIdleTimeout != 0 && c.QUICKeepAlive >= c.QUICIdleTimeout {
+		return errors.New("config: QUIC keep-alive period must be less than the idle timeout")
+	}
+
+	if c.MaxInflight > 0 && !c.Parallel {
+		return errors.New("config: max in-flight only applies when running --parallel")
 	}
 
 	if c.Parallel && (c.Client != worker.HttpClientNetHTTP2 && c.Client != worker.HttpClientNetHTTP3) {
 		return fmt.Errorf("can only run parallel with %s or %s client", worker.HttpClientNetHTTP2, worker.HttpClientNetHTTP3)
 	}
 
+	if err := c.validateConcurrency(); err != nil {
+		return err
+	}
+
+	if err := c.validateThinkTime(); err != nil {
+		return err
+	}
+
+	if err := c.validateWarmup(); err != nil {
+		return err
+	}
+
+	if err := c.validateAbort(); err != nil {
+		return err
+	}
+
+	if err := c.validateRetry(); err != nil {
+		return err
+	}
+
+	if c.GracePeriod < 0 {
+		return errors.New("config: grace period can't be negative")
+	}
+
+	if c.ControlAddr != "" {
+		if _, _, err := net.SplitHostPort(c.ControlAddr); err != nil {
+			return fmt.Errorf("config: invalid control address %s, expected host:port i.e. localhost:9999", c.ControlAddr)
+		}
+	}
+
 	if c.VerboseTicker == 0 {
 		return errors.New("ticker value can't be zero")
 	}
@@ -233,6 +461,333 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// validateConcurrency checks workers sharing connections can be given requests and the connections can take them
+func (c *Config) validateConcurrency() error {
+	if c.Concurrency > 0 && c.VUs > 0 {
+		return errors.New("config: can't set both concurrency and virtual users, each virtual user has a single request in flight")
+	}
+	if !c.SharesConns() {
+		return nil
+	}
+
+	name := "concurrency"
+	if c.VUs > 0 {
+		name = "virtual users"
+	}
+	workers := c.Workers()
+	if int64(workers) > c.ReqTarget && c.ReqTarget != 0 {
+		return fmt.Errorf("config: %s can't be more than requests", name)
+	}
+	if c.Parallel {
+		return fmt.Errorf("config: can't run parallel with %s, %s already bounds the requests in flight", name, name)
+	}
+	if c.Handshake {
+		return fmt.Errorf("config: can't set %s in handshake mode, every handshake uses a new connection", name)
+	}
+	if len(c.MTLSIdentities) > 1 {
+		return fmt.Errorf("config: can't spread multiple mTLS identities with %s, identity results are kept per connection", name)
+	}
+	if c.Pipeline > 1 && workers > c.Conns*c.Pipeline {
+		return fmt.Errorf("config: %s can't be more than connections * pipeline (%d), pipelined connections can't queue more requests", name, c.Conns*c.Pipeline)
+	}
+	return nil
+}
+
+// parseThinkTime parses the think time distribution into ThinkTimeDist
+func (c *Config) parseThinkTime() error {
+	if c.ThinkTime == "" {
+		return nil
+	}
+	t, err := http_clients.ParseThinkTime(c.ThinkTime)
+	if err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+	c.ThinkTimeDist = t
+	return nil
+}
+
+// validateThinkTime checks think times only pause sequential requests
+func (c *Config) validateThinkTime() error {
+	if c.ThinkTimeDist == nil {
+		return nil
+	}
+	if c.Parallel || (c.Pipeline > 1 && !c.SharesConns()) {
+		return errors.New("config: think time only applies to requests sent one at a time, can't run parallel or pipeline")
+	}
+	if c.ReqTarget != 0 && c.Duration != 0 {
+		return errors.New("config: can't set think time with both requests and duration, requests are already paced")
+	}
+	return nil
+}
+
+// parseArrival parses the arrival process into ArrivalProcess, a rate curve sets the requests and by default the
+// duration too
+func (c *Config) parseArrival() error {
+	if c.Arrival == "" {
+		return nil
+	}
+
+	a, err := http_clients.ParseArrival(c.Arrival)
+	if err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+
+	if a.Process == http_clients.ArrivalCSV {
+		if c.ReqTarget != 0 {
+			return errors.New("config: can't set requests with a rate curve, the curve sets the requests sent")
+		}
+		duration := c.Duration
+		if duration == 0 {
+			duration = a.CurveEnd()
+		}
+		if duration == 0 {
+			return errors.New("config: rate curve with a single point needs a duration")
+		}
+		reqs := a.CurveRequests(duration)
+		if reqs == 0 {
+			return errors.New("config: rate curve sends no requests")
+		}
+		c.Duration, c.ReqTarget = duration, reqs
+	}
+
+	c.ArrivalProcess = a
+	return nil
+}
+
+// validateArrival checks the requests can be scheduled over the duration
+func (c *Config) validateArrival() error {
+	if c.ArrivalProcess != nil && (c.ReqTarget == 0 || c.Duration == 0) {
+		return errors.New("config: arrival process needs both requests and duration to schedule requests")
+	}
+	return nil
+}
+
+// validateWarmup checks the warm-up phase can be run with the same workers as the measured run
+func (c *Config) validateWarmup() error {
+	if c.Warmup < 0 || c.WarmupRequests < 0 {
+		return errors.New("config: warm-up can't be negative")
+	}
+	if c.Warmup != 0 && c.WarmupRequests != 0 {
+		return errors.New("config: can't set both warm-up duration and requests")
+	}
+	if c.WarmupRequests != 0 && c.WarmupRequests < int64(c.Workers()) {
+		return errors.New("config: warm-up requests can't be fewer than connections")
+	}
+	if c.Warmup != 0 && c.SendJWT {
+		return errors.New("config: can only send jwts during warm-up with a number of warm-up requests")
+	}
+	return nil
+}
+
+// Warming returns true when a warm-up phase runs before the measured run
+func (c *Config) Warming() bool {
+	return c.Warmup != 0 || c.WarmupRequests != 0
+}
+
+// parseRetry parses the retry conditions into RetryPolicy
+func (c *Config) parseRetry() error {
+	if c.RetryAttempts < 2 || len(c.RetryOn) == 0 {
+		return nil
+	}
+	policy, err := http_clients.ParseRetryOn(c.RetryOn)
+	if err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+	policy.Attempts = int(c.RetryAttempts)
+	policy.Backoff = c.RetryBackoff
+	policy.MaxBackoff = c.RetryMaxBackoff
+	policy.Jitter = c.RetryJitter
+	c.RetryPolicy = policy
+	return nil
+}
+
+// validateRetry checks retry attempts leave room for a retry, have conditions to retry on and a valid backoff
+func (c *Config) validateRetry() error {
+	if c.RetryAttempts == 0 {
+		return nil
+	}
+	if c.RetryAttempts == 1 {
+		return errors.New("config: retry attempts include the first attempt, at least 2 are needed to retry")
+	}
+	if len(c.RetryOn) == 0 {
+		return errors.New("config: retries need response codes or errors to retry on")
+	}
+	if c.RetryBackoff < 0 || c.RetryMaxBackoff < c.RetryBackoff {
+		return fmt.Errorf("config: invalid retry backoff %s up to %s, max backoff can't be less than backoff", c.RetryBackoff, c.RetryMaxBackoff)
+	}
+	if c.RetryJitter < 0 || c.RetryJitter > 1 {
+		return fmt.Errorf("config: invalid retry jitter %v, expected 0 to 1", c.RetryJitter)
+	}
+	return nil
+}
+
+// parseAbort parses the abort error rate percentage into AbortErrorRatio
+func (c *Config) parseAbort() error {
+	if c.AbortErrorRate == "" {
+		return nil
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSuffix(c.AbortErrorRate, "%"), 64)
+	if err != nil || rate <= 0 || rate > 100 {
+		return fmt.Errorf("config: invalid abort error rate %s, expected a percentage above 0 up to 100 i.e. 20%%", c.AbortErrorRate)
+	}
+	c.AbortErrorRatio = rate / 100
+	return nil
+}
+
+// validateAbort checks the abort conditions can be evaluated
+func (c *Config) validateAbort() error {
+	if c.AbortP99 < 0 {
+		return errors.New("config: abort p99 latency can't be negative")
+	}
+	if (c.AbortErrorRatio != 0 || c.AbortP99 != 0) && c.AbortWindow < time.Second {
+		return errors.New("config: abort window must be at least 1s")
+	}
+	return nil
+}
+
+// parseMTLS pairs the mTLS certs with their keys and collects them with those found in MTLSDir into
+// MTLSIdentities, identities are reported by name so duplicate names are replaced by the cert path
+func (c *Config) parseMTLS() error {
+	if len(c.MTLSCerts) != len(c.MTLSKeys) {
+		return fmt.Errorf("config: got %d mTLS certs and %d mTLS keys, each cert needs a key", len(c.MTLSCerts), len(c.MTLSKeys))
+	}
+
+	identities := make([]http_clients.MTLSIdentity, 0, len(c.MTLSCerts))
+	for i := range c.MTLSCerts {
+		identities = append(identities, http_clients.MTLSIdentity{
+			Name: strings.TrimSuffix(filepath.Base(c.MTLSCerts[i]), filepath.Ext(c.MTLSCerts[i])),
+			Cert: c.MTLSCerts[i],
+			Key:  c.MTLSKeys[i],
+		})
+	}
+
+	if c.MTLSDir != "" {
+		fromDir, err := http_clients.MTLSIdentitiesFromDir(c.MTLSDir)
+		if err != nil {
+			return fmt.Errorf("config: mTLS dir error; %v", err)
+		}
+		identities = append(identities, fromDir...)
+	}
+
+	names := make(map[string]bool)
+	for i, id := range identities {
+		if names[id.Name] {
+			identities[i].Name = id.Cert
+		}
+		names[id.Name] = true
+	}
+	c.MTLSIdentities = identities
+	return nil
+}
+
+// validateMTLS checks every mTLS identity's cert/key pair loads
+func (c *Config) validateMTLS() error {
+	for _, id := range c.MTLSIdentities {
+		for _, path := range []string{id.Key, id.Cert} {
+			_, err := os.OpenFile(path, os.O_RDONLY, os.ModePerm)
+			if err != nil {
+				if os.IsNotExist(err) {
+					return errors.New("config: mTLS file does not exist: " + path)
+				}
+				return fmt.Errorf("config: mTLS file error checking file exists; %v", err)
+			}
+		}
+		if _, err := tls.LoadX509KeyPair(id.Cert, id.Key); err != nil {
+			return fmt.Errorf("config: mTLS cert %s and key %s error; %v", id.Cert, id.Key, err)
+		}
+	}
+	return nil
+}
+
+// tunesHTTP2 returns true if any HTTP/2 tuning option is set
+func (c *Config) tunesHTTP2() bool {
+	return c.H2MaxStreams != 0 || c.H2Conns > 1 || c.H2MaxHeaderListSize != 0 || c.H2MaxFrameSize != 0 ||
+		c.H2StreamWindow != 0 || c.H2ConnWindow != 0 || c.H2ReadIdleTimeout != 0 || c.H2PingTimeout != 0
+}
+
+// tunesQUIC returns true if any QUIC option is set
+func (c *Config) tunesQUIC() bool {
+	return c.QUICHandshakeTimeout != 0 || c.QUICIdleTimeout != 0 || c.QUICKeepAlive != 0 ||
+		c.QUICMaxIncomingStreams != 0 || c.QUIC0RTT
+}
+
+func (c *Config) validateHTTP2() error {
+	if !c.tunesHTTP2() {
+		return nil
+	}
+	if c.Client != worker.HttpClientNetHTTP2 || c.Handshake {
+		return fmt.Errorf("config: HTTP/2 options can only be used with %s client", worker.HttpClientNetHTTP2)
+	}
+	if c.H2MaxFrameSize != 0 && (c.H2MaxFrameSize < 1<<14 || c.H2MaxFrameSize > 1<<24-1) {
+		return fmt.Errorf("config: HTTP/2 max frame size %d must be between %d and %d", c.H2MaxFrameSize, 1<<14, 1<<24-1)
+	}
+	if c.H2StreamWindow != 0 && (c.H2StreamWindow < nethttp.MinH2StreamWindow || c.H2StreamWindow > nethttp.MaxH2StreamWindow) {
+		return fmt.Errorf("config: HTTP/2 stream window %d must be between %d and %d", c.H2StreamWindow, nethttp.MinH2StreamWindow, nethttp.MaxH2StreamWindow)
+	}
+	if c.H2ConnWindow != 0 && (c.H2ConnWindow < nethttp.MinH2ConnWindow || c.H2ConnWindow > nethttp.MaxH2ConnWindow) {
+		return fmt.Errorf("config: HTTP/2 connection window %d must be between %d and %d", c.H2ConnWindow, nethttp.MinH2ConnWindow, nethttp.MaxH2ConnWindow)
+	}
+	if c.H2PingTimeout != 0 && c.H2ReadIdleTimeout == 0 {
+		return errors.New("config: HTTP/2 ping timeout needs a read idle timeout to send pings")
+	}
+	return nil
+}
+
+func (c *Config) validateTLS() error {
+	if c.CACert != "" {
+		if _, err := http_clients.LoadCACert(c.CACert); err != nil {
+			return fmt.Errorf("config: CA cert error; %v", err)
+		}
+	}
+
+	var min, max uint16
+	var err error
+	if c.TLSMin != "" {
+		if min, err = http_clients.ParseTLSVersion(c.TLSMin); err != nil {
+			return fmt.Errorf("config: %v", err)
+		}
+	}
+	if c.TLSMax != "" {
+		if max, err = http_clients.ParseTLSVersion(c.TLSMax); err != nil {
+			return fmt.Errorf("config: %v", err)
+		}
+	}
+	if min != 0 && max != 0 && min > max {
+		return fmt.Errorf("config: min TLS version %s is greater than max TLS version %s", c.TLSMin, c.TLSMax)
+	}
+
+	if _, err := http_clients.ParseCipherSuites(c.TLSCiphers); err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+	if _, err := http_clients.ParseCurves(c.TLSCurves); err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+	return c.validateALPN()
+}
+
+// clientALPN are the ALPN protocols each client can speak once negotiated
+var clientALPN = map[string]string{
+	worker.HttpClientFastHTTP1: "http/1.1",
+	worker.HttpClientNetHTTP:   "http/1.1",
+	worker.HttpClientNetHTTP2:  "h2",
+	worker.HttpClientNetHTTP3:  "h3",
+}
+
+// validateALPN rejects ALPN protocols the client can't speak, the server could negotiate them. Any protocol can be
+// offered in handshake mode as no requests are sent.
+func (c *Config) validateALPN() error {
+	want, ok := clientALPN[c.Client]
+	if !ok || c.Handshake {
+		return nil
+	}
+	for _, p := range c.ALPN {
+		if p != want {
+			return fmt.Errorf("config: %s client can't speak ALPN protocol %s, only %s", c.Client, p, want)
+		}
+	}
+	return nil
+}
+
 func methodAllowed(method string) bool {
 	for _, m := range allowedMethods {
 		if method == m {
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfig_parseRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       Config
+		wantAttempts int
+		err          string
+	}{
+		{name: "disabled", config: Config{RetryOn: []string{"503"}}},
+		{name: "no conditions", config: Config{RetryAttempts: 3}},
+		{
+			name:         "attempts",
+			config:       Config{RetryAttempts: 3, RetryOn: []string{"503", "reset"}, RetryBackoff: time.Millisecond, RetryMaxBackoff: time.Second},
+			wantAttempts: 3,
+		},
+		{name: "invalid condition", config: Config{RetryAttempts: 3, RetryOn: []string{"teapot"}}, err: "config: retry: invalid condition teapot, expected a response code, 5xx, reset or timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.config
+			err := c.parseRetry()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("parseRetry() error = %v, wanted %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRetry() error = %v, wanted no error", err)
+			}
+			if tt.wantAttempts == 0 {
+				if c.RetryPolicy != nil {
+					t.Errorf("wanted no retry policy got %+v", c.RetryPolicy)
+				}
+				return
+			}
+			if c.RetryPolicy == nil || c.RetryPolicy.Attempts != tt.wantAttempts || c.RetryPolicy.Backoff != c.RetryBackoff || c.RetryPolicy.MaxBackoff != c.RetryMaxBackoff {
+				t.Errorf("wanted a policy of %d attempts backing off from %s up to %s got %+v", tt.wantAttempts, c.RetryBackoff, c.RetryMaxBackoff, c.RetryPolicy)
+			}
+		})
+	}
+}
+
+func TestConfig_validateRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		err    string
+	}{
+		{name: "disabled", config: Config{}},
+		{name: "valid", config: Config{RetryAttempts: 2, RetryOn: []string{"503"}, RetryBackoff: time.Millisecond, RetryMaxBackoff: time.Second, RetryJitter: 1}},
+		{name: "one attempt", config: Config{RetryAttempts: 1, RetryOn: []string{"503"}}, err: "config: retry attempts include the first attempt, at least 2 are needed to retry"},
+		{name: "no conditions", config: Config{RetryAttempts: 2}, err: "config: retries need response codes or errors to retry on"},
+		{
+			name:   "max backoff below backoff",
+			config: Config{RetryAttempts: 2, RetryOn: []string{"503"}, RetryBackoff: time.Second, RetryMaxBackoff: time.Millisecond},
+			err:    "config: invalid retry backoff 1s up to 1ms, max backoff can't be less than backoff",
+		},
+		{
+			name:   "jitter above 1",
+			config: Config{RetryAttempts: 2, RetryOn: []string{"503"}, RetryMaxBackoff: time.Second, RetryJitter: 1.5},
+			err:    "config: invalid retry jitter 1.5, expected 0 to 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validateRetry()
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("validateRetry() error = %v, wanted no error", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("validateRetry() error = %v, wanted %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestConfig_parseAbort(t *testing.T) {
+	tests := []struct {
+		name      string
+		rate      string
+		wantRatio float64
+		err       string
+	}{
+		{name: "disabled"},
+		{name: "percentage", rate: "20%", wantRatio: 0.2},
+		{name: "without percent sign", rate: "2.5", wantRatio: 0.025},
+		{name: "all requests", rate: "100%", wantRatio: 1},
+		{name: "zero", rate: "0%", err: "config: invalid abort error rate 0%, expected a percentage above 0 up to 100 i.e. 20%"},
+		{name: "above 100", rate: "101%", err: "config: invalid abort error rate 101%, expected a percentage above 0 up to 100 i.e. 20%"},
+		{name: "not a number", rate: "lots", err: "config: invalid abort error rate lots, expected a percentage above 0 up to 100 i.e. 20%"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{AbortErrorRate: tt.rate}
+			err := c.parseAbort()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("parseAbort() error = %v, wanted %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAbort() error = %v, wanted no error", err)
+			}
+			if c.AbortErrorRatio != tt.wantRatio {
+				t.Errorf("wanted abort error ratio %v got %v", tt.wantRatio, c.AbortErrorRatio)
+			}
+		})
+	}
+}
+
+func TestConfig_validateAbort(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		err    string
+	}{
+		{name: "disabled"},
+		{name: "error rate", config: Config{AbortErrorRatio: 0.2, AbortWindow: 10 * time.Second}},
+		{name: "p99", config: Config{AbortP99: time.Second, AbortWindow: time.Second}},
+		{name: "error count without window", config: Config{AbortAfterErrors: 10}},
+		{name: "negative p99", config: Config{AbortP99: -time.Second, AbortWindow: time.Second}, err: "config: abort p99 latency can't be negative"},
+		{name: "error rate window too short", config: Config{AbortErrorRatio: 0.2, AbortWindow: 500 * time.Millisecond}, err: "config: abort window must be at least 1s"},
+		{name: "p99 window too short", config: Config{AbortP99: time.Second}, err: "config: abort window must be at least 1s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validateAbort()
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("validateAbort() error = %v, wanted no error", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("validateAbort() error = %v, wanted %s", err, tt.err)
+			}
+		})
+	}
+}
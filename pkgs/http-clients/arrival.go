@@ -0,0 +1,231 @@
+package http_clients
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ArrivalUniform = "uniform"
+	ArrivalPoisson = "poisson"
+	ArrivalBursty  = "bursty"
+	ArrivalCSV     = "csv"
+)
+
+// Arrival is the process requests are scheduled by when a number of requests are sent over a duration
+type Arrival struct {
+	Process string
+	// On and Off are the lengths of bursts and the pauses between them
+	On  time.Duration
+	Off time.Duration
+	// Curve is the request rate over time, each rate holds until the next point's timestamp
+	Curve []RatePoint
+}
+
+// RatePoint is the requests per second sent from At
+type RatePoint struct {
+	At  time.Duration
+	RPS float64
+}
+
+// ParseArrival parses an arrival process in the format uniform, poisson, bursty:<on>,<off> i.e. bursty:1s,4s or
+// csv:<path> to a file of timestamp,rps rows
+func ParseArrival(spec string) (*Arrival, error) {
+	process, params, _ := strings.Cut(spec, ":")
+
+	switch process {
+	case ArrivalUniform, ArrivalPoisson:
+		if params != "" {
+			return nil, fmt.Errorf("arrival: %s takes no parameters, got %s", process, params)
+		}
+		return &Arrival{Process: process}, nil
+	case ArrivalBursty:
+		on, off, found := strings.Cut(params, ",")
+		if !found {
+			return nil, fmt.Errorf("arrival: bursty needs on and off durations i.e. bursty:1s,4s, got %s", spec)
+		}
+		a := &Arrival{Process: process}
+		var err error
+		if a.On, err = time.ParseDuration(on); err != nil || a.On <= 0 {
+			return nil, fmt.Errorf("arrival: invalid bursty on duration %s", on)
+		}
+		if a.Off, err = time.ParseDuration(off); err != nil || a.Off < 0 {
+			return nil, fmt.Errorf("arrival: invalid bursty off duration %s", off)
+		}
+		return a, nil
+	case ArrivalCSV:
+		curve, err := LoadRateCurve(params)
+		if err != nil {
+			return nil, err
+		}
+		return &Arrival{Process: process, Curve: curve}, nil
+	}
+	return nil, fmt.Errorf("arrival: unknown process %s, expected one of %s, %s, %s:<on>,<off>, %s:<path>", process,
+		ArrivalUniform, ArrivalPoisson, ArrivalBursty, ArrivalCSV)
+}
+
+// LoadRateCurve reads timestamp,rps rows from a CSV file, timestamps are seconds or durations from the start and must
+// increase from 0. A header row is skipped.
+func LoadRateCurve(path string) ([]RatePoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("arrival: failed to open rate curve; %v", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+
+	var curve []RatePoint
+	for row := 1; ; row++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("arrival: failed to read rate curve %s; %v", path, err)
+		}
+
+		p, err := parseRatePoint(record)
+		if err != nil {
+			if row == 1 {
+				// header
+				continue
+			}
+			return nil, fmt.Errorf("arrival: rate curve %s row %d; %v", path, row, err)
+		}
+		if len(curve) == 0 && p.At != 0 {
+			return nil, fmt.Errorf("arrival: rate curve %s must start at 0s, starts at %s", path, p.At)
+		}
+		if len(curve) > 0 && p.At <= curve[len(curve)-1].At {
+			return nil, fmt.Errorf("arrival: rate curve %s row %d; timestamp %s doesn't increase", path, row, p.At)
+		}
+		curve = append(curve, p)
+	}
+
+	if len(curve) == 0 {
+		return nil, fmt.Errorf("arrival: rate curve %s is empty", path)
+	}
+	return curve, nil
+}
+
+func parseRatePoint(record []string) (RatePoint, error) {
+	var p RatePoint
+	at, err := time.ParseDuration(record[0])
+	if err != nil {
+		secs, err := strconv.ParseFloat(record[0], 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid timestamp %s", record[0])
+		}
+		at = time.Duration(secs * float64(time.Second))
+	}
+	rps, err := strconv.ParseFloat(record[1], 64)
+	if err != nil || rps < 0 {
+		return p, fmt.Errorf("invalid rps %s", record[1])
+	}
+	return RatePoint{At: at, RPS: rps}, nil
+}
+
+// CurveEnd is where the rate curve ends when no duration is given, the last point's timestamp
+func (a *Arrival) CurveEnd() time.Duration {
+	return a.Curve[len(a.Curve)-1].At
+}
+
+// CurveRequests returns the number of requests the rate curve sends over d
+func (a *Arrival) CurveRequests(d time.Duration) int64 {
+	return int64(math.Round(a.curveTotal(d)))
+}
+
+func (a *Arrival) curveTotal(d time.Duration) float64 {
+	var total float64
+	for _, s := range a.segments(d) {
+		total += s.rps * (s.end - s.start).Seconds()
+	}
+	return total
+}
+
+type rateSegment struct {
+	start, end time.Duration
+	rps        float64
+}
+
+func (a *Arrival) segments(d time.Duration) []rateSegment {
+	segments := make([]rateSegment, 0, len(a.Curve))
+	for i, p := range a.Curve {
+		if p.At >= d {
+			break
+		}
+		end := d
+		if i+1 < len(a.Curve) && a.Curve[i+1].At < d {
+			end = a.Curve[i+1].At
+		}
+		segments = append(segments, rateSegment{start: p.At, end: end, rps: p.RPS})
+	}
+	return segments
+}
+
+// Schedule returns a func returning the offset from the start of each of n requests sent over d in order
+func (a *Arrival) Schedule(n int64, d time.Duration, r *rand.Rand) func() time.Duration {
+	var k int64
+
+	switch a.Process {
+	case ArrivalPoisson:
+		// a Poisson process with n arrivals over d places them uniformly at random, so they're drawn as ascending
+		// order statistics of n uniforms, each the minimum of the remaining uniforms over what's left of d
+		var v float64
+		return func() time.Duration {
+			k++
+			v += (1 - v) * (1 - math.Pow(1-r.Float64(), 1/float64(n-k+1)))
+			return time.Duration(v * float64(d))
+		}
+	case ArrivalBursty:
+		// spread evenly over the time spent in bursts then mapped back onto the bursts
+		cycle := a.On + a.Off
+		onTotal := (d/cycle)*a.On + min(d%cycle, a.On)
+		return func() time.Duration {
+			s := time.Duration(float64(onTotal) * float64(k) / float64(n))
+			k++
+			return (s/a.On)*cycle + s%a.On
+		}
+	case ArrivalCSV:
+		// spread evenly over the requests the curve sends, each placed where the curve reaches its share
+		segments := a.segments(d)
+		total := a.curveTotal(d)
+		var i int
+		var sent float64
+		return func() time.Duration {
+			k++
+			target := total * float64(k) / float64(n)
+			for i < len(segments)-1 {
+				s := segments[i]
+				next := sent + s.rps*(s.end-s.start).Seconds()
+				if s.rps > 0 && next >= target {
+					break
+				}
+				sent = next
+				i++
+			}
+			s := segments[i]
+			if s.rps == 0 {
+				return s.end
+			}
+			at := s.start + time.Duration((target-sent)/s.rps*float64(time.Second))
+			return min(at, s.end)
+		}
+	}
+
+	return func() time.Duration {
+		k++
+		return time.Duration(float64(d) * float64(k) / float64(n))
+	}
+}
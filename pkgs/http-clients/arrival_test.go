@@ -0,0 +1,181 @@
+package http_clients
+
+import (
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCurve writes a rate curve file with rows
+func writeCurve(t *testing.T, rows ...string) string {
+	path := filepath.Join(t.TempDir(), "curve.csv")
+	if err := os.WriteFile(path, []byte(strings.Join(rows, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseArrival(t *testing.T) {
+	curve := writeCurve(t, "0,10", "5s,20")
+
+	tests := []struct {
+		name string
+		spec string
+		want *Arrival
+		err  string
+	}{
+		{name: "uniform", spec: "uniform", want: &Arrival{Process: ArrivalUniform}},
+		{name: "poisson", spec: "poisson", want: &Arrival{Process: ArrivalPoisson}},
+		{name: "bursty", spec: "bursty:1s,4s", want: &Arrival{Process: ArrivalBursty, On: time.Second, Off: 4 * time.Second}},
+		{name: "bursty without pauses", spec: "bursty:1s,0s", want: &Arrival{Process: ArrivalBursty, On: time.Second}},
+		{name: "csv", spec: "csv:" + curve, want: &Arrival{Process: ArrivalCSV, Curve: []RatePoint{{RPS: 10}, {At: 5 * time.Second, RPS: 20}}}},
+		{name: "parameters not taken", spec: "poisson:10", err: "arrival: poisson takes no parameters, got 10"},
+		{name: "bursty without off", spec: "bursty:1s", err: "arrival: bursty needs on and off durations i.e. bursty:1s,4s, got bursty:1s"},
+		{name: "bursty zero on", spec: "bursty:0s,1s", err: "arrival: invalid bursty on duration 0s"},
+		{name: "bursty negative off", spec: "bursty:1s,-1s", err: "arrival: invalid bursty off duration -1s"},
+		{name: "unknown", spec: "gaussian", err: "arrival: unknown process gaussian, expected one of uniform, poisson, bursty:<on>,<off>, csv:<path>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseArrival(tt.spec)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("ParseArrival() error = %v, wanted %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseArrival() error = %v, wanted no error", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseArrival() = %+v, wanted %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRateCurve(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []string
+		want []RatePoint
+		err  string
+	}{
+		{
+			name: "header, comments, seconds and durations",
+			rows: []string{"timestamp,rps", "# ramp up", "0, 10", "1.5,20", "1m,0"},
+			want: []RatePoint{{RPS: 10}, {At: 1500 * time.Millisecond, RPS: 20}, {At: time.Minute}},
+		},
+		{name: "empty", rows: []string{"timestamp,rps"}, err: "is empty"},
+		{name: "doesn't start at 0", rows: []string{"1s,10"}, err: "must start at 0s, starts at 1s"},
+		{name: "timestamp doesn't increase", rows: []string{"0,10", "2s,10", "2s,20"}, err: "row 3; timestamp 2s doesn't increase"},
+		{name: "negative rps", rows: []string{"0,10", "1s,-5"}, err: "row 2; invalid rps -5"},
+		{name: "invalid timestamp", rows: []string{"0,10", "soon,5"}, err: "row 2; invalid timestamp soon"},
+		{name: "wrong number of fields", rows: []string{"0,10,20"}, err: "failed to read rate curve"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadRateCurve(writeCurve(t, tt.rows...))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("LoadRateCurve() error = %v, wanted %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadRateCurve() error = %v, wanted no error", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadRateCurve() = %+v, wanted %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadRateCurve(filepath.Join(t.TempDir(), "missing.csv")); err == nil || !strings.Contains(err.Error(), "arrival: failed to open rate curve") {
+		t.Errorf("LoadRateCurve() error = %v, wanted open error", err)
+	}
+}
+
+func TestArrival_Schedule(t *testing.T) {
+	// 10 rps for 2s, a 2s pause then 30 rps for 1s
+	curve := &Arrival{Process: ArrivalCSV, Curve: []RatePoint{{RPS: 10}, {At: 2 * time.Second}, {At: 4 * time.Second, RPS: 30}}}
+
+	tests := []struct {
+		name    string
+		arrival *Arrival
+		n       int64
+		d       time.Duration
+		// within returns true if request k may be scheduled at the offset
+		within func(k int64, at time.Duration) bool
+	}{
+		{
+			name:    "uniform",
+			arrival: &Arrival{Process: ArrivalUniform},
+			n:       10,
+			d:       time.Second,
+			within: func(k int64, at time.Duration) bool {
+				return at == time.Duration(k)*100*time.Millisecond
+			},
+		},
+		{
+			name:    "poisson",
+			arrival: &Arrival{Process: ArrivalPoisson},
+			n:       1000,
+			d:       time.Second,
+			within: func(k int64, at time.Duration) bool {
+				return at >= 0 && at <= time.Second
+			},
+		},
+		{
+			name:    "bursty",
+			arrival: &Arrival{Process: ArrivalBursty, On: 100 * time.Millisecond, Off: 400 * time.Millisecond},
+			n:       100,
+			d:       time.Second,
+			within: func(k int64, at time.Duration) bool {
+				return at%(500*time.Millisecond) < 100*time.Millisecond
+			},
+		},
+		{
+			name:    "csv",
+			arrival: curve,
+			n:       curve.CurveRequests(5 * time.Second),
+			d:       5 * time.Second,
+			within: func(k int64, at time.Duration) bool {
+				if k <= 20 {
+					return at > 0 && at <= 2*time.Second
+				}
+				return at > 4*time.Second && at <= 5*time.Second
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := tt.arrival.Schedule(tt.n, tt.d, rand.New(rand.NewPCG(1, 2)))
+			var last time.Duration
+			for k := int64(1); k <= tt.n; k++ {
+				at := next()
+				if at < last {
+					t.Fatalf("request %d scheduled at %s before the previous request at %s", k, at, last)
+				}
+				if !tt.within(k, at) {
+					t.Fatalf("request %d of %d over %s scheduled at %s", k, tt.n, tt.d, at)
+				}
+				last = at
+			}
+		})
+	}
+
+	if got := curve.CurveRequests(5 * time.Second); got != 50 {
+		t.Errorf("CurveRequests() = %d, wanted 50", got)
+	}
+	if got := curve.CurveEnd(); got != 4*time.Second {
+		t.Errorf("CurveEnd() = %s, wanted 4s", got)
+	}
+}
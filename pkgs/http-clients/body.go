@@ -0,0 +1,59 @@
+package http_clients
+
+import (
+	"io"
+	"os"
+)
+
+// StreamBodyOver is the size above which body files are streamed from disk rather than read into memory
+const StreamBodyOver = 1 << 20
+
+// BodyFile is a request body file opened once for the run and shared by every worker. Files over StreamBodyOver are
+// kept open and streamed, otherwise Body holds the file read into memory.
+type BodyFile struct {
+	Body []byte
+	Size int64
+	file *os.File
+}
+
+func OpenBodyFile(path string) (*BodyFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if stat.Size() > StreamBodyOver {
+		return &BodyFile{Size: stat.Size(), file: f}, nil
+	}
+
+	defer f.Close()
+	body, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return &BodyFile{Body: body, Size: int64(len(body))}, nil
+}
+
+// Streamed is true if the body is streamed from the file rather than held in Body
+func (b *BodyFile) Streamed() bool {
+	return b.file != nil
+}
+
+// Open returns a reader of the streamed body from the start, readers share the file as ReadAt is safe for concurrent
+// use
+func (b *BodyFile) Open() io.Reader {
+	return io.NewSectionReader(b.file, 0, b.Size)
+}
+
+// Close closes the file once no requests are streaming it
+func (b *BodyFile) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	return b.file.Close()
+}
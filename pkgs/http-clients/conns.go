@@ -0,0 +1,75 @@
+package http_clients
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrConnsClosed is returned when dialing once the client's connections were closed, transports retrying requests
+// over new connections would otherwise leave them open
+var ErrConnsClosed = errors.New("connections closed")
+
+// Conns tracks the connections a client dialed so they can be closed with requests still in flight, transports only
+// close idle connections. Connections stop being tracked once closed.
+type Conns struct {
+	lock   sync.Mutex
+	conns  map[*trackedConn]struct{}
+	closed bool
+}
+
+type trackedConn struct {
+	net.Conn
+	conns *Conns
+}
+
+func (c *trackedConn) Close() error {
+	c.conns.lock.Lock()
+	delete(c.conns.conns, c)
+	c.conns.lock.Unlock()
+	return c.Conn.Close()
+}
+
+// RemoteIP returns the IP of a connection's remote address, empty if there was no connection
+func RemoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	ip, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return ip
+}
+
+func NewConns() *Conns {
+	return &Conns{conns: make(map[*trackedConn]struct{})}
+}
+
+// Track tracks conn until it's closed, the returned connection must be used in its place. conn is closed straight away
+// if Close was called.
+func (c *Conns) Track(conn net.Conn) (net.Conn, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		conn.Close()
+		return nil, ErrConnsClosed
+	}
+
+	t := &trackedConn{Conn: conn, conns: c}
+	c.conns[t] = struct{}{}
+	return t, nil
+}
+
+// Close closes every tracked connection, failing requests in flight over them, and any connection dialed after
+func (c *Conns) Close() {
+	c.lock.Lock()
+	conns := c.conns
+	c.conns = make(map[*trackedConn]struct{})
+	c.closed = true
+	c.lock.Unlock()
+
+	for conn := range conns {
+		_ = conn.Conn.Close()
+	}
+}
@@ -2,20 +2,33 @@ package http_clients
 
 import (
 	"context"
+	"crypto/tls"
+	"github.com/domsolutions/gopayloader/pkgs/http-clients/dialer"
+	"github.com/domsolutions/gopayloader/pkgs/payloader/histogram"
+	"io"
 	"sync"
 	"time"
 )
 
 type Request interface {
 	SetHeader(key, val string)
+	Header(key string) string
 	SetBody(body []byte)
+	// SetBodyStream streams a body of size bytes rather than buffering it, open returns a reader of the body from the
+	// start each time the request is sent
+	SetBodyStream(open func() io.Reader, size int64)
 	Size() int64
 }
 
 type Response interface {
 	StatusCode() int
+	Header(key string) string
 	Size() int64
 	Close()
+	// Release returns the response to the client's pool once closed, it mustn't be used after
+	Release()
+	// RemoteIP is the IP of the connection the request was sent over, empty if it didn't get a connection
+	RemoteIP() string
 }
 
 type GoPayLoaderClient interface {
@@ -26,17 +39,22 @@ type GoPayLoaderClient interface {
 	HTTP2() bool
 }
 
+// Pipeliner is implemented by clients which pipeline requests over a connection
+type Pipeliner interface {
+	PendingRequests() int
+}
+
 type Config struct {
 	ReqURI            string
 	DisableKeepAlive  bool
 	SkipVerify        bool
 	MTLSKey           string
 	MTLSCert          string
+	MTLSName          string
 	ReqTarget         int64
 	Ctx               context.Context
 	StartTrigger      *sync.WaitGroup
 	Until             time.Duration
-	ReqEvery          time.Duration
 	ReadTimeout       time.Duration
 	WriteTimeout      time.Duration
 	Method            string
@@ -45,12 +63,67 @@ type Config struct {
 	JWTHeader         string
 	Headers           []string
 	Body              string
-	BodyFile          string
+	BodyFile          *BodyFile
 	NetHTTP           bool
 	HTTPV3            bool
-	ReqStats          chan<- time.Duration
-	Client            string
-	Parallel          bool
+	// ReqStats records the latency of completed requests, ErrStats how long failed requests took to fail and RespSizes
+	// the size of responses. They're shared by a shard of workers and merged when stats are needed.
+	ReqStats               *histogram.Atomic
+	ErrStats               *histogram.Atomic
+	RespSizes              *histogram.Atomic
+	Client                 string
+	Parallel               bool
+	Pipeline               int
+	Handshake              bool
+	SessionResumption      bool
+	OnTLSHandshake         func(state tls.ConnectionState)
+	Dialer                 *dialer.Dialer
+	CACert                 string
+	SNI                    string
+	TLSMin                 string
+	TLSMax                 string
+	TLSCiphers             []string
+	TLSCurves              []string
+	ALPN                   []string
+	H2MaxStreams           int
+	H2Conns                int
+	H2MaxHeaderListSize    uint32
+	H2MaxFrameSize         uint32
+	H2StreamWindow         uint32
+	H2ConnWindow           uint32
+	H2ReadIdleTimeout      time.Duration
+	H2PingTimeout          time.Duration
+	QUICHandshakeTimeout   time.Duration
+	QUICIdleTimeout        time.Duration
+	QUICKeepAlive          time.Duration
+	QUICMaxIncomingStreams int64
+	QUIC0RTT               bool
+	MaxRedirects           int
+	OnQUICHandshake        func(took time.Duration, used0RTT bool)
+	OnQUICPathChange       func()
+	Shared                 bool
+	MaxInflight            int
+	ThinkTime              *ThinkTime
+	Arrival                *Arrival
+	Retry                  *RetryPolicy
+	HonorRetryAfter        bool
+	// Gate and WorkerID are set when the run is controlled through the control API
+	Gate     *Gate
+	WorkerID int
+	// Warmup is set when the worker warms up its connections before the measured run
+	Warmup *Warmup
+	// HTTPClient sends the requests in place of a client built for Client when set
+	HTTPClient GoPayLoaderClient
+}
+
+// Warmup is the warm-up phase a worker runs over the measured run's connections, sending ReqTarget requests or for
+// Until as fast as possible. Workers mark Done once their warm-up requests complete and wait on Measure while their
+// stats are reset.
+type Warmup struct {
+	ReqTarget int64
+	Until     time.Duration
+	Done      *sync.WaitGroup
+	Measure   *sync.WaitGroup
 }
 
 func (c *Config) ReqLimitedOnly() bool {
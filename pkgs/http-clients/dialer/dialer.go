@@ -0,0 +1,263 @@
+package dialer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/quic-go/quic-go"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrSourceAddrUnavailable is returned when the OS can't bind to the source address, usually because it has run out of
+// ephemeral ports
+var ErrSourceAddrUnavailable = errors.New("source address unavailable, out of ephemeral ports or not a local address")
+
+// IPStats are the connections made to a single resolved IP
+type IPStats struct {
+	Conns      int64
+	DialErrors int64
+}
+
+type ipCounter struct {
+	conns      atomic.Int64
+	dialErrors atomic.Int64
+}
+
+type target struct {
+	ips  []string
+	next atomic.Uint64
+}
+
+// Resolver resolves host:port addresses once per run, applying user overrides, and spreads connections round-robin
+// across the resolved IPs. Safe for concurrent use, one Resolver is shared by all workers.
+type Resolver struct {
+	overrides map[string][]string
+	resolver  *net.Resolver
+	lock      *sync.Mutex
+	targets   map[string]*target
+	stats     *sync.Map
+}
+
+// NewResolver creates a Resolver from curl style host:port:ip[,ip...] overrides and an optional DNS server address
+// used for hosts without an override
+func NewResolver(overrides []string, resolverAddr string) (*Resolver, error) {
+	r := &Resolver{
+		overrides: make(map[string][]string),
+		resolver:  net.DefaultResolver,
+		lock:      &sync.Mutex{},
+		targets:   make(map[string]*target),
+		stats:     &sync.Map{},
+	}
+
+	for _, o := range overrides {
+		addr, ips, err := ParseOverride(o)
+		if err != nil {
+			return nil, err
+		}
+		r.overrides[addr] = append(r.overrides[addr], ips...)
+	}
+
+	if resolverAddr != "" {
+		if _, _, err := net.SplitHostPort(resolverAddr); err != nil {
+			resolverAddr = net.JoinHostPort(resolverAddr, "53")
+		}
+		d := &net.Dialer{}
+		r.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, network, resolverAddr)
+			},
+		}
+	}
+
+	return r, nil
+}
+
+// ParseOverride parses host:port:ip[,ip...] returning host:port and the IPs
+func ParseOverride(override string) (string, []string, error) {
+	parts := strings.SplitN(override, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", nil, fmt.Errorf("dialer: resolve %s not in format host:port:ip[,ip...]", override)
+	}
+
+	ips := make([]string, 0)
+	for _, ip := range strings.Split(parts[2], ",") {
+		ip = strings.Trim(strings.TrimSpace(ip), "[]")
+		if net.ParseIP(ip) == nil {
+			return "", nil, fmt.Errorf("dialer: resolve %s contains invalid ip %s", override, ip)
+		}
+		ips = append(ips, ip)
+	}
+	return net.JoinHostPort(parts[0], parts[1]), ips, nil
+}
+
+func (r *Resolver) lookup(ctx context.Context, addr string) (*target, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if t, ok := r.targets[addr]; ok {
+		return t, nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, ok := r.overrides[addr]
+	if !ok {
+		if ip := net.ParseIP(host); ip != nil {
+			ips = []string{host}
+		} else {
+			ips, err = r.resolver.LookupHost(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	t := &target{ips: make([]string, 0, len(ips))}
+	for _, ip := range ips {
+		t.ips = append(t.ips, net.JoinHostPort(ip, port))
+	}
+	r.targets[addr] = t
+	return t, nil
+}
+
+// Pick returns the next ip:port to connect to for addr
+func (r *Resolver) Pick(ctx context.Context, addr string) (string, error) {
+	t, err := r.lookup(ctx, addr)
+	if err != nil {
+		return "", err
+	}
+	if len(t.ips) == 0 {
+		return "", fmt.Errorf("dialer: no ips found for %s", addr)
+	}
+	return t.ips[(t.next.Add(1)-1)%uint64(len(t.ips))], nil
+}
+
+func (r *Resolver) record(ipPort string, err error) {
+	ip, _, splitErr := net.SplitHostPort(ipPort)
+	if splitErr != nil {
+		ip = ipPort
+	}
+	val, _ := r.stats.LoadOrStore(ip, &ipCounter{})
+	if err != nil {
+		val.(*ipCounter).dialErrors.Add(1)
+		return
+	}
+	val.(*ipCounter).conns.Add(1)
+}
+
+// Stats returns connection stats keyed by IP
+func (r *Resolver) Stats() map[string]IPStats {
+	stats := make(map[string]IPStats)
+	r.stats.Range(func(key, value any) bool {
+		c := value.(*ipCounter)
+		stats[key.(string)] = IPStats{Conns: c.conns.Load(), DialErrors: c.dialErrors.Load()}
+		return true
+	})
+	return stats
+}
+
+// Dialer dials connections through a shared Resolver, one Dialer is created per worker
+type Dialer struct {
+	resolver *Resolver
+	dialer   *net.Dialer
+	sourceIP net.IP
+}
+
+// New creates a Dialer, connections are bound to sourceIP if not nil
+func New(resolver *Resolver, timeout time.Duration, sourceIP net.IP) *Dialer {
+	d := &Dialer{
+		resolver: resolver,
+		dialer:   &net.Dialer{Timeout: timeout},
+		sourceIP: sourceIP,
+	}
+	if sourceIP != nil {
+		d.dialer.LocalAddr = &net.TCPAddr{IP: sourceIP}
+	}
+	return d
+}
+
+// socketErr replaces socket exhaustion errors, which contain the ports, with a consistent error so they're grouped
+// together in results
+func (d *Dialer) socketErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	var errno string
+	switch {
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		errno = "EADDRNOTAVAIL"
+	case errors.Is(err, syscall.EADDRINUSE):
+		errno = "EADDRINUSE"
+	default:
+		return err
+	}
+
+	source := "default"
+	if d.sourceIP != nil {
+		source = d.sourceIP.String()
+	}
+	return fmt.Errorf("dial: source ip %s; %w (%s)", source, ErrSourceAddrUnavailable, errno)
+}
+
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	ipPort, err := d.resolver.Pick(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.dialer.DialContext(ctx, network, ipPort)
+	d.resolver.record(ipPort, err)
+	return conn, d.socketErr(err)
+}
+
+// Dial is a fasthttp.DialFunc
+func (d *Dialer) Dial(addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), "tcp", addr)
+}
+
+// DialQUIC is an http3.Transport Dial func
+func (d *Dialer) DialQUIC(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	ipPort, err := d.resolver.Pick(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.dialQUIC(ctx, ipPort, tlsCfg, cfg)
+	d.resolver.record(ipPort, err)
+	return conn, d.socketErr(err)
+}
+
+func (d *Dialer) dialQUIC(ctx context.Context, ipPort string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	if d.sourceIP == nil {
+		return quic.DialAddrEarly(ctx, ipPort, tlsCfg, cfg)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", ipPort)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: d.sourceIP})
+	if err != nil {
+		return nil, err
+	}
+	transport := &quic.Transport{Conn: udpConn}
+	conn, err := transport.DialEarly(ctx, addr, tlsCfg, cfg)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	go func() {
+		// transport owns the UDP socket, free it once the connection is closed
+		<-conn.Context().Done()
+		transport.Close()
+	}()
+	return conn, nil
+}
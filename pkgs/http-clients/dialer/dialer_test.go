@@ -0,0 +1,180 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		override string
+		wantAddr string
+		wantIPs  []string
+		err      string
+	}{
+		{name: "single ip", override: "example.com:443:10.0.0.1", wantAddr: "example.com:443", wantIPs: []string{"10.0.0.1"}},
+		{name: "multiple ips", override: "example.com:443:10.0.0.1, 10.0.0.2", wantAddr: "example.com:443", wantIPs: []string{"10.0.0.1", "10.0.0.2"}},
+		{name: "ipv6", override: "example.com:443:[::1],10.0.0.1", wantAddr: "example.com:443", wantIPs: []string{"::1", "10.0.0.1"}},
+		{name: "missing ip", override: "example.com:443", err: "dialer: resolve example.com:443 not in format host:port:ip[,ip...]"},
+		{name: "empty port", override: "example.com::10.0.0.1", err: "dialer: resolve example.com::10.0.0.1 not in format host:port:ip[,ip...]"},
+		{name: "invalid ip", override: "example.com:443:10.0.0.1,nope", err: "dialer: resolve example.com:443:10.0.0.1,nope contains invalid ip nope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, ips, err := ParseOverride(tt.override)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("ParseOverride() error = %v, wanted %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseOverride() error = %v, wanted no error", err)
+			}
+			if addr != tt.wantAddr || !reflect.DeepEqual(ips, tt.wantIPs) {
+				t.Errorf("ParseOverride() = %s, %v, wanted %s, %v", addr, ips, tt.wantAddr, tt.wantIPs)
+			}
+		})
+	}
+}
+
+func TestResolver_Pick(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides []string
+		addr      string
+		want      []string
+	}{
+		{
+			name:      "round-robin across overrides",
+			overrides: []string{"example.com:443:10.0.0.1,10.0.0.2"},
+			addr:      "example.com:443",
+			want:      []string{"10.0.0.1:443", "10.0.0.2:443", "10.0.0.1:443"},
+		},
+		{
+			name:      "repeated overrides are appended",
+			overrides: []string{"example.com:443:10.0.0.1", "example.com:443:10.0.0.2"},
+			addr:      "example.com:443",
+			want:      []string{"10.0.0.1:443", "10.0.0.2:443"},
+		},
+		{
+			name:      "override only for its port",
+			overrides: []string{"example.com:443:10.0.0.1"},
+			addr:      "10.0.0.9:80",
+			want:      []string{"10.0.0.9:80", "10.0.0.9:80"},
+		},
+		{name: "ip address isn't looked up", addr: "[::1]:8080", want: []string{"[::1]:8080"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewResolver(tt.overrides, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for range tt.want {
+				ipPort, err := r.Pick(context.Background(), tt.addr)
+				if err != nil {
+					t.Fatalf("Pick() error = %v, wanted no error", err)
+				}
+				got = append(got, ipPort)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Pick() = %v, wanted %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewResolver([]string{"example.com"}, ""); err == nil {
+		t.Error("NewResolver() wanted error for an invalid override")
+	}
+}
+
+func TestDialer_DialContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// refused resolves to an IP with nothing listening on its port
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+
+	r, err := NewResolver([]string{"example.com:" + port + ":127.0.0.1", "refused:" + closedPort + ":127.0.0.2"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(r, time.Second, nil)
+
+	for i := 0; i < 2; i++ {
+		conn, err := d.DialContext(context.Background(), "tcp", "example.com:"+port)
+		if err != nil {
+			t.Fatalf("DialContext() error = %v, wanted no error", err)
+		}
+		conn.Close()
+	}
+	if _, err := d.Dial("refused:" + closedPort); err == nil {
+		t.Error("Dial() wanted error dialing a closed port")
+	}
+
+	want := map[string]IPStats{"127.0.0.1": {Conns: 2}, "127.0.0.2": {DialErrors: 1}}
+	if got := r.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats() = %v, wanted %v", got, want)
+	}
+}
+
+func TestDialer_socketErr(t *testing.T) {
+	tests := []struct {
+		name     string
+		sourceIP net.IP
+		err      error
+		want     string
+	}{
+		{name: "no error"},
+		{name: "other error", err: syscall.ECONNREFUSED, want: syscall.ECONNREFUSED.Error()},
+		{
+			name: "out of ports",
+			err:  &net.OpError{Op: "dial", Err: syscall.EADDRNOTAVAIL},
+			want: fmt.Sprintf("dial: source ip default; %v (EADDRNOTAVAIL)", ErrSourceAddrUnavailable),
+		},
+		{
+			name:     "address in use from source ip",
+			sourceIP: net.ParseIP("10.0.0.5"),
+			err:      &net.OpError{Op: "dial", Err: syscall.EADDRINUSE},
+			want:     fmt.Sprintf("dial: source ip 10.0.0.5; %v (EADDRINUSE)", ErrSourceAddrUnavailable),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := New(nil, time.Second, tt.sourceIP).socketErr(tt.err)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("socketErr() = %v, wanted no error", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("socketErr() = %v, wanted %s", err, tt.want)
+			}
+			if unavailable := tt.err != syscall.ECONNREFUSED; errors.Is(err, ErrSourceAddrUnavailable) != unavailable {
+				t.Errorf("socketErr() = %v, wanted wrapping %v %v", err, ErrSourceAddrUnavailable, unavailable)
+			}
+		})
+	}
+}
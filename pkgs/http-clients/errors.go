@@ -0,0 +1,116 @@
+package http_clients
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/domsolutions/gopayloader/pkgs/http-clients/dialer"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// Categories errors are counted under, error strings include addresses and ports so they're too varied to count
+const (
+	ErrCategoryConnectTimeout   = "connect timeout"
+	ErrCategoryReadTimeout      = "read timeout"
+	ErrCategoryWriteTimeout     = "write timeout"
+	ErrCategoryTimeout          = "timeout"
+	ErrCategoryRefused          = "connection refused"
+	ErrCategoryReset            = "reset by peer"
+	ErrCategoryTLSHandshake     = "TLS handshake failure"
+	ErrCategoryDNS              = "DNS failure"
+	ErrCategoryEOF              = "EOF"
+	ErrCategoryTooManyOpenFiles = "too many open files"
+	ErrCategorySourceAddr       = "source address unavailable"
+	ErrCategoryOther            = "other"
+)
+
+// ClassifyError returns the category of err. Clients don't all wrap the underlying error so the message is checked
+// when it can't be unwrapped.
+func ClassifyError(err error) string {
+	msg := strings.ToLower(err.Error())
+
+	switch {
+	case errors.Is(err, syscall.EMFILE) || strings.Contains(msg, "too many open files"):
+		return ErrCategoryTooManyOpenFiles
+	case errors.Is(err, dialer.ErrSourceAddrUnavailable) || strings.Contains(msg, dialer.ErrSourceAddrUnavailable.Error()):
+		return ErrCategorySourceAddr
+	case isDNS(err, msg):
+		return ErrCategoryDNS
+	case isTLS(err, msg):
+		return ErrCategoryTLSHandshake
+	case IsTimeout(err):
+		return timeoutCategory(err, msg)
+	case errors.Is(err, syscall.ECONNREFUSED) || strings.Contains(msg, "refused"):
+		return ErrCategoryRefused
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		strings.Contains(msg, "connection reset") || strings.Contains(msg, "broken pipe") ||
+		strings.Contains(msg, "forcibly closed"):
+		return ErrCategoryReset
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || strings.HasSuffix(msg, "eof") ||
+		strings.Contains(msg, "server closed connection"):
+		return ErrCategoryEOF
+	}
+	return ErrCategoryOther
+}
+
+// Unsent returns true if err happened before the request could be written, while connecting or completing the TLS
+// handshake
+func Unsent(err error) bool {
+	if errors.Is(err, ErrConnsClosed) {
+		return true
+	}
+	switch ClassifyError(err) {
+	case ErrCategoryConnectTimeout, ErrCategoryRefused, ErrCategoryTLSHandshake, ErrCategoryDNS,
+		ErrCategoryTooManyOpenFiles, ErrCategorySourceAddr:
+		return true
+	}
+	return false
+}
+
+func isDNS(err error, msg string) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) || strings.Contains(msg, "no such host")
+}
+
+func isTLS(err error, msg string) bool {
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &verifyErr) || errors.As(err, &alertErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return true
+	}
+	return strings.Contains(msg, "tls:") || strings.Contains(msg, "x509:") || strings.Contains(msg, "handshake")
+}
+
+// timeoutCategory splits timeouts by whether they happened connecting, writing the request or reading the response
+func timeoutCategory(err error, msg string) string {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		switch opErr.Op {
+		case "dial":
+			return ErrCategoryConnectTimeout
+		case "read":
+			return ErrCategoryReadTimeout
+		case "write":
+			return ErrCategoryWriteTimeout
+		}
+	}
+
+	switch {
+	case strings.Contains(msg, "dial"):
+		return ErrCategoryConnectTimeout
+	case strings.Contains(msg, "awaiting headers") || strings.Contains(msg, "awaiting response headers") ||
+		strings.Contains(msg, "read"):
+		return ErrCategoryReadTimeout
+	case strings.Contains(msg, "write"):
+		return ErrCategoryWriteTimeout
+	}
+	return ErrCategoryTimeout
+}
@@ -0,0 +1,65 @@
+package http_clients
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/domsolutions/gopayloader/pkgs/http-clients/dialer"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// timeoutErr is a net.Error which timed out, as returned by connections past their deadline
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		want   string
+		unsent bool
+	}{
+		{name: "too many open files", err: &net.OpError{Op: "dial", Err: syscall.EMFILE}, want: ErrCategoryTooManyOpenFiles, unsent: true},
+		{name: "too many open files message", err: errors.New("accept: too many open files"), want: ErrCategoryTooManyOpenFiles, unsent: true},
+		{name: "source address", err: fmt.Errorf("dial: %w", dialer.ErrSourceAddrUnavailable), want: ErrCategorySourceAddr, unsent: true},
+		{name: "dns", err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "nope.invalid"}}, want: ErrCategoryDNS, unsent: true},
+		{name: "dns message", err: errors.New("lookup nope.invalid: no such host"), want: ErrCategoryDNS, unsent: true},
+		{name: "tls alert", err: &net.OpError{Op: "remote error", Err: tls.AlertError(40)}, want: ErrCategoryTLSHandshake, unsent: true},
+		{name: "tls message", err: errors.New("tls: first record does not look like a TLS handshake"), want: ErrCategoryTLSHandshake, unsent: true},
+		{name: "connect timeout", err: &net.OpError{Op: "dial", Err: timeoutErr{}}, want: ErrCategoryConnectTimeout, unsent: true},
+		{name: "read timeout", err: &net.OpError{Op: "read", Err: timeoutErr{}}, want: ErrCategoryReadTimeout},
+		{name: "write timeout", err: &net.OpError{Op: "write", Err: timeoutErr{}}, want: ErrCategoryWriteTimeout},
+		{name: "awaiting response headers", err: errors.New("net/http: timeout awaiting response headers"), want: ErrCategoryReadTimeout},
+		{name: "client timeout awaiting headers", err: errors.New("context deadline exceeded (Client.Timeout exceeded while awaiting headers)"), want: ErrCategoryReadTimeout},
+		{name: "deadline exceeded", err: os.ErrDeadlineExceeded, want: ErrCategoryTimeout},
+		{name: "context deadline", err: context.DeadlineExceeded, want: ErrCategoryTimeout},
+		{name: "refused", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, want: ErrCategoryRefused, unsent: true},
+		{name: "reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, want: ErrCategoryReset},
+		{name: "broken pipe", err: &net.OpError{Op: "write", Err: syscall.EPIPE}, want: ErrCategoryReset},
+		{name: "forcibly closed", err: errors.New("wsarecv: An existing connection was forcibly closed by the remote host."), want: ErrCategoryReset},
+		{name: "eof", err: io.EOF, want: ErrCategoryEOF},
+		{name: "unexpected eof", err: fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF), want: ErrCategoryEOF},
+		{name: "server closed", err: errors.New("the server closed connection before returning the first response byte"), want: ErrCategoryEOF},
+		{name: "connections closed", err: fmt.Errorf("dial: %w", ErrConnsClosed), want: ErrCategoryOther, unsent: true},
+		{name: "other", err: errors.New("malformed HTTP response"), want: ErrCategoryOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err); got != tt.want {
+				t.Errorf("ClassifyError(%v) = %s, wanted %s", tt.err, got, tt.want)
+			}
+			if got := Unsent(tt.err); got != tt.unsent {
+				t.Errorf("Unsent(%v) = %v, wanted %v", tt.err, got, tt.unsent)
+			}
+		})
+	}
+}
@@ -1,20 +1,30 @@
 package fasthttp
 
 import (
-	"crypto/tls"
 	"github.com/domsolutions/gopayloader/pkgs/http-clients"
 	"github.com/valyala/fasthttp"
+	"io"
 	"net"
 	"net/url"
 )
 
 type Client struct {
 	client *fasthttp.HostClient
+	conns  *http_clients.Conns
 	http2  bool
 }
 
+// PipelineClient sends HTTP/1.1 requests pipelined over a single connection
+type PipelineClient struct {
+	client *fasthttp.PipelineClient
+	conns  *http_clients.Conns
+}
+
 type Req struct {
 	req *fasthttp.Request
+	// open returns the body stream, set again before each send as fasthttp closes the stream once sent
+	open       func() io.Reader
+	streamSize int64
 }
 
 type Resp struct {
@@ -25,26 +35,45 @@ func (r *Resp) StatusCode() int {
 	return r.resp.StatusCode()
 }
 
+func (r *Resp) Header(key string) string {
+	return string(r.resp.Header.Peek(key))
+}
+
+// Size is the status line, headers and body as received, bodies aren't decompressed
 func (r *Resp) Size() int64 {
-	var size = int64(len(r.resp.Body()))
-	size += int64(len(r.resp.Header.Header()))
-	return size
+	return int64(len(r.resp.Header.Header()) + len(r.resp.Body()))
 }
 
 func (r *Resp) Close() {
 	r.resp.CloseBodyStream()
 }
 
+func (r *Resp) Release() {
+	fasthttp.ReleaseResponse(r.resp)
+	r.resp = nil
+}
+
+// RemoteIP is set once fasthttp acquires a connection for the request, before it's written
+func (r *Resp) RemoteIP() string {
+	return http_clients.RemoteIP(r.resp.RemoteAddr())
+}
+
 func (fh *Req) SetHeader(key, val string) {
 	fh.req.Header.Set(key, val)
 }
 
+func (fh *Req) Header(key string) string {
+	return string(fh.req.Header.Peek(key))
+}
+
+// Size is the request line, headers and body as sent, the host and content length are only added to the headers once
+// the request is sent
 func (fh *Req) Size() int64 {
-	size := len(fh.req.Body()) + 2 // 2 for the \r\n that separates the headers and body.
-	fh.req.Header.VisitAll(func(key, value []byte) {
-		size += len(key) + len(value) + 2 // 2 for the \r\n that separates the headers.
-	})
-	return int64(size)
+	if fh.open != nil {
+		// reading Body() would buffer the stream
+		return int64(len(fh.req.Header.Header())) + fh.streamSize
+	}
+	return int64(len(fh.req.Header.Header()) + len(fh.req.Body()))
 }
 
 func (fh *Req) SetMethod(method string) {
@@ -55,8 +84,20 @@ func (fh *Req) SetBody(body []byte) {
 	fh.req.SetBody(body)
 }
 
+func (fh *Req) SetBodyStream(open func() io.Reader, size int64) {
+	fh.open, fh.streamSize = open, size
+}
+
+// prepare sets the body stream from the start before the request is sent
+func (fh *Req) prepare() *fasthttp.Request {
+	if fh.open != nil {
+		fh.req.SetBodyStream(fh.open(), int(fh.streamSize))
+	}
+	return fh.req
+}
+
 func (fh *Client) Do(req http_clients.Request, resp http_clients.Response) error {
-	return fh.client.Do(req.(*Req).req, resp.(*Resp).resp)
+	return fh.client.Do(req.(*Req).prepare(), resp.(*Resp).resp)
 }
 
 func (c *Client) HTTP2() bool {
@@ -65,15 +106,48 @@ func (c *Client) HTTP2() bool {
 
 func (c *Client) CloseConns() {
 	c.client.CloseIdleConnections()
+	c.conns.Close()
 }
 
 func (fh *Client) NewResponse() http_clients.Response {
-	// TODO: buffer pool
-	return &Resp{resp: &fasthttp.Response{}}
+	return newResponse()
 }
 
 func (fh *Client) NewReq(method, url string) (http_clients.Request, error) {
-	// TODO: buffer pool
+	return newReq(method, url)
+}
+
+func (pc *PipelineClient) Do(req http_clients.Request, resp http_clients.Response) error {
+	return pc.client.Do(req.(*Req).prepare(), resp.(*Resp).resp)
+}
+
+func (pc *PipelineClient) HTTP2() bool {
+	return false
+}
+
+// CloseConns is a no-op, pipelined connections are closed once idle for fasthttp.DefaultMaxIdleConnDuration
+func (pc *PipelineClient) CloseConns() {
+	pc.conns.Close()
+}
+
+func (pc *PipelineClient) PendingRequests() int {
+	return pc.client.PendingRequests()
+}
+
+func (pc *PipelineClient) NewResponse() http_clients.Response {
+	return newResponse()
+}
+
+func (pc *PipelineClient) NewReq(method, url string) (http_clients.Request, error) {
+	return newReq(method, url)
+}
+
+func newResponse() http_clients.Response {
+	return &Resp{resp: fasthttp.AcquireResponse()}
+}
+
+// newReq creates a request, workers reuse requests once sent so they aren't pooled
+func newReq(method, url string) (http_clients.Request, error) {
 	r := &fasthttp.Request{}
 	r.SetRequestURI(url)
 	r.Header.SetMethodBytes([]byte(method))
@@ -82,17 +156,54 @@ func (fh *Client) NewReq(method, url string) (http_clients.Request, error) {
 	}, nil
 }
 
-func GetFastHTTPClient1(config *http_clients.Config) (http_clients.GoPayLoaderClient, error) {
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: config.SkipVerify,
+func GetFastHTTPPipelineClient(config *http_clients.Config) (http_clients.GoPayLoaderClient, error) {
+	tlsConfig, err := http_clients.NewTLSConfig(config)
+	if err != nil {
+		return nil, err
 	}
 
-	if config.MTLSCert != "" && config.MTLSKey != "" {
-		cert, err := tls.LoadX509KeyPair(config.MTLSCert, config.MTLSKey)
+	u, err := url.ParseRequestURI(config.ReqURI)
+	if err != nil {
+		return nil, err
+	}
+
+	conns := http_clients.NewConns()
+	client := &fasthttp.PipelineClient{
+		Addr:                          u.Host,
+		IsTLS:                         u.Scheme == "https",
+		MaxConns:                      1,
+		MaxPendingRequests:            config.Pipeline,
+		ReadTimeout:                   config.ReadTimeout,
+		WriteTimeout:                  config.WriteTimeout,
+		DisableHeaderNamesNormalizing: true,
+		TLSConfig:                     tlsConfig,
+		Dial:                          dial(config, conns),
+	}
+
+	return &PipelineClient{client: client, conns: conns}, nil
+}
+
+// dial dials connections tracked by conns so they can be closed with requests in flight
+func dial(config *http_clients.Config, conns *http_clients.Conns) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		var conn net.Conn
+		var err error
+		if config.Dialer != nil {
+			conn, err = config.Dialer.Dial(addr)
+		} else {
+			conn, err = fasthttp.DialTimeout(addr, config.ReadTimeout)
+		}
 		if err != nil {
 			return nil, err
 		}
-		tlsConfig.Certificates = []tls.Certificate{cert}
+		return conns.Track(conn)
+	}
+}
+
+func GetFastHTTPClient1(config *http_clients.Config) (http_clients.GoPayLoaderClient, error) {
+	tlsConfig, err := http_clients.NewTLSConfig(config)
+	if err != nil {
+		return nil, err
 	}
 
 	u, err := url.ParseRequestURI(config.ReqURI)
@@ -100,6 +211,7 @@ func GetFastHTTPClient1(config *http_clients.Config) (http_clients.GoPayLoaderCl
 		return nil, err
 	}
 
+	conns := http_clients.NewConns()
 	client := &fasthttp.HostClient{
 		Addr:                          u.Host,
 		IsTLS:                         u.Scheme == "https",
@@ -108,10 +220,12 @@ func GetFastHTTPClient1(config *http_clients.Config) (http_clients.GoPayLoaderCl
 		WriteTimeout:                  config.WriteTimeout,
 		DisableHeaderNamesNormalizing: true,
 		TLSConfig:                     tlsConfig,
-		Dial: func(addr string) (net.Conn, error) {
-			return fasthttp.DialTimeout(addr, config.ReadTimeout)
-		},
+		Dial:                          dial(config, conns),
+	}
+	if config.Shared {
+		// requests from workers sharing the connection queue for it rather than failing with ErrNoFreeConns
+		client.MaxConnWaitTimeout = config.ReadTimeout + config.WriteTimeout
 	}
 
-	return &Client{client: client, http2: false}, nil
+	return &Client{client: client, conns: conns, http2: false}, nil
 }
@@ -0,0 +1,136 @@
+package http_clients
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrReqTargetConns is returned when changing the active workers of a run with a request target, each worker sends its
+// share of the requests so inactive workers' requests would never be sent
+var ErrReqTargetConns = errors.New("gate: connections of a run with a request target can't be changed")
+
+// Gate holds back workers' requests while paused, above the target rate or when the worker isn't active, it's
+// changed at runtime through the control API
+type Gate struct {
+	mu      sync.Mutex
+	paused  bool
+	rate    float64
+	active  int
+	workers int
+	// reqTarget is set when workers each send a share of a request target
+	reqTarget bool
+	next      time.Time
+	// changed is closed and replaced whenever the gate changes, waking workers held back
+	changed chan struct{}
+}
+
+// GateState is the state of the gate, a rate of 0 is unlimited
+type GateState struct {
+	Paused  bool
+	Rate    float64
+	Active  int
+	Workers int
+}
+
+// NewGate returns an open gate for workers workers, all of them active, reqTarget is set when the run has a request
+// target
+func NewGate(workers int, reqTarget bool) *Gate {
+	return &Gate{
+		active:    workers,
+		workers:   workers,
+		reqTarget: reqTarget,
+		changed:   make(chan struct{}),
+	}
+}
+
+// Wait blocks worker id until it may send its next request, returning false if ctx is done first
+func (g *Gate) Wait(ctx context.Context, id int) bool {
+	for {
+		g.mu.Lock()
+		if !g.paused && id < g.active {
+			break
+		}
+		changed := g.changed
+		g.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+
+	// requests are spaced 1/rate apart across all workers
+	var wait time.Duration
+	if g.rate > 0 {
+		now := time.Now()
+		at := g.next
+		if at.Before(now) {
+			at = now
+		}
+		g.next = at.Add(time.Duration(float64(time.Second) / g.rate))
+		wait = at.Sub(now)
+	}
+	g.mu.Unlock()
+
+	if wait <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Pause holds back all workers until Resume
+func (g *Gate) Pause() {
+	g.update(func() {
+		g.paused = true
+	})
+}
+
+func (g *Gate) Resume() {
+	g.update(func() {
+		g.paused = false
+	})
+}
+
+// SetRate caps requests per second across all workers, 0 removes the cap
+func (g *Gate) SetRate(rps float64) {
+	g.update(func() {
+		g.rate = rps
+		g.next = time.Time{}
+	})
+}
+
+// SetActive sets how many workers send requests, the rest are held back. It's clamped to between 1 and the number of
+// workers, runs with a request target return ErrReqTargetConns.
+func (g *Gate) SetActive(n int) error {
+	if g.reqTarget {
+		return ErrReqTargetConns
+	}
+	g.update(func() {
+		g.active = min(max(n, 1), g.workers)
+	})
+	return nil
+}
+
+func (g *Gate) State() GateState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return GateState{Paused: g.paused, Rate: g.rate, Active: g.active, Workers: g.workers}
+}
+
+func (g *Gate) update(change func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	change()
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
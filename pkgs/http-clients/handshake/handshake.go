@@ -0,0 +1,205 @@
+package handshake
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/domsolutions/gopayloader/pkgs/http-clients"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// ticketWait is the longest a TLS 1.3 connection is kept open after the handshake to receive a session ticket
+const ticketWait = 250 * time.Millisecond
+
+// Client opens a new TCP+TLS connection per request, completes the handshake and closes it, no HTTP exchange happens
+type Client struct {
+	addr              string
+	tlsConfig         *tls.Config
+	dial              func(ctx context.Context, network, addr string) (net.Conn, error)
+	timeout           time.Duration
+	sessionResumption bool
+	noTickets         bool
+	tickets           *ticketCache
+	onHandshake       func(state tls.ConnectionState)
+	// ctx is cancelled once the connections are closed so in-flight handshakes of abandoned requests are stopped
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// ticketCache stops the read waiting for a session ticket as soon as one is stored
+type ticketCache struct {
+	tls.ClientSessionCache
+	pending *tls.Conn
+}
+
+func (t *ticketCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	t.ClientSessionCache.Put(sessionKey, cs)
+	if cs != nil && t.pending != nil {
+		_ = t.pending.SetReadDeadline(time.Now())
+	}
+}
+
+type Req struct{}
+
+// Resp holds the connection until it's closed so waiting for a session ticket isn't timed as part of the handshake
+type Resp struct {
+	client     *Client
+	conn       *tls.Conn
+	remoteAddr net.Addr
+	resume     bool
+}
+
+func (r *Req) SetHeader(key, val string) {}
+
+func (r *Req) SetBody(body []byte) {}
+
+func (r *Req) SetBodyStream(open func() io.Reader, size int64) {}
+
+func (r *Req) Header(key string) string {
+	return ""
+}
+
+func (r *Req) Size() int64 {
+	return 0
+}
+
+func (r *Resp) StatusCode() int {
+	return 0
+}
+
+func (r *Resp) Header(key string) string {
+	return ""
+}
+
+func (r *Resp) Size() int64 {
+	return 0
+}
+
+func (r *Resp) Close() {
+	if r.conn == nil {
+		return
+	}
+	if r.resume {
+		r.client.readTicket(r.conn)
+	}
+	_ = r.conn.Close()
+	r.conn = nil
+}
+
+func (r *Resp) Release() {}
+
+func (r *Resp) RemoteIP() string {
+	return http_clients.RemoteIP(r.remoteAddr)
+}
+
+func (c *Client) Do(req http_clients.Request, resp http_clients.Response) error {
+	conn, err := c.dial(c.ctx, "tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	r := resp.(*Resp)
+	r.remoteAddr = conn.RemoteAddr()
+
+	tlsConn := tls.Client(conn, c.tlsConfig)
+	if err := tlsConn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	if err := tlsConn.HandshakeContext(c.ctx); err != nil {
+		conn.Close()
+		return err
+	}
+
+	state := tlsConn.ConnectionState()
+	if c.onHandshake != nil {
+		c.onHandshake(state)
+	}
+
+	r.conn = tlsConn
+	// the ticket is read once the response is closed, after the handshake is timed
+	r.resume = c.sessionResumption && !c.noTickets && state.Version == tls.VersionTLS13 && !state.DidResume
+	return nil
+}
+
+// readTicket processes TLS 1.3 session tickets which are sent after the handshake
+func (c *Client) readTicket(conn *tls.Conn) {
+	c.tickets.pending = conn
+	defer func() {
+		c.tickets.pending = nil
+	}()
+
+	if err := conn.SetReadDeadline(time.Now().Add(ticketWait)); err != nil {
+		return
+	}
+	// reading processes post handshake messages, the read itself is expected to time out once a ticket is stored
+	_, _ = conn.Read(make([]byte, 1))
+
+	if _, ok := c.tickets.Get(c.tlsConfig.ServerName); !ok {
+		// server doesn't issue tickets, don't wait on every handshake
+		c.noTickets = true
+	}
+}
+
+func (c *Client) CloseConns() {
+	c.cancel()
+}
+
+func (c *Client) HTTP2() bool {
+	return false
+}
+
+func (c *Client) NewResponse() http_clients.Response {
+	return &Resp{client: c}
+}
+
+func (c *Client) NewReq(method, url string) (http_clients.Request, error) {
+	return &Req{}, nil
+}
+
+func GetHandshakeClient(config *http_clients.Config) (http_clients.GoPayLoaderClient, error) {
+	u, err := url.ParseRequestURI(config.ReqURI)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return nil, errors.New("handshake: request uri must use https scheme")
+	}
+
+	tlsConfig, err := http_clients.NewTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+	// handshake stats are reported once the handshake completes
+	tlsConfig.VerifyConnection = nil
+
+	tickets := &ticketCache{ClientSessionCache: tls.NewLRUClientSessionCache(1)}
+	if config.SessionResumption {
+		tlsConfig.ClientSessionCache = tickets
+	} else {
+		tlsConfig.SessionTicketsDisabled = true
+	}
+
+	dial := (&net.Dialer{Timeout: config.ReadTimeout}).DialContext
+	if config.Dialer != nil {
+		dial = config.Dialer.DialContext
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{
+		addr:              u.Host,
+		tlsConfig:         tlsConfig,
+		dial:              dial,
+		timeout:           config.ReadTimeout + config.WriteTimeout,
+		sessionResumption: config.SessionResumption,
+		tickets:           tickets,
+		onHandshake:       config.OnTLSHandshake,
+		ctx:               ctx,
+		cancel:            cancel,
+	}, nil
+}
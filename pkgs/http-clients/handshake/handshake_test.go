@@ -0,0 +1,53 @@
+package handshake
+
+import (
+	"context"
+	"errors"
+	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClient_DoConnsClosed(t *testing.T) {
+	// the server accepts connections but never answers the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	client, err := GetHandshakeClient(&http_clients.Config{
+		ReqURI:       "https://" + ln.Addr().String(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Do(&Req{}, client.NewResponse())
+	}()
+	time.Sleep(100 * time.Millisecond)
+	client.CloseConns()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Do() error = %v, wanted %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Do() still handshaking once the connections were closed")
+	}
+}
@@ -0,0 +1,200 @@
+package nethttp
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/net/http2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	clientPreface        = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+	frameHeaderLen       = 9
+	frameSettings        = 0x4
+	frameWindowUpdate    = 0x8
+	settingInitialWindow = 0x4
+	settingEntryLen      = 6
+	defaultConnWindow    = 65535
+)
+
+// Window limits, the upper limits are the windows the http2 transport enforces itself
+const (
+	MinH2StreamWindow = 4 << 10
+	MaxH2StreamWindow = 4 << 20
+	MinH2ConnWindow   = defaultConnWindow
+	MaxH2ConnWindow   = 1<<30 + defaultConnWindow
+)
+
+// flowConn rewrites the flow control windows the http2 transport advertises in its connection preface, x/net doesn't
+// expose them. Windows can only be lowered, the transport still enforces its own larger windows so the server never
+// overruns them, and it credits consumed bytes back in 4KB steps so windows can't go below that.
+//
+// The preface is buffered until its SETTINGS and connection WINDOW_UPDATE frames are complete, so it's rewritten
+// however the transport splits its writes. The layout depends on x/net so checkPreface checks it before any connection
+// is rewritten.
+type flowConn struct {
+	*tls.Conn
+	streamWindow uint32
+	connWindow   uint32
+	pending      []byte
+	rewritten    bool
+}
+
+func (c *flowConn) Write(b []byte) (int, error) {
+	if c.rewritten {
+		return c.Conn.Write(b)
+	}
+
+	c.pending = append(c.pending, b...)
+	out, ok, err := c.rewritePreface(c.pending)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		// rest of the preface is still to be written
+		return len(b), nil
+	}
+	c.rewritten = true
+	c.pending = nil
+
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// rewritePreface returns b with the initial window settings replaced, ok is false while b doesn't yet hold the
+// preface, SETTINGS and connection WINDOW_UPDATE frames in full
+func (c *flowConn) rewritePreface(b []byte) (out []byte, ok bool, err error) {
+	n := min(len(b), len(clientPreface))
+	if string(b[:n]) != clientPreface[:n] {
+		return nil, false, errors.New("http2: unexpected client preface; can't set flow control windows")
+	}
+	if n < len(clientPreface) {
+		return nil, false, nil
+	}
+
+	out = make([]byte, 0, len(b)+settingEntryLen)
+	out = append(out, clientPreface...)
+	rest := b[len(clientPreface):]
+
+	var settings, windowUpdate bool
+	for !settings || !windowUpdate {
+		if len(rest) < frameHeaderLen {
+			return nil, false, nil
+		}
+		length := int(rest[0])<<16 | int(rest[1])<<8 | int(rest[2])
+		if len(rest) < frameHeaderLen+length {
+			return nil, false, nil
+		}
+		header, payload := rest[:frameHeaderLen], rest[frameHeaderLen:frameHeaderLen+length]
+		rest = rest[frameHeaderLen+length:]
+		streamID := binary.BigEndian.Uint32(header[5:]) & (1<<31 - 1)
+
+		switch {
+		case header[3] == frameSettings && streamID == 0 && !settings:
+			if !hasInitialWindow(payload) {
+				return nil, false, errors.New("http2: client preface SETTINGS has no initial window size; can't set flow control windows")
+			}
+			settings = true
+			if c.streamWindow == 0 {
+				out = append(out, header...)
+				out = append(out, payload...)
+				continue
+			}
+			out = appendSettings(out, header, payload, c.streamWindow)
+		case header[3] == frameWindowUpdate && streamID == 0 && !windowUpdate:
+			windowUpdate = true
+			switch c.connWindow {
+			case 0:
+				out = append(out, header...)
+				out = append(out, payload...)
+			case defaultConnWindow:
+				// spec default window needs no update
+			default:
+				out = append(out, header...)
+				out = binary.BigEndian.AppendUint32(out, c.connWindow-defaultConnWindow)
+			}
+		default:
+			return nil, false, fmt.Errorf("http2: unexpected frame type %d in client preface; can't set flow control windows", header[3])
+		}
+	}
+	return append(out, rest...), true, nil
+}
+
+func hasInitialWindow(payload []byte) bool {
+	if len(payload)%settingEntryLen != 0 {
+		return false
+	}
+	for i := 0; i < len(payload); i += settingEntryLen {
+		if binary.BigEndian.Uint16(payload[i:]) == settingInitialWindow {
+			return true
+		}
+	}
+	return false
+}
+
+// prefaceCheck is the result of checkPreface, the layout can only change with the x/net version built in
+var prefaceCheck = sync.OnceValue(checkPreface)
+
+// checkPreface opens an http2 transport connection over a pipe to check it writes its preface as flowConn expects, so
+// a change in x/net fails the run rather than leaving the windows as they were or stalling connections
+func checkPreface() error {
+	client, server := net.Pipe()
+	defer server.Close()
+	transport := &http2.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return client, nil
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://preface.check/", nil)
+	if err != nil {
+		return err
+	}
+	go func() {
+		_, _ = transport.RoundTrip(req)
+	}()
+
+	if err := server.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		return err
+	}
+	probe := &flowConn{streamWindow: MinH2StreamWindow, connWindow: MinH2ConnWindow + 1}
+	var written []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := server.Read(buf)
+		if err != nil {
+			return fmt.Errorf("http2: client preface incomplete; can't set flow control windows; %v", err)
+		}
+		written = append(written, buf[:n]...)
+		if _, ok, err := probe.rewritePreface(written); err != nil || ok {
+			return err
+		}
+	}
+}
+
+func appendSettings(out, header, payload []byte, streamWindow uint32) []byte {
+	settings := make([]byte, 0, len(payload)+settingEntryLen)
+	for i := 0; i+settingEntryLen <= len(payload); i += settingEntryLen {
+		if binary.BigEndian.Uint16(payload[i:]) == settingInitialWindow {
+			continue
+		}
+		settings = append(settings, payload[i:i+settingEntryLen]...)
+	}
+	settings = binary.BigEndian.AppendUint16(settings, settingInitialWindow)
+	settings = binary.BigEndian.AppendUint32(settings, streamWindow)
+
+	out = append(out, byte(len(settings)>>16), byte(len(settings)>>8), byte(len(settings)))
+	out = append(out, header[3:]...)
+	return append(out, settings...)
+}
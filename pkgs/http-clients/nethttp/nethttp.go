@@ -2,26 +2,72 @@ package nethttp
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"fmt"
 	"github.com/domsolutions/gopayloader/pkgs/http-clients"
+	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/logging"
 	"golang.org/x/net/http2"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+var respPool = sync.Pool{
+	New: func() any {
+		return &Resp{}
+	},
+}
+
 type Client struct {
 	client *http.Client
-	http2  bool
+	// conns are the http2 connections streams are spread over round-robin, each has its own transport
+	conns   []*http.Client
+	streams []chan struct{}
+	next    atomic.Uint64
+	http2   bool
+	// zeroRTT sends GET requests as 0-RTT early data on resumed QUIC connections
+	zeroRTT bool
+	// dialed are the TCP connections dialed, h3 owns the QUIC connections, both are closed with requests in flight
+	dialed *http_clients.Conns
+	h3     *http3.RoundTripper
+	// ctx is the context of every request, cancelled once the connections are closed so requests waiting for a stream
+	// slot give up
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type Req struct {
 	req *http.Request
+	// remoteAddr is the remote address of the connection the transport got for the request
+	remoteAddr net.Addr
 }
 
 type Resp struct {
-	resp *http.Response
+	resp       *http.Response
+	body       *countingBody
+	release    func()
+	remoteAddr net.Addr
+}
+
+// countingBody counts the response body bytes read, bodies are chunked or compressed so ContentLength can't be used
+type countingBody struct {
+	io.ReadCloser
+	read int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	return n, err
 }
 
 func (r *Resp) StatusCode() int {
@@ -34,17 +80,45 @@ func (r *Resp) Close() {
 		log.Printf("Failed to read response body and discard %v \n", err)
 	}
 	r.resp.Body.Close()
+	if r.release != nil {
+		// stream is finished once the body is closed
+		r.release()
+		r.release = nil
+	}
 }
 
+func (r *Resp) Release() {
+	*r = Resp{}
+	respPool.Put(r)
+}
+
+func (r *Resp) Header(key string) string {
+	return r.resp.Header.Get(key)
+}
+
+func (r *Resp) RemoteIP() string {
+	return http_clients.RemoteIP(r.remoteAddr)
+}
+
+// Size is the status line, headers and body bytes read as sent over HTTP/1.1, the body must be read or the response
+// closed first
 func (r *Resp) Size() int64 {
-	if r.resp == nil {
+	if r.resp == nil || r.resp.Status == "" {
 		return 0
 	}
-	var size = r.resp.ContentLength
-	for key, header := range r.resp.Header {
-		size += int64(len(key))
+	size := int64(len(r.resp.Proto)+len(r.resp.Status)+len("  \r\n\r\n")) + headerSize(r.resp.Header)
+	if r.body != nil {
+		size += r.body.read
+	}
+	return size
+}
+
+// headerSize is the size of h written as key: value lines
+func headerSize(h http.Header) int64 {
+	var size int64
+	for key, header := range h {
 		for _, val := range header {
-			size += int64(len(val))
+			size += int64(len(key) + len(val) + len(": \r\n"))
 		}
 	}
 	return size
@@ -54,37 +128,120 @@ func (r *Req) SetHeader(key, val string) {
 	r.req.Header.Set(key, val)
 }
 
+func (r *Req) Header(key string) string {
+	return r.req.Header.Get(key)
+}
+
 func (r *Req) SetMethod(method string) {
 	r.req.Method = method
 }
 
+// SetBody sets the body sent with the request, it's sent again from the start each time the request is sent
 func (r *Req) SetBody(body []byte) {
+	r.req.ContentLength = int64(len(body))
 	r.req.GetBody = func() (io.ReadCloser, error) {
 		r := bytes.NewReader(body)
 		return io.NopCloser(r), nil
 	}
 }
 
+// SetBodyStream sets a body read from open each time the request is sent, the transport streams it without buffering
+func (r *Req) SetBodyStream(open func() io.Reader, size int64) {
+	r.req.ContentLength = size
+	r.req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(open()), nil
+	}
+}
+
+// Size is the request line, headers and body as sent over HTTP/1.1
 func (r *Req) Size() int64 {
-	var size = r.req.ContentLength
-	for key, header := range r.req.Header {
-		size += int64(len(key))
-		for _, val := range header {
-			size += int64(len(val))
-		}
+	size := int64(len(r.req.Method)+len(r.req.URL.RequestURI())+len("  HTTP/1.1\r\n\r\n")) + headerSize(r.req.Header)
+	size += int64(len("Host: \r\n") + len(r.req.URL.Host))
+	if r.req.Header.Get("User-Agent") == "" {
+		size += int64(len("User-Agent: Go-http-client/1.1\r\n"))
+	}
+	if r.req.ContentLength > 0 {
+		size += int64(len("Content-Length: \r\n")+len(strconv.FormatInt(r.req.ContentLength, 10))) + r.req.ContentLength
 	}
-	size += int64(len(r.req.UserAgent()))
-	return size + int64(len(r.req.Host))
+	return size
+}
+
+// rewind resets the body so the request can be sent again
+func (r *Req) rewind() error {
+	if r.req.GetBody == nil {
+		return nil
+	}
+	body, err := r.req.GetBody()
+	if err != nil {
+		return err
+	}
+	r.req.Body = body
+	return nil
 }
 
 func (c *Client) Do(req http_clients.Request, resp http_clients.Response) error {
-	resptemp, err := c.client.Do(req.(*Req).req)
-	resp.(*Resp).resp = resptemp
-	return err
+	r := req.(*Req)
+	if err := r.rewind(); err != nil {
+		return err
+	}
+	r.remoteAddr = nil
+	defer func() {
+		resp.(*Resp).remoteAddr = r.remoteAddr
+	}()
+
+	if len(c.conns) == 0 {
+		resptemp, err := c.client.Do(r.req)
+		resp.(*Resp).setResp(resptemp)
+		return err
+	}
+
+	i := (c.next.Add(1) - 1) % uint64(len(c.conns))
+	var release func()
+	if c.streams != nil {
+		select {
+		case c.streams[i] <- struct{}{}:
+		case <-r.req.Context().Done():
+			return r.req.Context().Err()
+		}
+		release = func() {
+			<-c.streams[i]
+		}
+	}
+
+	resptemp, err := c.conns[i].Do(r.req)
+	resp.(*Resp).setResp(resptemp)
+	if err != nil {
+		if release != nil {
+			release()
+		}
+		return err
+	}
+	resp.(*Resp).release = release
+	return nil
+}
+
+func (r *Resp) setResp(resp *http.Response) {
+	r.resp = resp
+	if resp != nil && resp.Body != nil {
+		r.body = &countingBody{ReadCloser: resp.Body}
+		resp.Body = r.body
+	}
 }
 
 func (c *Client) CloseConns() {
-	c.client.CloseIdleConnections()
+	c.cancel()
+	if c.h3 != nil {
+		_ = c.h3.Close()
+		return
+	}
+
+	if len(c.conns) == 0 {
+		c.client.CloseIdleConnections()
+	}
+	for _, conn := range c.conns {
+		conn.CloseIdleConnections()
+	}
+	c.dialed.Close()
 }
 
 func (c *Client) HTTP2() bool {
@@ -92,95 +249,237 @@ func (c *Client) HTTP2() bool {
 }
 
 func (c *Client) NewResponse() http_clients.Response {
-	return &Resp{
-		resp: &http.Response{},
-	}
+	return respPool.Get().(*Resp)
 }
 
 func (c *Client) NewReq(method, url string) (http_clients.Request, error) {
-	req, err := http.NewRequest(method, url, nil)
+	if c.zeroRTT && method == http.MethodGet {
+		method = http3.MethodGet0RTT
+	}
+	r := &Req{}
+	// the connection's address is taken per request as transports spread requests over several connections
+	trace := httptrace.WithClientTrace(c.ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.remoteAddr = info.Conn.RemoteAddr()
+		},
+	})
+	req, err := http.NewRequestWithContext(trace, method, url, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Connection", "Keep-Alive")
+	r.req = req
+	return r, nil
+}
 
-	return &Req{
-		req: req,
-	}, nil
+// noRedirects returns redirect responses as is, redirects are followed by the worker so all clients behave the same
+func noRedirects(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
 }
 
 func GetNetHTTPClient(config *http_clients.Config) (http_clients.GoPayLoaderClient, error) {
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: config.SkipVerify,
+	tlsConfig, err := http_clients.NewTLSConfig(config)
+	if err != nil {
+		return nil, err
 	}
 
-	if config.MTLSCert != "" && config.MTLSKey != "" {
-		cert, err := tls.LoadX509KeyPair(config.MTLSCert, config.MTLSKey)
-		if err != nil {
-			return nil, err
-		}
-		tlsConfig.Certificates = []tls.Certificate{cert}
+	dialed := http_clients.NewConns()
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+		MaxConnsPerHost: 1,
+		// bodies are counted as received, compressed responses are only requested with an Accept-Encoding header
+		DisableCompression: true,
+		DialContext:        dialTCP(config, dialed),
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
-		http2: false,
+		http2:  false,
+		dialed: dialed,
+		ctx:    ctx,
+		cancel: cancel,
 		client: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: tlsConfig,
-				MaxConnsPerHost: 1,
-			},
-			Timeout: config.ReadTimeout + config.WriteTimeout,
+			Transport:     transport,
+			Timeout:       config.ReadTimeout + config.WriteTimeout,
+			CheckRedirect: noRedirects,
 		}}, nil
 }
 
 func GetNetHTTP2Client(config *http_clients.Config) (http_clients.GoPayLoaderClient, error) {
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: config.SkipVerify,
+	tlsConfig, err := http_clients.NewTLSConfig(config)
+	if err != nil {
+		return nil, err
 	}
 
-	if config.MTLSCert != "" && config.MTLSKey != "" {
-		cert, err := tls.LoadX509KeyPair(config.MTLSCert, config.MTLSKey)
-		if err != nil {
+	if config.H2StreamWindow != 0 || config.H2ConnWindow != 0 {
+		if err := prefaceCheck(); err != nil {
 			return nil, err
 		}
-		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
 
-	return &Client{
-		http2: true,
-		client: &http.Client{
-			Transport: &http2.Transport{
-				TLSClientConfig:            tlsConfig,
-				StrictMaxConcurrentStreams: true,
-			},
-			Timeout: config.ReadTimeout + config.WriteTimeout,
-		}}, nil
+	numConns := config.H2Conns
+	if numConns < 1 {
+		numConns = 1
+	}
+
+	c := &Client{
+		http2:  true,
+		conns:  make([]*http.Client, numConns),
+		dialed: http_clients.NewConns(),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	if config.H2MaxStreams > 0 {
+		c.streams = make([]chan struct{}, numConns)
+	}
+
+	for i := range c.conns {
+		transport := &http2.Transport{
+			TLSClientConfig:            tlsConfig,
+			StrictMaxConcurrentStreams: true,
+			DisableCompression:         true,
+			MaxHeaderListSize:          config.H2MaxHeaderListSize,
+			MaxReadFrameSize:           config.H2MaxFrameSize,
+			ReadIdleTimeout:            config.H2ReadIdleTimeout,
+			PingTimeout:                config.H2PingTimeout,
+			DialTLSContext:             dialHTTP2(config, c.dialed),
+		}
+
+		c.conns[i] = &http.Client{
+			Transport:     transport,
+			Timeout:       config.ReadTimeout + config.WriteTimeout,
+			CheckRedirect: noRedirects,
+		}
+		if c.streams != nil {
+			c.streams[i] = make(chan struct{}, config.H2MaxStreams)
+		}
+	}
+	c.client = c.conns[0]
+
+	return c, nil
 }
 
-func GetNetHTTP3Client(config *http_clients.Config) (http_clients.GoPayLoaderClient, error) {
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: config.SkipVerify,
+// dialTCP dials connections tracked by dialed so they can be closed with requests in flight
+func dialTCP(config *http_clients.Config, dialed *http_clients.Conns) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dial := (&net.Dialer{}).DialContext
+	if config.Dialer != nil {
+		dial = config.Dialer.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return dialed.Track(conn)
 	}
+}
 
-	if config.MTLSCert != "" && config.MTLSKey != "" {
-		cert, err := tls.LoadX509KeyPair(config.MTLSCert, config.MTLSKey)
+func dialHTTP2(config *http_clients.Config, dialed *http_clients.Conns) func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	dial := dialTCP(config, dialed)
+	return func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+		tcpConn, err := dial(ctx, network, addr)
 		if err != nil {
 			return nil, err
 		}
-		tlsConfig.Certificates = []tls.Certificate{cert}
+		conn := tls.Client(tcpConn, cfg)
+		if err := conn.HandshakeContext(ctx); err != nil {
+			tcpConn.Close()
+			return nil, err
+		}
+
+		if p := conn.ConnectionState().NegotiatedProtocol; p != http2.NextProtoTLS {
+			conn.Close()
+			return nil, fmt.Errorf("http2: unexpected ALPN protocol %q; want %q", p, http2.NextProtoTLS)
+		}
+
+		if config.H2StreamWindow != 0 || config.H2ConnWindow != 0 {
+			return &flowConn{Conn: conn, streamWindow: config.H2StreamWindow, connWindow: config.H2ConnWindow}, nil
+		}
+		return conn, nil
 	}
+}
 
-	// todo timeout configs
+func GetNetHTTP3Client(config *http_clients.Config) (http_clients.GoPayLoaderClient, error) {
+	tlsConfig, err := http_clients.NewTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.QUIC0RTT && tlsConfig.ClientSessionCache == nil {
+		// 0-RTT needs a session ticket from a previous connection
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
 
 	roundTripper := &http3.RoundTripper{
-		TLSClientConfig: tlsConfig,
-		EnableDatagrams: true,
+		TLSClientConfig:    tlsConfig,
+		DisableCompression: true,
+		EnableDatagrams:    true,
+		QUICConfig: &quic.Config{
+			HandshakeIdleTimeout: config.QUICHandshakeTimeout,
+			MaxIdleTimeout:       config.QUICIdleTimeout,
+			KeepAlivePeriod:      config.QUICKeepAlive,
+			MaxIncomingStreams:   config.QUICMaxIncomingStreams,
+			EnableDatagrams:      true,
+			Tracer:               QUICTracer(config.OnQUICPathChange),
+		},
+		Dial: dialQUIC(config),
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
-		http2: false,
+		http2:   false,
+		zeroRTT: config.QUIC0RTT,
+		h3:      roundTripper,
+		ctx:     ctx,
+		cancel:  cancel,
 		client: &http.Client{
-			Transport: roundTripper,
+			Transport:     roundTripper,
+			Timeout:       config.ReadTimeout + config.WriteTimeout,
+			CheckRedirect: noRedirects,
 		},
 	}, nil
 }
+
+// QUICTracer calls onPathChange when the server validates a new path with a PATH_CHALLENGE, as it does when the client's
+// address changes i.e. after NAT rebinding. quic-go clients don't migrate connections themselves so these are the only
+// path changes.
+func QUICTracer(onPathChange func()) func(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer {
+	if onPathChange == nil {
+		return nil
+	}
+	return func(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer {
+		return &logging.ConnectionTracer{
+			ReceivedShortHeaderPacket: func(_ *logging.ShortHeader, _ logging.ByteCount, _ logging.ECN, frames []logging.Frame) {
+				for _, f := range frames {
+					if _, ok := f.(*logging.PathChallengeFrame); ok {
+						onPathChange()
+						return
+					}
+				}
+			},
+		}
+	}
+}
+
+func dialQUIC(config *http_clients.Config) func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	dial := quic.DialAddrEarly
+	if config.Dialer != nil {
+		dial = config.Dialer.DialQUIC
+	}
+
+	return func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+		start := time.Now()
+		conn, err := dial(ctx, addr, tlsCfg, cfg)
+		if err != nil || config.OnQUICHandshake == nil {
+			return conn, err
+		}
+
+		// early connections are returned before the handshake completes so 0-RTT data can be sent
+		go func() {
+			select {
+			case <-conn.HandshakeComplete():
+				config.OnQUICHandshake(time.Since(start), conn.ConnectionState().Used0RTT)
+			case <-conn.Context().Done():
+			}
+		}()
+		return conn, nil
+	}
+}
@@ -0,0 +1,128 @@
+package nethttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
+	"golang.org/x/net/http2"
+	"testing"
+	"time"
+)
+
+func TestClient_DoStreamSlotCancelled(t *testing.T) {
+	c, err := GetNetHTTP2Client(&http_clients.Config{ReqURI: "https://127.0.0.1:1", H2MaxStreams: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := c.(*Client)
+	// the only stream slot is held by a request in flight
+	client.streams[0] <- struct{}{}
+
+	req, err := client.NewReq("GET", "https://127.0.0.1:1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Do(req, client.NewResponse())
+	}()
+	client.CloseConns()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Do() error = %v, wanted %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Do() still waiting for a stream slot once the connections were closed")
+	}
+}
+
+func TestCheckPreface(t *testing.T) {
+	if err := checkPreface(); err != nil {
+		t.Errorf("checkPreface() error = %v, wanted the x/net preface layout to be supported", err)
+	}
+}
+
+// preface writes the client preface followed by frames written by write
+func preface(t *testing.T, write func(f *http2.Framer) error) []byte {
+	var b bytes.Buffer
+	b.WriteString(clientPreface)
+	if err := write(http2.NewFramer(&b, nil)); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestFlowConn_rewritePreface(t *testing.T) {
+	settings := func(f *http2.Framer) error {
+		return f.WriteSettings(http2.Setting{ID: http2.SettingEnablePush}, http2.Setting{ID: http2.SettingInitialWindowSize, Val: 4 << 20})
+	}
+	windowUpdate := func(f *http2.Framer) error {
+		return f.WriteWindowUpdate(0, 1<<30)
+	}
+	full := preface(t, func(f *http2.Framer) error {
+		return errors.Join(settings(f), windowUpdate(f))
+	})
+
+	tests := []struct {
+		name    string
+		written []byte
+		ok      bool
+		err     string
+	}{
+		{name: "rewritten", written: full, ok: true},
+		{name: "preface incomplete", written: full[:len(clientPreface)-1]},
+		{name: "window update incomplete", written: full[:len(full)-1]},
+		{name: "unexpected preface", written: []byte("GET / HTTP/1.1\r\n"), err: "http2: unexpected client preface; can't set flow control windows"},
+		{
+			name: "no initial window",
+			written: preface(t, func(f *http2.Framer) error {
+				return f.WriteSettings(http2.Setting{ID: http2.SettingEnablePush})
+			}),
+			err: "http2: client preface SETTINGS has no initial window size; can't set flow control windows",
+		},
+		{
+			name: "unexpected frame",
+			written: preface(t, func(f *http2.Framer) error {
+				return errors.Join(settings(f), f.WritePing(false, [8]byte{}))
+			}),
+			err: "http2: unexpected frame type 6 in client preface; can't set flow control windows",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &flowConn{streamWindow: 64 << 10, connWindow: 1 << 20}
+			out, ok, err := c.rewritePreface(tt.written)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("rewritePreface() error = %v, wanted %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil || ok != tt.ok {
+				t.Fatalf("rewritePreface() = %v, %v, wanted %v, no error", ok, err, tt.ok)
+			}
+			if !ok {
+				return
+			}
+
+			framer := http2.NewFramer(nil, bytes.NewReader(out[len(clientPreface):]))
+			frame, err := framer.ReadFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if window, _ := frame.(*http2.SettingsFrame).Value(http2.SettingInitialWindowSize); window != 64<<10 {
+				t.Errorf("wanted stream window %d got %d", 64<<10, window)
+			}
+			if frame, err = framer.ReadFrame(); err != nil {
+				t.Fatal(err)
+			}
+			if increment := frame.(*http2.WindowUpdateFrame).Increment; increment != 1<<20-defaultConnWindow {
+				t.Errorf("wanted connection window increment %d got %d", 1<<20-defaultConnWindow, increment)
+			}
+		})
+	}
+}
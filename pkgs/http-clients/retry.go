@@ -0,0 +1,122 @@
+package http_clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	RetryOnReset   = "reset"
+	RetryOnTimeout = "timeout"
+	RetryOn5xx     = "5xx"
+)
+
+// RetryPolicy sends requests failing with a retryable response code or error up to Attempts times, the first attempt
+// included, backing off exponentially from Backoff up to MaxBackoff between attempts
+type RetryPolicy struct {
+	Attempts   int
+	Statuses   map[int]bool
+	All5xx     bool
+	Reset      bool
+	Timeout    bool
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Jitter is the fraction of each backoff drawn at random, 1 is full jitter
+	Jitter float64
+}
+
+// ParseRetryOn parses what's retried, response codes i.e. 503, 5xx for any server error, reset for connections
+// reset or closed by the server and timeout for read/write timeouts
+func ParseRetryOn(on []string) (*RetryPolicy, error) {
+	p := &RetryPolicy{Statuses: make(map[int]bool)}
+	for _, o := range on {
+		switch o = strings.ToLower(strings.TrimSpace(o)); o {
+		case RetryOnReset:
+			p.Reset = true
+		case RetryOnTimeout:
+			p.Timeout = true
+		case RetryOn5xx:
+			p.All5xx = true
+		default:
+			code, err := strconv.Atoi(o)
+			if err != nil || code < 100 || code > 599 {
+				return nil, fmt.Errorf("retry: invalid condition %s, expected a response code, %s, %s or %s", o,
+					RetryOn5xx, RetryOnReset, RetryOnTimeout)
+			}
+			p.Statuses[code] = true
+		}
+	}
+	return p, nil
+}
+
+// Retryable returns true if a request failing with err, or receiving status if there's no error, should be retried
+func (p *RetryPolicy) Retryable(status int, err error) bool {
+	if err != nil {
+		return (p.Reset && IsReset(err)) || (p.Timeout && IsTimeout(err))
+	}
+	return p.Statuses[status] || (p.All5xx && status >= 500 && status <= 599)
+}
+
+// Delay is the backoff before the retry'th retry
+func (p *RetryPolicy) Delay(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxBackoff)
+	// global rand as requests sent in parallel back off concurrently
+	return d - time.Duration(float64(d)*p.Jitter*rand.Float64())
+}
+
+// ParseRetryAfter parses a Retry-After header of delay seconds or an HTTP date, returning false if it's missing or
+// invalid
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(at.Sub(now), 0), true
+}
+
+// IsReset returns true if the connection was reset or closed by the server before the response was received
+func IsReset(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// not all clients wrap the underlying error
+	msg := err.Error()
+	return strings.Contains(msg, "connection reset") || strings.Contains(msg, "broken pipe") ||
+		strings.Contains(msg, "server closed connection") || strings.HasSuffix(msg, "EOF")
+}
+
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "timeout") || strings.Contains(msg, "timed out")
+}
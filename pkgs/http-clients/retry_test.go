@@ -0,0 +1,106 @@
+package http_clients
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseRetryOn(t *testing.T) {
+	tests := []struct {
+		name string
+		on   []string
+		want RetryPolicy
+		err  string
+	}{
+		{name: "codes", on: []string{"503", " 429 "}, want: RetryPolicy{Statuses: map[int]bool{503: true, 429: true}}},
+		{name: "conditions", on: []string{"5XX", "reset", "Timeout"}, want: RetryPolicy{Statuses: map[int]bool{}, All5xx: true, Reset: true, Timeout: true}},
+		{name: "unknown condition", on: []string{"teapot"}, err: "retry: invalid condition teapot, expected a response code, 5xx, reset or timeout"},
+		{name: "code out of range", on: []string{"600"}, err: "retry: invalid condition 600, expected a response code, 5xx, reset or timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRetryOn(tt.on)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("ParseRetryOn() error = %v, wanted %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRetryOn() error = %v, wanted no error", err)
+			}
+			if len(got.Statuses) != len(tt.want.Statuses) || got.All5xx != tt.want.All5xx || got.Reset != tt.want.Reset || got.Timeout != tt.want.Timeout {
+				t.Errorf("ParseRetryOn() = %+v, wanted %+v", got, tt.want)
+			}
+			for code := range tt.want.Statuses {
+				if !got.Statuses[code] {
+					t.Errorf("ParseRetryOn() wanted %d retried got %v", code, got.Statuses)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Retryable(t *testing.T) {
+	policy := &RetryPolicy{Statuses: map[int]bool{429: true}, Reset: true}
+	all5xx := &RetryPolicy{All5xx: true, Timeout: true}
+
+	tests := []struct {
+		name   string
+		policy *RetryPolicy
+		status int
+		err    error
+		want   bool
+	}{
+		{name: "listed code", policy: policy, status: 429, want: true},
+		{name: "unlisted code", policy: policy, status: 503},
+		{name: "success", policy: all5xx, status: 200},
+		{name: "any 5xx", policy: all5xx, status: 504, want: true},
+		{name: "reset", policy: policy, err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, want: true},
+		{name: "eof", policy: policy, err: io.EOF, want: true},
+		{name: "reset not retried", policy: all5xx, err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}},
+		{name: "timeout", policy: all5xx, err: &net.OpError{Op: "read", Err: timeoutErr{}}, want: true},
+		{name: "timeout not retried", policy: policy, err: &net.OpError{Op: "read", Err: timeoutErr{}}},
+		{name: "other error", policy: policy, err: errors.New("malformed HTTP response")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Retryable(tt.status, tt.err); got != tt.want {
+				t.Errorf("Retryable(%d, %v) = %v, wanted %v", tt.status, tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	tests := []struct {
+		name   string
+		retry  int
+		jitter float64
+		min    time.Duration
+		max    time.Duration
+	}{
+		{name: "first retry", retry: 1, min: 100 * time.Millisecond, max: 100 * time.Millisecond},
+		{name: "doubled", retry: 3, min: 400 * time.Millisecond, max: 400 * time.Millisecond},
+		{name: "capped", retry: 10, min: time.Second, max: time.Second},
+		{name: "full jitter", retry: 2, jitter: 1, min: 0, max: 200 * time.Millisecond},
+		{name: "half jitter", retry: 2, jitter: 0.5, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: tt.jitter}
+			for i := 0; i < 100; i++ {
+				if got := policy.Delay(tt.retry); got < tt.min || got > tt.max {
+					t.Fatalf("Delay(%d) = %s, wanted %s to %s", tt.retry, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package http_clients
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+const (
+	ThinkConstant    = "constant"
+	ThinkUniform     = "uniform"
+	ThinkExponential = "exponential"
+	ThinkNormal      = "normal"
+)
+
+// ThinkTime is the distribution of pauses a virtual user takes between requests
+type ThinkTime struct {
+	Dist string
+	// Mean is the constant pause, or the mean of exponential and normal pauses
+	Mean time.Duration
+	// Min and Max bound uniform pauses
+	Min    time.Duration
+	Max    time.Duration
+	StdDev time.Duration
+}
+
+// ParseThinkTime parses a think time in the format <dist>:<params> i.e. constant:500ms, uniform:100ms,900ms,
+// exponential:500ms (mean) or normal:500ms,100ms (mean, standard deviation). A bare duration is constant.
+func ParseThinkTime(spec string) (*ThinkTime, error) {
+	dist, params, found := strings.Cut(spec, ":")
+	if !found {
+		dist, params = ThinkConstant, spec
+	}
+
+	var durations []time.Duration
+	for _, p := range strings.Split(params, ",") {
+		d, err := time.ParseDuration(p)
+		if err != nil {
+			return nil, fmt.Errorf("think time: invalid duration %s in %s", p, spec)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("think time: negative duration %s in %s", p, spec)
+		}
+		durations = append(durations, d)
+	}
+
+	want := 1
+	if dist == ThinkUniform || dist == ThinkNormal {
+		want = 2
+	}
+	switch dist {
+	case ThinkConstant, ThinkUniform, ThinkExponential, ThinkNormal:
+	default:
+		return nil, fmt.Errorf("think time: unknown distribution %s, expected one of %s, %s, %s, %s", dist,
+			ThinkConstant, ThinkUniform, ThinkExponential, ThinkNormal)
+	}
+	if len(durations) != want {
+		return nil, fmt.Errorf("think time: %s takes %d duration/s, got %s", dist, want, params)
+	}
+
+	t := &ThinkTime{Dist: dist}
+	switch dist {
+	case ThinkUniform:
+		t.Min, t.Max = durations[0], durations[1]
+		if t.Min > t.Max {
+			return nil, fmt.Errorf("think time: uniform min %s is more than max %s", t.Min, t.Max)
+		}
+	case ThinkNormal:
+		t.Mean, t.StdDev = durations[0], durations[1]
+	default:
+		t.Mean = durations[0]
+	}
+	return t, nil
+}
+
+// Next returns the next pause drawn from r, normal pauses below zero are cut to zero
+func (t *ThinkTime) Next(r *rand.Rand) time.Duration {
+	switch t.Dist {
+	case ThinkUniform:
+		if t.Max == t.Min {
+			return t.Min
+		}
+		return t.Min + time.Duration(r.Int64N(int64(t.Max-t.Min)+1))
+	case ThinkExponential:
+		return time.Duration(r.ExpFloat64() * float64(t.Mean))
+	case ThinkNormal:
+		return max(0, time.Duration(r.NormFloat64()*float64(t.StdDev))+t.Mean)
+	}
+	return t.Mean
+}
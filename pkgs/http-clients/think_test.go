@@ -0,0 +1,88 @@
+package http_clients
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseThinkTime(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		want *ThinkTime
+		err  string
+	}{
+		{name: "bare duration", spec: "500ms", want: &ThinkTime{Dist: ThinkConstant, Mean: 500 * time.Millisecond}},
+		{name: "constant", spec: "constant:1s", want: &ThinkTime{Dist: ThinkConstant, Mean: time.Second}},
+		{name: "uniform", spec: "uniform:100ms,900ms", want: &ThinkTime{Dist: ThinkUniform, Min: 100 * time.Millisecond, Max: 900 * time.Millisecond}},
+		{name: "exponential", spec: "exponential:500ms", want: &ThinkTime{Dist: ThinkExponential, Mean: 500 * time.Millisecond}},
+		{name: "normal", spec: "normal:500ms,100ms", want: &ThinkTime{Dist: ThinkNormal, Mean: 500 * time.Millisecond, StdDev: 100 * time.Millisecond}},
+		{name: "invalid duration", spec: "constant:soon", err: "think time: invalid duration soon in constant:soon"},
+		{name: "negative duration", spec: "-1s", err: "think time: negative duration -1s in -1s"},
+		{name: "unknown distribution", spec: "poisson:1s", err: "think time: unknown distribution poisson, expected one of constant, uniform, exponential, normal"},
+		{name: "too few durations", spec: "uniform:100ms", err: "think time: uniform takes 2 duration/s, got 100ms"},
+		{name: "too many durations", spec: "exponential:1s,2s", err: "think time: exponential takes 1 duration/s, got 1s,2s"},
+		{name: "uniform min above max", spec: "uniform:1s,100ms", err: "think time: uniform min 1s is more than max 100ms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseThinkTime(tt.spec)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("ParseThinkTime() error = %v, wanted %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseThinkTime() error = %v, wanted no error", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseThinkTime() = %+v, wanted %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThinkTime_Next(t *testing.T) {
+	const draws = 10000
+
+	tests := []struct {
+		name  string
+		think ThinkTime
+		// min and max bound every pause, mean is the wanted average within 5%
+		min  time.Duration
+		max  time.Duration
+		mean time.Duration
+	}{
+		{name: "constant", think: ThinkTime{Dist: ThinkConstant, Mean: time.Second}, min: time.Second, max: time.Second, mean: time.Second},
+		{name: "uniform", think: ThinkTime{Dist: ThinkUniform, Min: 100 * time.Millisecond, Max: 900 * time.Millisecond}, min: 100 * time.Millisecond, max: 900 * time.Millisecond, mean: 500 * time.Millisecond},
+		{name: "uniform without range", think: ThinkTime{Dist: ThinkUniform, Min: time.Second, Max: time.Second}, min: time.Second, max: time.Second, mean: time.Second},
+		{name: "exponential", think: ThinkTime{Dist: ThinkExponential, Mean: 500 * time.Millisecond}, max: time.Hour, mean: 500 * time.Millisecond},
+		{name: "normal", think: ThinkTime{Dist: ThinkNormal, Mean: 500 * time.Millisecond, StdDev: 100 * time.Millisecond}, max: time.Hour, mean: 500 * time.Millisecond},
+		// cut to zero rather than drawn negative
+		{name: "normal cut at zero", think: ThinkTime{Dist: ThinkNormal, StdDev: 100 * time.Millisecond}, max: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rand.New(rand.NewPCG(1, 2))
+			var total time.Duration
+			for i := 0; i < draws; i++ {
+				d := tt.think.Next(r)
+				if d < tt.min || d > tt.max {
+					t.Fatalf("Next() = %s, wanted %s to %s", d, tt.min, tt.max)
+				}
+				total += d
+			}
+			if tt.mean == 0 {
+				return
+			}
+			if mean := total / draws; mean < tt.mean*95/100 || mean > tt.mean*105/100 {
+				t.Errorf("wanted mean pause %s got %s", tt.mean, mean)
+			}
+		})
+	}
+}
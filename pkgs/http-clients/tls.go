@@ -0,0 +1,193 @@
+package http_clients
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"x25519": tls.X25519,
+	"p-256":  tls.CurveP256,
+	"p-384":  tls.CurveP384,
+	"p-521":  tls.CurveP521,
+}
+
+// ParseTLSVersion parses a TLS version in the format 1.2 or tls1.2
+func ParseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(version), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("tls: unknown version %s, expected one of 1.0, 1.1, 1.2, 1.3", version)
+	}
+	return v, nil
+}
+
+// ParseCipherSuites parses cipher suite names as returned by tls.CipherSuiteName
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	suites := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+	ids := make([]uint16, 0, len(names))
+
+	for _, name := range names {
+		found := false
+		for _, s := range suites {
+			if strings.EqualFold(s.Name, name) {
+				ids = append(ids, s.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("tls: unknown cipher suite %s", name)
+		}
+	}
+	return ids, nil
+}
+
+// ParseCurves parses curve names i.e. X25519, P-256
+func ParseCurves(names []string) ([]tls.CurveID, error) {
+	curves := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		c, ok := tlsCurves[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown curve %s, expected one of X25519, P-256, P-384, P-521", name)
+		}
+		curves = append(curves, c)
+	}
+	return curves, nil
+}
+
+// MTLSIdentity is a client cert/key pair, Name identifies it in results
+type MTLSIdentity struct {
+	Name string
+	Cert string
+	Key  string
+}
+
+// MTLSIdentitiesFromDir finds cert/key pairs in dir, certs named <name>.crt or <name>.pem are paired with keys named
+// <name>.key or <name>-key.pem
+func MTLSIdentitiesFromDir(dir string) ([]MTLSIdentity, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	identities := make([]MTLSIdentity, 0)
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".crt" && ext != ".pem") || strings.HasSuffix(e.Name(), "-key.pem") {
+			continue
+		}
+
+		name := strings.TrimSuffix(e.Name(), ext)
+		for _, key := range []string{name + ".key", name + "-key.pem"} {
+			if _, err := os.Stat(filepath.Join(dir, key)); err == nil {
+				identities = append(identities, MTLSIdentity{
+					Name: name,
+					Cert: filepath.Join(dir, e.Name()),
+					Key:  filepath.Join(dir, key),
+				})
+				break
+			}
+		}
+	}
+
+	if len(identities) == 0 {
+		return nil, errors.New("tls: no cert/key pairs found in " + dir)
+	}
+	return identities, nil
+}
+
+// LoadCACert loads a PEM encoded CA bundle
+func LoadCACert(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("tls: no certificates found in CA bundle " + path)
+	}
+	return pool, nil
+}
+
+// NewTLSConfig builds the client TLS config shared by all clients
+func NewTLSConfig(config *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.SkipVerify,
+		ServerName:         config.SNI,
+		NextProtos:         config.ALPN,
+	}
+
+	if config.MTLSCert != "" && config.MTLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(config.MTLSCert, config.MTLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.CACert != "" {
+		pool, err := LoadCACert(config.CACert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.TLSMin != "" {
+		v, err := ParseTLSVersion(config.TLSMin)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.MinVersion = v
+	}
+
+	if config.TLSMax != "" {
+		v, err := ParseTLSVersion(config.TLSMax)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.MaxVersion = v
+	}
+
+	if len(config.TLSCiphers) > 0 {
+		ciphers, err := ParseCipherSuites(config.TLSCiphers)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = ciphers
+	}
+
+	if len(config.TLSCurves) > 0 {
+		curves, err := ParseCurves(config.TLSCurves)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CurvePreferences = curves
+	}
+
+	if config.SessionResumption {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+
+	if config.OnTLSHandshake != nil {
+		// called on every handshake including resumed sessions
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			config.OnTLSHandshake(state)
+			return nil
+		}
+	}
+
+	return tlsConfig, nil
+}
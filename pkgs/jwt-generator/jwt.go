@@ -24,6 +24,9 @@ const (
 	batchSize = 1000000
 )
 
+// ErrCancelled is returned when generation is cancelled, JWTs already saved stay cached for the next run
+var ErrCancelled = errors.New("jwt generation cancelled")
+
 type Config struct {
 	Ctx                 context.Context
 	Kid                 string
@@ -195,7 +198,7 @@ func (j *JWTGenerator) batchGenSave(reqJwtAmount, batchSize int64) error {
 		select {
 		case <-j.config.Ctx.Done():
 			// user cancelled
-			return errors.New("jwt generation cancelled")
+			return fmt.Errorf("%w; %d jwts cached for next run", ErrCancelled, j.config.store.getJwtCount())
 		case err := <-errs:
 			return err
 		case tokens = <-resp:
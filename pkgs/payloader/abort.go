@@ -0,0 +1,151 @@
+package payloader
+
+import (
+	"fmt"
+	"github.com/domsolutions/gopayloader/config"
+	"github.com/domsolutions/gopayloader/pkgs/payloader/histogram"
+	"github.com/domsolutions/gopayloader/pkgs/payloader/worker"
+	"time"
+)
+
+// abortCheckEvery is how often the failed requests limit is checked
+const abortCheckEvery = 100 * time.Millisecond
+
+// abortError is the cause the run's context is cancelled with when an abort condition is met
+type abortError struct {
+	reason string
+}
+
+func (e *abortError) Error() string {
+	return e.reason
+}
+
+// abortSlot holds a second of the rolling window
+type abortSlot struct {
+	completed int64
+	failed    int64
+	latencies *histogram.Histogram
+}
+
+// aborter checks the abort conditions every second, error rate and p99 latency are checked over a rolling window of
+// one second slots once the first window has passed. Not safe for concurrent use, calcReqStats drives it.
+// Each slot's latencies are the difference between the workers' latencies at the start and end of its second.
+type aborter struct {
+	errorRate   float64
+	afterErrors uint64
+	p99         time.Duration
+	window      time.Duration
+	slots       []abortSlot
+	current     int
+	ticks       int
+	completed   int64
+	failed      int64
+	merged      *histogram.Histogram
+	// latencies are all latencies recorded by the last tick
+	latencies *histogram.Histogram
+	next      *histogram.Histogram
+}
+
+func newAborter(c *config.Config) *aborter {
+	if c.AbortErrorRatio == 0 && c.AbortAfterErrors == 0 && c.AbortP99 == 0 {
+		return nil
+	}
+
+	seconds := int((c.AbortWindow + time.Second - 1) / time.Second)
+	a := &aborter{
+		errorRate:   c.AbortErrorRatio,
+		afterErrors: c.AbortAfterErrors,
+		p99:         c.AbortP99,
+		window:      time.Duration(seconds) * time.Second,
+		slots:       make([]abortSlot, seconds),
+		merged:      histogram.New(),
+	}
+	if a.p99 != 0 {
+		for i := range a.slots {
+			a.slots[i].latencies = histogram.New()
+		}
+		a.latencies, a.next = histogram.New(), histogram.New()
+	}
+	return a
+}
+
+// checkErrors returns an error if the workers' failed requests reached the limit, it's checked more often than the
+// window so runs failing fast don't overshoot the limit by a second's worth of errors
+func (a *aborter) checkErrors(workers []worker.Worker) error {
+	if a.afterErrors == 0 {
+		return nil
+	}
+
+	var failed int64
+	for _, w := range workers {
+		_, f := w.Counts()
+		failed += f
+	}
+	if uint64(failed) >= a.afterErrors {
+		return &abortError{reason: fmt.Sprintf("%d errors reached limit of %d", failed, a.afterErrors)}
+	}
+	return nil
+}
+
+// tick closes the current slot with the workers' totals, mergeLatencies adds the workers' latencies to a histogram.
+// Returns an error if an abort condition is met.
+func (a *aborter) tick(workers []worker.Worker, mergeLatencies func(h *histogram.Histogram)) error {
+	var completed, failed int64
+	for _, w := range workers {
+		c, f := w.Counts()
+		completed += c
+		failed += f
+	}
+
+	slot := &a.slots[a.current]
+	slot.completed, slot.failed = completed-a.completed, failed-a.failed
+	a.completed, a.failed = completed, failed
+	if a.p99 != 0 {
+		a.next.Reset()
+		mergeLatencies(a.next)
+		slot.latencies.Reset()
+		slot.latencies.Merge(a.next)
+		slot.latencies.Subtract(a.latencies)
+		a.latencies, a.next = a.next, a.latencies
+	}
+	a.ticks++
+
+	err := a.check()
+
+	a.current = (a.current + 1) % len(a.slots)
+	a.slots[a.current].completed, a.slots[a.current].failed = 0, 0
+	return err
+}
+
+func (a *aborter) check() error {
+	if a.afterErrors != 0 && uint64(a.failed) >= a.afterErrors {
+		return &abortError{reason: fmt.Sprintf("%d errors reached limit of %d", a.failed, a.afterErrors)}
+	}
+	if a.ticks < len(a.slots) {
+		// first window hasn't passed
+		return nil
+	}
+
+	var completed, failed int64
+	a.merged.Reset()
+	for _, s := range a.slots {
+		completed += s.completed
+		failed += s.failed
+		if s.latencies != nil {
+			a.merged.Merge(s.latencies)
+		}
+	}
+
+	if a.errorRate != 0 && completed+failed > 0 {
+		if rate := float64(failed) / float64(completed+failed); rate >= a.errorRate {
+			return &abortError{reason: fmt.Sprintf("error rate %.2f%% over last %s reached limit of %.2f%%",
+				rate*100, a.window, a.errorRate*100)}
+		}
+	}
+	if a.p99 != 0 && a.merged.Count() > 0 {
+		if p99 := time.Duration(a.merged.Percentile(99)); p99 >= a.p99 {
+			return &abortError{reason: fmt.Sprintf("p99 latency %s over last %s reached limit of %s", p99, a.window, a.p99)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to a run's control API
+type Client struct {
+	addr   string
+	client *http.Client
+}
+
+func NewClient(addr string) *Client {
+	return &Client{addr: addr, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (c *Client) Stats() (*Snapshot, error) {
+	return c.do(http.MethodGet, "stats", nil)
+}
+
+func (c *Client) Pause() (*Snapshot, error) {
+	return c.do(http.MethodPost, "pause", nil)
+}
+
+func (c *Client) Resume() (*Snapshot, error) {
+	return c.do(http.MethodPost, "resume", nil)
+}
+
+// SetRate caps the run's requests per second, 0 removes the cap
+func (c *Client) SetRate(rps float64) (*Snapshot, error) {
+	return c.do(http.MethodPost, "rate", url.Values{"rps": {strconv.FormatFloat(rps, 'f', -1, 64)}})
+}
+
+// SetConns sets how many connections, or workers when connections are shared, send requests
+func (c *Client) SetConns(n int) (*Snapshot, error) {
+	return c.do(http.MethodPost, "conns", url.Values{"n": {strconv.Itoa(n)}})
+}
+
+func (c *Client) do(method, endpoint string, query url.Values) (*Snapshot, error) {
+	u := url.URL{Scheme: "http", Host: c.addr, Path: "/" + endpoint, RawQuery: query.Encode()}
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("control: failed to reach control API on %s; %v", c.addr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("control: %s failed; %s", endpoint, strings.TrimSpace(string(body)))
+	}
+
+	snapshot := &Snapshot{}
+	if err := json.NewDecoder(resp.Body).Decode(snapshot); err != nil {
+		return nil, fmt.Errorf("control: invalid response; %v", err)
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,123 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Snapshot is the live state of a run returned by every control API endpoint
+type Snapshot struct {
+	Elapsed   time.Duration     `json:"elapsed"`
+	Paused    bool              `json:"paused"`
+	Rate      float64           `json:"rate"`
+	Active    int               `json:"active"`
+	Workers   int               `json:"workers"`
+	Completed int64             `json:"completed"`
+	Failed    int64             `json:"failed"`
+	Inflight  int64             `json:"inflight"`
+	RPS       float64           `json:"rps"`
+	Responses map[int]int64     `json:"responses"`
+	Errors    map[string]uint64 `json:"errors"`
+	// Examples holds an error of each category in Errors
+	Examples map[string]string `json:"error_examples"`
+}
+
+// Server serves the control API, pausing, resuming and changing the rate or active workers of a run through its gate
+type Server struct {
+	gate     *http_clients.Gate
+	snapshot func() Snapshot
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen listens on addr, snapshot returns the run's stats which the gate's state is added to
+func Listen(addr string, gate *http_clients.Gate, snapshot func() Snapshot) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("control: failed to listen on %s; %v", addr, err)
+	}
+
+	s := &Server{gate: gate, snapshot: snapshot, listener: listener}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", s.handleStats)
+	mux.HandleFunc("POST /pause", s.handlePause)
+	mux.HandleFunc("POST /resume", s.handleResume)
+	mux.HandleFunc("POST /rate", s.handleRate)
+	mux.HandleFunc("POST /conns", s.handleConns)
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Serve serves requests until Close
+func (s *Server) Serve() error {
+	if err := s.server.Serve(s.listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	s.respond(w)
+}
+
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	s.gate.Pause()
+	s.respond(w)
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	s.gate.Resume()
+	s.respond(w)
+}
+
+func (s *Server) handleRate(w http.ResponseWriter, r *http.Request) {
+	rps, err := strconv.ParseFloat(r.URL.Query().Get("rps"), 64)
+	if err != nil || rps < 0 {
+		http.Error(w, "rps must be a number of requests per second, 0 removes the limit", http.StatusBadRequest)
+		return
+	}
+	s.gate.SetRate(rps)
+	s.respond(w)
+}
+
+func (s *Server) handleConns(w http.ResponseWriter, r *http.Request) {
+	workers := s.gate.State().Workers
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n < 1 || n > workers {
+		http.Error(w, fmt.Sprintf("n must be between 1 and %d", workers), http.StatusBadRequest)
+		return
+	}
+	if err := s.gate.SetActive(n); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.respond(w)
+}
+
+func (s *Server) respond(w http.ResponseWriter) {
+	snapshot := s.snapshot()
+	state := s.gate.State()
+	snapshot.Paused, snapshot.Rate, snapshot.Active, snapshot.Workers = state.Paused, state.Rate, state.Active, state.Workers
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
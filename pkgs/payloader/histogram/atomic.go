@@ -0,0 +1,78 @@
+package histogram
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Atomic records int64 values into the same buckets as Histogram without locking so it can be recorded into by
+// concurrent requests, it's read by merging it into a Histogram.
+type Atomic struct {
+	counts [numBuckets]atomic.Uint64
+	min    atomic.Int64
+	max    atomic.Int64
+	sum    atomic.Int64
+}
+
+func NewAtomic() *Atomic {
+	a := &Atomic{}
+	a.min.Store(math.MaxInt64)
+	return a
+}
+
+func (a *Atomic) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	for {
+		cur := a.min.Load()
+		if v >= cur || a.min.CompareAndSwap(cur, v) {
+			break
+		}
+	}
+	for {
+		cur := a.max.Load()
+		if v <= cur || a.max.CompareAndSwap(cur, v) {
+			break
+		}
+	}
+	a.sum.Add(v)
+
+	if v > maxValue {
+		v = maxValue
+	}
+	a.counts[bucket(v)].Add(1)
+}
+
+// Reset discards the values recorded so far, it mustn't be recorded into while reset
+func (a *Atomic) Reset() {
+	for i := range a.counts {
+		a.counts[i].Store(0)
+	}
+	a.min.Store(math.MaxInt64)
+	a.max.Store(0)
+	a.sum.Store(0)
+}
+
+// MergeAtomic adds the values recorded in a to h. Values recorded while merging may be partly merged, the count is
+// taken from the buckets so percentiles stay consistent.
+func (h *Histogram) MergeAtomic(a *Atomic) {
+	var count uint64
+	for i := range a.counts {
+		c := a.counts[i].Load()
+		h.counts[i] += c
+		count += c
+	}
+	if count == 0 {
+		return
+	}
+
+	if min := a.min.Load(); h.count == 0 || min < h.min {
+		h.min = min
+	}
+	if max := a.max.Load(); max > h.max {
+		h.max = max
+	}
+	h.sum += a.sum.Load()
+	h.count += count
+}
@@ -0,0 +1,59 @@
+package histogram
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestHistogram_MergeAtomic(t *testing.T) {
+	tests := []struct {
+		name      string
+		recorders int
+		values    int64
+	}{
+		{name: "nothing recorded", recorders: 1},
+		{name: "single recorder", recorders: 1, values: 1000},
+		{name: "concurrent recorders", recorders: 8, values: 10000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAtomic()
+			want := New()
+			var wg sync.WaitGroup
+			for r := 0; r < tt.recorders; r++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for v := int64(1); v <= tt.values; v++ {
+						a.Record(v)
+					}
+				}()
+				for v := int64(1); v <= tt.values; v++ {
+					want.Record(v)
+				}
+			}
+			wg.Wait()
+
+			got := New()
+			got.MergeAtomic(a)
+			if got.counts != want.counts || got.Count() != want.Count() || got.Sum() != want.Sum() || got.Min() != want.Min() || got.Max() != want.Max() {
+				t.Errorf("wanted count %d, sum %d, min %d, max %d got %d, %d, %d, %d", want.Count(), want.Sum(), want.Min(),
+					want.Max(), got.Count(), got.Sum(), got.Min(), got.Max())
+			}
+		})
+	}
+}
+
+func TestAtomic_Reset(t *testing.T) {
+	a := NewAtomic()
+	a.Record(500)
+	a.Reset()
+	a.Record(7)
+
+	h := New()
+	h.MergeAtomic(a)
+	if h.Count() != 1 || h.Min() != 7 || h.Max() != 7 {
+		t.Errorf("wanted only the value recorded after the reset got count %d, min %d, max %d", h.Count(), h.Min(), h.Max())
+	}
+}
@@ -0,0 +1,150 @@
+package histogram
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// subBucketBits sets precision, 32 sub buckets per power of 2 gives ~3% error
+	subBucketBits  = 5
+	subBucketCount = 1 << subBucketBits
+	// maxBits caps recordable values at 2^40 i.e. ~18 minutes when recording nanoseconds
+	maxBits    = 40
+	maxValue   = int64(1)<<maxBits - 1
+	numBuckets = (maxBits-subBucketBits+1)*subBucketCount + subBucketCount
+)
+
+// Histogram records int64 values into log-linear buckets so percentiles can be computed in constant memory.
+// Not safe for concurrent use.
+type Histogram struct {
+	counts [numBuckets]uint64
+	count  uint64
+	min    int64
+	max    int64
+	sum    int64
+}
+
+func New() *Histogram {
+	return &Histogram{}
+}
+
+func bucket(v int64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketBits - 1
+	return (shift+1)*subBucketCount + int(v>>shift) - subBucketCount
+}
+
+// upperBound returns the highest value which would be recorded in bucket i
+func upperBound(i int) int64 {
+	if i < subBucketCount {
+		return int64(i)
+	}
+	shift := i/subBucketCount - 1
+	sub := int64(i%subBucketCount + subBucketCount)
+	return (sub+1)<<shift - 1
+}
+
+func (h *Histogram) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.sum += v
+	h.count++
+
+	if v > maxValue {
+		v = maxValue
+	}
+	h.counts[bucket(v)]++
+}
+
+// Merge adds all values recorded in o to h
+func (h *Histogram) Merge(o *Histogram) {
+	if o.count == 0 {
+		return
+	}
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+	h.sum += o.sum
+	h.count += o.count
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+}
+
+// Subtract removes the values recorded in o from h, o must have been merged into h. The min and max aren't known once
+// values are removed so h keeps its own.
+func (h *Histogram) Subtract(o *Histogram) {
+	h.sum -= o.sum
+	h.count -= o.count
+	for i, c := range o.counts {
+		h.counts[i] -= c
+	}
+}
+
+func (h *Histogram) Reset() {
+	*h = Histogram{}
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+func (h *Histogram) Min() int64 {
+	return h.min
+}
+
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+func (h *Histogram) Sum() int64 {
+	return h.sum
+}
+
+func (h *Histogram) Mean() int64 {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / int64(h.count)
+}
+
+// Percentile returns the value below which p percent of recorded values fall, p is in the range 0-100
+func (h *Histogram) Percentile(p float64) int64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(p / 100 * float64(h.count)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			v := upperBound(i)
+			// bucket bounds are approximate, never report outside observed range
+			if v > h.max {
+				return h.max
+			}
+			if v < h.min {
+				return h.min
+			}
+			return v
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,107 @@
+package histogram
+
+import (
+	"testing"
+)
+
+// record returns a histogram of the values 1 to n
+func record(n int64) *Histogram {
+	h := New()
+	for v := int64(1); v <= n; v++ {
+		h.Record(v)
+	}
+	return h
+}
+
+func TestHistogram_Percentile(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []int64
+		p      float64
+		want   int64
+	}{
+		{name: "empty", p: 50, want: 0},
+		{name: "single value", values: []int64{42}, p: 99, want: 42},
+		{name: "exact below sub buckets", values: []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, p: 50, want: 5},
+		{name: "p0 is the min", values: []int64{7, 100, 1000}, p: 0, want: 7},
+		{name: "p100 is the max", values: []int64{7, 100, 1000}, p: 100, want: 1000},
+		{name: "negative recorded as 0", values: []int64{-5, -1}, p: 100, want: 0},
+		{name: "above max value reports the max", values: []int64{maxValue + 100}, p: 50, want: maxValue + 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			for _, v := range tt.values {
+				h.Record(v)
+			}
+			if got := h.Percentile(tt.p); got != tt.want {
+				t.Errorf("Percentile(%v) = %d, wanted %d", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogram_PercentileError(t *testing.T) {
+	h := record(1_000_000)
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		want := int64(p / 100 * 1_000_000)
+		got := h.Percentile(p)
+		// 32 sub buckets per power of 2 bounds the error to ~3%
+		if diff := float64(got-want) / float64(want); diff < -0.035 || diff > 0.035 {
+			t.Errorf("Percentile(%v) = %d, wanted %d within 3.5%%", p, got, want)
+		}
+	}
+	if h.Count() != 1_000_000 || h.Min() != 1 || h.Max() != 1_000_000 || h.Mean() != 500_000 {
+		t.Errorf("wanted count 1000000, min 1, max 1000000, mean 500000 got %d, %d, %d, %d", h.Count(), h.Min(), h.Max(), h.Mean())
+	}
+}
+
+func TestHistogram_MergeSubtract(t *testing.T) {
+	tests := []struct {
+		name      string
+		a, b      int64
+		wantCount uint64
+		wantMin   int64
+		wantMax   int64
+	}{
+		{name: "into empty", b: 100, wantCount: 100, wantMin: 1, wantMax: 100},
+		{name: "empty into", a: 100, wantCount: 100, wantMin: 1, wantMax: 100},
+		{name: "both", a: 10, b: 1000, wantCount: 1010, wantMin: 1, wantMax: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, o := record(tt.a), record(tt.b)
+			before := *h
+			h.Merge(o)
+			if h.Count() != tt.wantCount || h.Min() != tt.wantMin || h.Max() != tt.wantMax || h.Sum() != before.Sum()+o.Sum() {
+				t.Errorf("wanted count %d, min %d, max %d, sum %d got %d, %d, %d, %d", tt.wantCount, tt.wantMin, tt.wantMax,
+					before.Sum()+o.Sum(), h.Count(), h.Min(), h.Max(), h.Sum())
+			}
+
+			h.Subtract(o)
+			if h.counts != before.counts || h.Count() != before.Count() || h.Sum() != before.Sum() {
+				t.Errorf("wanted merged values subtracted got count %d, sum %d", h.Count(), h.Sum())
+			}
+		})
+	}
+}
+
+func TestHistogram_Reset(t *testing.T) {
+	h := record(100)
+	h.Reset()
+	if h.Count() != 0 || h.Max() != 0 || h.Percentile(50) != 0 {
+		t.Errorf("wanted an empty histogram got count %d, max %d", h.Count(), h.Max())
+	}
+}
+
+func TestBucket(t *testing.T) {
+	// every value falls in a bucket whose upper bound isn't below it, and above the previous bucket's
+	for _, v := range []int64{0, 1, subBucketCount - 1, subBucketCount, 100, 1000, 123456789, maxValue} {
+		i := bucket(v)
+		if upperBound(i) < v || (i > 0 && upperBound(i-1) >= v) {
+			t.Errorf("value %d in bucket %d with bounds (%d, %d]", v, i, upperBound(i-1), upperBound(i))
+		}
+	}
+}
@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 	"github.com/domsolutions/gopayloader/pkgs/payloader"
+	"github.com/domsolutions/gopayloader/pkgs/payloader/control"
 	"github.com/domsolutions/gopayloader/pkgs/payloader/worker"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/pterm/pterm"
@@ -18,20 +19,118 @@ func Display(results *payloader.GoPayloaderResults) {
 	t := table.NewWriter()
 	t.SetOutputMirror(os.Stdout)
 
+	if results.Warmup != nil {
+		displayWarmup(results.Warmup, t)
+	}
+
 	displayOverview(results, t)
 	displayRPS(results.RPS, t)
 	displayReqSize(results.ReqByteSize, t)
 	displayRespSize(results.RespByteSize, t)
 	displayLatency(results.Latency, t)
+	if results.FailedReqs > 0 {
+		displayFailedLatency(results.FailedLatency, t)
+	}
+
+	if len(results.PipelineLatency) > 0 {
+		displayPipelineLatency(results.PipelineLatency, t)
+	}
+
+	if results.RedirectChains > 0 {
+		displayRedirects(results, t)
+	}
+
+	if results.Retries.Policy {
+		displayRetries(results.Retries, t)
+	}
+
+	if results.Throttle.Honored {
+		displayThrottle(results.Throttle, t)
+	}
+
+	if results.Schedule.Requests > 0 {
+		displaySchedule(results.Schedule, t)
+	}
+
+	if results.VirtualUsers.VUs > 0 {
+		displayVirtualUsers(results.VirtualUsers, t)
+	}
+
+	if results.Backpressure.MaxInflight > 0 {
+		displayBackpressure(results.Backpressure, t)
+	}
+
 	displayResponseCodes(results.Responses, t)
 
+	if len(results.TLSVersions) > 0 {
+		displayTLS(results, t)
+	}
+
+	if results.QUIC.Handshakes > 0 {
+		displayQUIC(results.QUIC, t)
+	}
+
+	if len(results.MTLSIdentities) > 0 {
+		displayMTLSIdentities(results.MTLSIdentities, t)
+	}
+
+	if len(results.IPs) > 0 {
+		displayIPs(results.IPs, t)
+	}
+
 	if len(results.Errors) > 0 {
-		displayErrors(results.Errors, t)
+		displayErrors(results.Errors, results.ErrorExamples, results.ErrorLatency, t)
+	}
+
+	t.Render()
+}
+
+// DisplaySnapshot displays the live stats of a run returned by its control API
+func DisplaySnapshot(s *control.Snapshot) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+
+	state := "running"
+	if s.Paused {
+		state = "paused"
 	}
+	rate := "unlimited"
+	if s.Rate > 0 {
+		rate = fmt.Sprintf("%.2f RPS", s.Rate)
+	}
+	t.AppendRows([]table.Row{
+		{"State", state},
+		{"Elapsed", s.Elapsed.Round(time.Millisecond)},
+		{"Rate limit", rate},
+		{"Active connections", fmt.Sprintf("%d / %d", s.Active, s.Workers)},
+		{"Completed requests", s.Completed},
+		{"Failed requests", s.Failed},
+		{"In-flight requests", s.Inflight},
+		{"Average RPS", fmt.Sprintf("%.2f", s.RPS)},
+	})
+	t.AppendSeparator()
 
+	responses := make(map[worker.ResponseCode]int64, len(s.Responses))
+	for code, freq := range s.Responses {
+		responses[worker.ResponseCode(code)] = freq
+	}
+	displayResponseCodes(responses, t)
+	if len(s.Errors) > 0 {
+		displayErrors(s.Errors, s.Examples, nil, t)
+	}
 	t.Render()
 }
 
+func displayWarmup(results *payloader.Warmup, t table.Writer) {
+	t.AppendRows([]table.Row{
+		{"Warm-up time", results.Total},
+		{"Warm-up completed/failed requests", fmt.Sprintf("%d / %d", results.CompletedReqs, results.FailedReqs)},
+		{"Warm-up RPS", fmt.Sprintf("%.2f", results.RPS)},
+		{"Warm-up latency; avg/p99", fmt.Sprintf("%s / %s", results.AverageLatency, results.P99Latency)},
+	})
+	t.AppendSeparator()
+}
+
 func displayOverview(results *payloader.GoPayloaderResults, t table.Writer) {
 	t.AppendHeader(table.Row{"Metric", "Result"})
 	t.AppendRows([]table.Row{
@@ -41,31 +140,63 @@ func displayOverview(results *payloader.GoPayloaderResults, t table.Writer) {
 		{"Completed requests", results.CompletedReqs},
 		{"Failed requests", results.FailedReqs},
 	})
+	if results.Aborted {
+		t.AppendRow(table.Row{"Aborted", results.AbortReason})
+	}
+	if results.Interrupted {
+		t.AppendRow(table.Row{"Interrupted", "partial results"})
+	}
+	if results.Abandoned > 0 {
+		t.AppendRow(table.Row{"Abandoned in-flight requests", results.Abandoned})
+	}
 	t.AppendSeparator()
 }
 
 func displayReqSize(req payloader.ByteSize, t table.Writer) {
+	bytes, mb := sizeUnits(req)
 	rows := make([]table.Row, 0)
-	rows = append(rows, table.Row{"Req size (bytes)", req.Single})
-	rows = append(rows, table.Row{"Req size/second (MB)", fmt.Sprintf("%.3f", float64(req.PerSecond)/(1024*1024))})
-	rows = append(rows, table.Row{"Req total size (MB)", fmt.Sprintf("%.3f", float64(req.Total)/float64(1024*1024))})
+	rows = append(rows, table.Row{"Avg req size " + bytes, req.Average})
+	rows = append(rows, table.Row{"Req size/second " + mb, fmt.Sprintf("%.3f", float64(req.PerSecond)/(1024*1024))})
+	rows = append(rows, table.Row{"Req total size " + mb, fmt.Sprintf("%.3f", float64(req.Total)/float64(1024*1024))})
 	t.AppendRows(rows)
 	t.AppendSeparator()
 }
 
 func displayRespSize(resp payloader.ByteSize, t table.Writer) {
+	bytes, mb := sizeUnits(resp)
 	rows := make([]table.Row, 0)
-	rows = append(rows, table.Row{"Resp size (bytes)", resp.Single})
-	rows = append(rows, table.Row{"Resp size/second (MB)", fmt.Sprintf("%.3f", float64(resp.PerSecond)/(1024*1024))})
-	rows = append(rows, table.Row{"Resp total size (MB)", fmt.Sprintf("%.3f", float64(resp.Total)/float64(1024*1024))})
+	rows = append(rows, table.Row{"Avg resp size " + bytes, resp.Average})
+	if resp.Max > 0 {
+		rows = append(rows, table.Row{"P50 resp size " + bytes, resp.P50})
+		rows = append(rows, table.Row{"P90 resp size " + bytes, resp.P90})
+		rows = append(rows, table.Row{"P95 resp size " + bytes, resp.P95})
+		rows = append(rows, table.Row{"P99 resp size " + bytes, resp.P99})
+		rows = append(rows, table.Row{"Max resp size " + bytes, resp.Max})
+	}
+	rows = append(rows, table.Row{"Resp size/second " + mb, fmt.Sprintf("%.3f", float64(resp.PerSecond)/(1024*1024))})
+	rows = append(rows, table.Row{"Resp total size " + mb, fmt.Sprintf("%.3f", float64(resp.Total)/float64(1024*1024))})
 	t.AppendRows(rows)
 	t.AppendSeparator()
 }
 
-func displayErrors(errors map[string]uint64, t table.Writer) {
+// sizeUnits returns the units sizes are shown in, marking estimated sizes
+func sizeUnits(size payloader.ByteSize) (bytes, mb string) {
+	if size.Estimated {
+		return "(est. bytes)", "(est. MB)"
+	}
+	return "(bytes)", "(MB)"
+}
+
+func displayErrors(errors map[string]uint64, examples map[string]string, latencies map[string]payloader.ErrorLatency, t table.Writer) {
 	rows := make([]table.Row, 0)
-	for err, count := range errors {
-		rows = append(rows, table.Row{"Error; " + err, count})
+	for category, count := range errors {
+		rows = append(rows, table.Row{"Error; " + category, count})
+		if l, ok := latencies[category]; ok {
+			rows = append(rows, table.Row{"  avg/max time to failure", fmt.Sprintf("%s / %s", l.Average, l.Max)})
+		}
+		if example, ok := examples[category]; ok {
+			rows = append(rows, table.Row{"  e.g.", example})
+		}
 	}
 	t.AppendRows(rows)
 	t.AppendSeparator()
@@ -80,11 +211,163 @@ func displayResponseCodes(resps map[worker.ResponseCode]int64, t table.Writer) {
 	t.AppendSeparator()
 }
 
+func displayIPs(ips map[string]payloader.IPStats, t table.Writer) {
+	rows := make([]table.Row, 0)
+	for ip, stats := range ips {
+		rows = append(rows, table.Row{
+			"IP " + ip + "; conns/dial errors/reqs/errors",
+			fmt.Sprintf("%d / %d / %d / %d", stats.Conns, stats.DialErrors, stats.Requests, stats.Errors),
+		})
+	}
+	t.AppendRows(rows)
+	t.AppendSeparator()
+}
+
+func displayQUIC(results payloader.QUICStats, t table.Writer) {
+	t.AppendRows([]table.Row{
+		{"QUIC handshakes", results.Handshakes},
+		{"QUIC 0-RTT handshakes", results.Used0RTT},
+		{"Average QUIC handshake", results.AverageHandshake},
+		{"Max QUIC handshake", results.MaxHandshake},
+		{"QUIC path changes", results.PathChanges},
+	})
+	t.AppendSeparator()
+}
+
+func displayMTLSIdentities(identities map[string]*payloader.MTLSIdentityStats, t table.Writer) {
+	rows := make([]table.Row, 0)
+	for name, stats := range identities {
+		rows = append(rows, table.Row{
+			"mTLS identity " + name + "; conns/completed/failed",
+			fmt.Sprintf("%d / %d / %d", stats.Conns, stats.CompletedReqs, stats.FailedReqs),
+		})
+		for code, freq := range stats.Responses {
+			rows = append(rows, table.Row{"mTLS identity " + name + "; response code " + strconv.Itoa(int(code)), freq})
+		}
+		for err, count := range stats.Errors {
+			rows = append(rows, table.Row{"mTLS identity " + name + "; error " + err, count})
+		}
+	}
+	t.AppendRows(rows)
+	t.AppendSeparator()
+}
+
+func displayTLS(results *payloader.GoPayloaderResults, t table.Writer) {
+	rows := make([]table.Row, 0)
+	for version, freq := range results.TLSVersions {
+		rows = append(rows, table.Row{"TLS version; " + version, freq})
+	}
+	for cipher, freq := range results.CipherSuites {
+		rows = append(rows, table.Row{"Cipher suite; " + cipher, freq})
+	}
+	for proto, freq := range results.ALPNProtocols {
+		rows = append(rows, table.Row{"ALPN protocol; " + proto, freq})
+	}
+	rows = append(rows, table.Row{"Resumed sessions", results.ResumedSessions})
+	t.AppendRows(rows)
+	t.AppendSeparator()
+}
+
 func displayLatency(results payloader.Latency, t table.Writer) {
 	t.AppendRows([]table.Row{
 		{"Average latency", results.Average},
 		{"Max latency", results.Max},
 		{"Min latency", results.Min},
+		{"P50 latency", results.P50},
+		{"P90 latency", results.P90},
+		{"P95 latency", results.P95},
+		{"P99 latency", results.P99},
+	})
+	t.AppendSeparator()
+}
+
+func displayFailedLatency(results payloader.Latency, t table.Writer) {
+	t.AppendRows([]table.Row{
+		{"Average time to failure", results.Average},
+		{"Max time to failure", results.Max},
+		{"Min time to failure", results.Min},
+		{"P50 time to failure", results.P50},
+		{"P90 time to failure", results.P90},
+		{"P95 time to failure", results.P95},
+		{"P99 time to failure", results.P99},
+	})
+	t.AppendSeparator()
+}
+
+func displayPipelineLatency(depths []payloader.PipelineLatency, t table.Writer) {
+	rows := make([]table.Row, 0)
+	for _, d := range depths {
+		rows = append(rows, table.Row{
+			"Pipeline depth " + strconv.Itoa(d.Depth) + "; avg/max latency",
+			fmt.Sprintf("%s / %s (%d requests)", d.Average, d.Max, d.Requests),
+		})
+	}
+	t.AppendRows(rows)
+	t.AppendSeparator()
+}
+
+func displayRedirects(results *payloader.GoPayloaderResults, t table.Writer) {
+	rows := []table.Row{{"Redirect chains", results.RedirectChains}}
+	for _, h := range results.RedirectLatency {
+		rows = append(rows, table.Row{
+			"Redirect hop " + strconv.Itoa(h.Hop) + "; avg/max latency",
+			fmt.Sprintf("%s / %s (%d requests)", h.Average, h.Max, h.Requests),
+		})
+	}
+	t.AppendRows(rows)
+	t.AppendSeparator()
+}
+
+func displayRetries(results payloader.Retries, t table.Writer) {
+	rows := []table.Row{
+		{"Succeeded on first attempt", results.FirstAttempt},
+		{"Succeeded after retry", results.AfterRetry},
+		{"Retries exhausted", results.Exhausted},
+		{"Total retries", results.Retries},
+		{"Retried requests; avg/max end-to-end latency", fmt.Sprintf("%s / %s", results.AverageLatency, results.MaxLatency)},
+	}
+	for _, a := range results.Attempts {
+		rows = append(rows, table.Row{
+			"Attempt " + strconv.Itoa(a.Attempt) + "; avg/max latency",
+			fmt.Sprintf("%s / %s (%d requests)", a.Average, a.Max, a.Requests),
+		})
+	}
+	t.AppendRows(rows)
+	t.AppendSeparator()
+}
+
+func displayThrottle(results payloader.Throttle, t table.Writer) {
+	t.AppendRows([]table.Row{
+		{"Responses with Retry-After honored", results.Responses},
+		{"Time throttled (all workers)", fmt.Sprintf("%s (%.2f%%)", results.Time.Round(time.Millisecond), results.Share*100)},
+		{"Effective RPS (excluding 429 and 503 responses)", fmt.Sprintf("%.2f", results.EffectiveRPS)},
+	})
+	t.AppendSeparator()
+}
+
+func displaySchedule(results payloader.Schedule, t table.Writer) {
+	t.AppendRows([]table.Row{
+		{"Arrival process", results.Arrival},
+		{"Scheduled requests", results.Requests},
+		{"Schedule lag; avg/max", fmt.Sprintf("%s / %s", results.AverageLag, results.MaxLag)},
+	})
+	t.AppendSeparator()
+}
+
+func displayVirtualUsers(results payloader.VirtualUsers, t table.Writer) {
+	t.AppendRows([]table.Row{
+		{"Virtual users", results.VUs},
+		{"Iterations per VU; min/avg/max", fmt.Sprintf("%d / %.2f / %d", results.MinIterations, results.AvgIterations, results.MaxIterations)},
+		{"Effective arrival rate (req/s)", fmt.Sprintf("%.2f", results.ArrivalRate)},
+	})
+	t.AppendSeparator()
+}
+
+func displayBackpressure(results payloader.Backpressure, t table.Writer) {
+	t.AppendRows([]table.Row{
+		{"Max in-flight per connection", results.MaxInflight},
+		{"Blocked by in-flight cap", results.Blocked},
+		{"Total time blocked", results.Wait},
 	})
 	t.AppendSeparator()
 }
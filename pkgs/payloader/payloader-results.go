@@ -1,8 +1,12 @@
 package payloader
 
 import (
+	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
 	"github.com/domsolutions/gopayloader/pkgs/payloader/worker"
 	"github.com/pterm/pterm"
+	"net/http"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -11,47 +15,319 @@ func (p *PayLoader) ComputeResults(workers []worker.Worker, results *GoPayloader
 	results.End = p.stopTime
 	results.Total = p.stopTime.Sub(p.startTime)
 	results.Errors = make(map[string]uint64)
+	results.ErrorExamples = make(map[string]string)
+	results.ErrorLatency = make(map[string]ErrorLatency)
+	errLatencies := make(map[string]worker.ErrorLatency)
 	results.Responses = make(map[worker.ResponseCode]int64)
+	results.TLSVersions = make(map[string]int64)
+	results.CipherSuites = make(map[string]int64)
+	results.ALPNProtocols = make(map[string]int64)
+	results.Backpressure.MaxInflight = p.config.MaxInflight
+	results.Retries.Policy = p.config.RetryPolicy != nil
+	results.Throttle.Honored = p.config.HonorRetryAfter
+	// nethttp clients only expose the request and response, not the bytes written over HTTP/2 or HTTP/3
+	estimated := strings.HasPrefix(p.config.Client, worker.HttpClientNetHTTP)
+	results.ReqByteSize.Estimated, results.RespByteSize.Estimated = estimated, estimated
+	results.Schedule.Arrival = http_clients.ArrivalUniform
+	if p.config.ArrivalProcess != nil {
+		results.Schedule.Arrival = p.config.ArrivalProcess.Process
+	}
 
 	pterm.Debug.Println("Calculating response code statistics")
+	depths := make(map[int]worker.DepthLatency)
+	hops := make(map[int]worker.HopLatency)
+	attempts := make(map[int]worker.AttemptLatency)
+	results.IPs = make(map[string]IPStats)
+	if p.resolver != nil {
+		for ip, stats := range p.resolver.Stats() {
+			results.IPs[ip] = IPStats{Conns: stats.Conns, DialErrors: stats.DialErrors}
+		}
+	}
+
+	if len(p.config.MTLSIdentities) > 1 {
+		results.MTLSIdentities = make(map[string]*MTLSIdentityStats)
+	}
 
+	var quicHandshakeTotal, scheduleLag, retriedLatency time.Duration
+	var sentReqs int64
 	for _, w := range workers {
 		stats := w.Stats()
 		results.CompletedReqs += stats.CompletedReqs
 		results.FailedReqs += stats.FailedReqs
+		results.ResumedSessions += stats.ResumedSessions
+		results.RedirectChains += stats.RedirectChains
+		results.Backpressure.Blocked += stats.InflightBlocked
+		results.Backpressure.Wait += stats.InflightWait
+		results.Schedule.Requests += stats.ScheduledReqs
+		results.Abandoned += stats.Inflight + stats.Abandoned
+		results.Retries.FirstAttempt += stats.FirstAttemptReqs
+		results.Retries.AfterRetry += stats.RetriedReqs
+		results.Retries.Exhausted += stats.RetriesExhausted
+		results.Retries.Retries += stats.Retries
+		retriedLatency += stats.RetriedLatency
+		results.Throttle.Responses += stats.Throttled
+		results.Throttle.Time += stats.ThrottledTime
+		results.ReqByteSize.Total += stats.SentBytes
+		sentReqs += stats.SentReqs
+		results.RespByteSize.Total += stats.ReceivedBytes
+		if stats.MaxRetriedLatency > results.Retries.MaxLatency {
+			results.Retries.MaxLatency = stats.MaxRetriedLatency
+		}
+		scheduleLag += stats.ScheduleLag
+		if stats.MaxScheduleLag > results.Schedule.MaxLag {
+			results.Schedule.MaxLag = stats.MaxScheduleLag
+		}
+		if p.config.VUs > 0 || p.config.ThinkTimeDist != nil {
+			results.VirtualUsers.Iterations = append(results.VirtualUsers.Iterations, stats.CompletedReqs+stats.FailedReqs)
+		}
+		results.QUIC.Handshakes += stats.QUIC.Count
+		results.QUIC.Used0RTT += stats.QUIC.Used0RTT
+		results.QUIC.PathChanges += stats.QUIC.PathChanges
+		quicHandshakeTotal += stats.QUIC.Total
+		if stats.QUIC.Max > results.QUIC.MaxHandshake {
+			results.QUIC.MaxHandshake = stats.QUIC.Max
+		}
+
+		var identity *MTLSIdentityStats
+		if results.MTLSIdentities != nil {
+			identity = results.MTLSIdentities[stats.MTLSIdentity]
+			if identity == nil {
+				identity = &MTLSIdentityStats{
+					Responses: make(map[worker.ResponseCode]int64),
+					Errors:    make(map[string]uint64),
+				}
+				results.MTLSIdentities[stats.MTLSIdentity] = identity
+			}
+			identity.Conns++
+			identity.CompletedReqs += stats.CompletedReqs
+			identity.FailedReqs += stats.FailedReqs
+		}
 
 		stats.Errors.Range(func(key, value any) bool {
 			results.Errors[key.(string)] += value.(uint64)
+			if identity != nil {
+				identity.Errors[key.(string)] += value.(uint64)
+			}
+			return true
+		})
+
+		stats.ErrorExamples.Range(func(key, value any) bool {
+			if _, ok := results.ErrorExamples[key.(string)]; !ok {
+				results.ErrorExamples[key.(string)] = value.(string)
+			}
+			return true
+		})
+
+		stats.ErrorLatencies.Range(func(key, value any) bool {
+			l := errLatencies[key.(string)]
+			v := value.(worker.ErrorLatency)
+			l.Requests += v.Requests
+			l.Total += v.Total
+			if v.Max > l.Max {
+				l.Max = v.Max
+			}
+			errLatencies[key.(string)] = l
 			return true
 		})
 
 		stats.Responses.Range(func(key, value any) bool {
 			results.Responses[key.(worker.ResponseCode)] += value.(int64)
+			if identity != nil {
+				identity.Responses[key.(worker.ResponseCode)] += value.(int64)
+			}
+			return true
+		})
+
+		stats.TLSVersions.Range(func(key, value any) bool {
+			results.TLSVersions[key.(string)] += value.(int64)
+			return true
+		})
+
+		stats.CipherSuites.Range(func(key, value any) bool {
+			results.CipherSuites[key.(string)] += value.(int64)
+			return true
+		})
+
+		stats.ALPNs.Range(func(key, value any) bool {
+			results.ALPNProtocols[key.(string)] += value.(int64)
+			return true
+		})
+
+		stats.PipelineDepths.Range(func(key, value any) bool {
+			d := depths[key.(int)]
+			v := value.(worker.DepthLatency)
+			d.Depth = v.Depth
+			d.Requests += v.Requests
+			d.Total += v.Total
+			if v.Max > d.Max {
+				d.Max = v.Max
+			}
+			depths[key.(int)] = d
+			return true
+		})
+
+		stats.RedirectHops.Range(func(key, value any) bool {
+			h := hops[key.(int)]
+			v := value.(worker.HopLatency)
+			h.Hop = v.Hop
+			h.Requests += v.Requests
+			h.Total += v.Total
+			if v.Max > h.Max {
+				h.Max = v.Max
+			}
+			hops[key.(int)] = h
+			return true
+		})
+
+		stats.RetryAttempts.Range(func(key, value any) bool {
+			a := attempts[key.(int)]
+			v := value.(worker.AttemptLatency)
+			a.Attempt = v.Attempt
+			a.Requests += v.Requests
+			a.Total += v.Total
+			if v.Max > a.Max {
+				a.Max = v.Max
+			}
+			attempts[key.(int)] = a
+			return true
+		})
+
+		stats.IPs.Range(func(key, value any) bool {
+			ip := results.IPs[key.(string)]
+			ip.Requests += value.(worker.IPRequests).Requests
+			ip.Errors += value.(worker.IPRequests).Errors
+			results.IPs[key.(string)] = ip
 			return true
 		})
 
 	}
 
+	if results.Schedule.Requests > 0 {
+		results.Schedule.AverageLag = scheduleLag / time.Duration(results.Schedule.Requests)
+	}
+
+	if len(results.VirtualUsers.Iterations) > 0 {
+		computeVirtualUsers(&results.VirtualUsers, results.Total)
+	}
+
+	for _, h := range hops {
+		results.RedirectLatency = append(results.RedirectLatency, RedirectLatency{
+			Hop:      h.Hop,
+			Requests: h.Requests,
+			Average:  h.Total / time.Duration(h.Requests),
+			Max:      h.Max,
+		})
+	}
+	sort.Slice(results.RedirectLatency, func(i, j int) bool {
+		return results.RedirectLatency[i].Hop < results.RedirectLatency[j].Hop
+	})
+
+	if results.Throttle.Honored && results.Total > 0 {
+		results.Throttle.Share = float64(results.Throttle.Time) / float64(results.Total*time.Duration(len(workers)))
+		shed := results.Responses[worker.ResponseCode(http.StatusTooManyRequests)] + results.Responses[worker.ResponseCode(http.StatusServiceUnavailable)]
+		results.Throttle.EffectiveRPS = float64(results.CompletedReqs-shed) / results.Total.Seconds()
+	}
+
+	if retried := results.Retries.AfterRetry + results.Retries.Exhausted; retried > 0 {
+		results.Retries.AverageLatency = retriedLatency / time.Duration(retried)
+	}
+	for _, a := range attempts {
+		results.Retries.Attempts = append(results.Retries.Attempts, AttemptLatency{
+			Attempt:  a.Attempt,
+			Requests: a.Requests,
+			Average:  a.Total / time.Duration(a.Requests),
+			Max:      a.Max,
+		})
+	}
+	sort.Slice(results.Retries.Attempts, func(i, j int) bool {
+		return results.Retries.Attempts[i].Attempt < results.Retries.Attempts[j].Attempt
+	})
+
+	if results.QUIC.Handshakes > 0 {
+		results.QUIC.AverageHandshake = quicHandshakeTotal / time.Duration(results.QUIC.Handshakes)
+	}
+
+	for _, d := range depths {
+		results.PipelineLatency = append(results.PipelineLatency, PipelineLatency{
+			Depth:    d.Depth,
+			Requests: d.Requests,
+			Average:  d.Total / time.Duration(d.Requests),
+			Max:      d.Max,
+		})
+	}
+	sort.Slice(results.PipelineLatency, func(i, j int) bool {
+		return results.PipelineLatency[i].Depth < results.PipelineLatency[j].Depth
+	})
+
+	for category, l := range errLatencies {
+		results.ErrorLatency[category] = ErrorLatency{Average: l.Total / time.Duration(l.Requests), Max: l.Max}
+	}
+	p.mergeShards()
+	if count := p.failedLatencies.Count(); count > 0 {
+		results.FailedLatency.Min = time.Duration(p.failedLatencies.Min())
+		results.FailedLatency.Max = time.Duration(p.failedLatencies.Max())
+		results.FailedLatency.Total = time.Duration(p.failedLatencies.Sum())
+		results.FailedLatency.Average = results.FailedLatency.Total / time.Duration(count)
+		results.FailedLatency.P50 = time.Duration(p.failedLatencies.Percentile(50))
+		results.FailedLatency.P90 = time.Duration(p.failedLatencies.Percentile(90))
+		results.FailedLatency.P95 = time.Duration(p.failedLatencies.Percentile(95))
+		results.FailedLatency.P99 = time.Duration(p.failedLatencies.Percentile(99))
+	}
+
+	if count := p.latencies.Count(); count > 0 {
+		results.Latency.Min = time.Duration(p.latencies.Min())
+		results.Latency.Max = time.Duration(p.latencies.Max())
+		results.Latency.Total = time.Duration(p.latencies.Sum())
+		results.Latency.Average = results.Latency.Total / time.Duration(count)
+		results.Latency.P50 = time.Duration(p.latencies.Percentile(50))
+		results.Latency.P90 = time.Duration(p.latencies.Percentile(90))
+		results.Latency.P95 = time.Duration(p.latencies.Percentile(95))
+		results.Latency.P99 = time.Duration(p.latencies.Percentile(99))
+	}
 	if results.CompletedReqs > 0 {
-		results.Latency.Average = results.Latency.Total / time.Duration(results.CompletedReqs)
 		results.RPS.Average = float64(results.CompletedReqs) / (float64(results.Total) / float64(time.Second))
+	}
 
-		results.ReqByteSize.Single = workers[0].ReqSize()
-		results.ReqByteSize.Total = workers[0].ReqSize() * results.CompletedReqs
-		if numSeconds := int64(results.Total / time.Second); numSeconds == 0 {
-			results.ReqByteSize.PerSecond = workers[0].ReqSize() * results.CompletedReqs
-		} else {
-			results.ReqByteSize.PerSecond = (workers[0].ReqSize() * results.CompletedReqs) / int64(results.Total/time.Second)
-		}
+	if sentReqs > 0 {
+		// failed requests are sent too so they're included in the average request size
+		results.ReqByteSize.Average = results.ReqByteSize.Total / sentReqs
+	}
+	if exchanges := int64(p.respSizes.Count()); exchanges > 0 {
+		results.RespByteSize.Average = results.RespByteSize.Total / exchanges
+		results.RespByteSize.P50 = p.respSizes.Percentile(50)
+		results.RespByteSize.P90 = p.respSizes.Percentile(90)
+		results.RespByteSize.P95 = p.respSizes.Percentile(95)
+		results.RespByteSize.P99 = p.respSizes.Percentile(99)
+		results.RespByteSize.Max = p.respSizes.Max()
+	}
+	if numSeconds := int64(results.Total / time.Second); numSeconds == 0 {
+		results.ReqByteSize.PerSecond = results.ReqByteSize.Total
+		results.RespByteSize.PerSecond = results.RespByteSize.Total
+	} else {
+		results.ReqByteSize.PerSecond = results.ReqByteSize.Total / numSeconds
+		results.RespByteSize.PerSecond = results.RespByteSize.Total / numSeconds
+	}
+
+	return results, nil
+}
 
-		results.RespByteSize.Single = workers[0].RespSize()
-		results.RespByteSize.Total = workers[0].RespSize() * results.CompletedReqs
-		if numSeconds := int64(results.Total / time.Second); numSeconds == 0 {
-			results.RespByteSize.PerSecond = workers[0].RespSize() * results.CompletedReqs
-		} else {
-			results.RespByteSize.PerSecond = (workers[0].RespSize() * results.CompletedReqs) / int64(results.Total/time.Second)
+func computeVirtualUsers(vus *VirtualUsers, total time.Duration) {
+	vus.VUs = len(vus.Iterations)
+	vus.MinIterations = vus.Iterations[0]
+
+	var sum int64
+	for _, i := range vus.Iterations {
+		sum += i
+		if i < vus.MinIterations {
+			vus.MinIterations = i
+		}
+		if i > vus.MaxIterations {
+			vus.MaxIterations = i
 		}
 	}
 
-	return results, nil
+	vus.AvgIterations = float64(sum) / float64(vus.VUs)
+	if total > 0 {
+		vus.ArrivalRate = float64(sum) / total.Seconds()
+	}
 }
@@ -3,15 +3,21 @@ package payloader
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/domsolutions/gopayloader/config"
 	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
+	"github.com/domsolutions/gopayloader/pkgs/http-clients/dialer"
 	jwt_generator "github.com/domsolutions/gopayloader/pkgs/jwt-generator"
+	"github.com/domsolutions/gopayloader/pkgs/payloader/control"
+	"github.com/domsolutions/gopayloader/pkgs/payloader/histogram"
 	"github.com/domsolutions/gopayloader/pkgs/payloader/worker"
 	"github.com/pterm/pterm"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
+	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"sync"
 	"time"
@@ -19,6 +25,10 @@ import (
 
 const (
 	cacheDir = "gopayloader"
+	// statsShardsPerCPU bounds the stats shards by the CPUs recording into them
+	statsShardsPerCPU = 4
+	// abandonWait is how long workers are given to stop once the connections of abandoned requests are closed
+	abandonWait = time.Second
 )
 
 var (
@@ -37,6 +47,16 @@ type PayLoader struct {
 	config    *config.Config
 	startTime time.Time
 	stopTime  time.Time
+	// shards are recorded into by the workers, latencies, failedLatencies and respSizes are merged from them once the
+	// run completes
+	shards    []statsShard
+	latencies *histogram.Histogram
+	// failedLatencies are how long failed requests took to fail
+	failedLatencies *histogram.Histogram
+	respSizes       *histogram.Histogram
+	aborter         *aborter
+	abort           context.CancelCauseFunc
+	resolver        *dialer.Resolver
 }
 
 type GoPayloaderResults struct {
@@ -47,16 +67,163 @@ type GoPayloaderResults struct {
 	FailedReqs    int64
 	RPS           RPS
 	Latency       Latency
+	// FailedLatency is how long failed requests took to fail, ErrorLatency is broken down by error category
+	FailedLatency Latency
+	ErrorLatency  map[string]ErrorLatency
+	Responses     map[worker.ResponseCode]int64
+	// Errors are counted by category, ErrorExamples holds an error of each category as it was returned
+	Errors          map[string]uint64
+	ErrorExamples   map[string]string
+	ReqByteSize     ByteSize
+	RespByteSize    ByteSize
+	TLSVersions     map[string]int64
+	CipherSuites    map[string]int64
+	ALPNProtocols   map[string]int64
+	ResumedSessions int64
+	PipelineLatency []PipelineLatency
+	IPs             map[string]IPStats
+	MTLSIdentities  map[string]*MTLSIdentityStats
+	QUIC            QUICStats
+	RedirectChains  int64
+	RedirectLatency []RedirectLatency
+	Backpressure    Backpressure
+	Retries         Retries
+	Throttle        Throttle
+	VirtualUsers    VirtualUsers
+	Schedule        Schedule
+	Warmup          *Warmup
+	Aborted         bool
+	AbortReason     string
+	// Interrupted is set when the user stopped the run, Abandoned are the requests still in flight once the grace
+	// period passed which are missing from the results
+	Interrupted bool
+	Abandoned   int64
+}
+
+// Warmup summarises the warm-up phase, its requests are excluded from all other results
+type Warmup struct {
+	Total          time.Duration
+	CompletedReqs  int64
+	FailedReqs     int64
+	RPS            float64
+	AverageLatency time.Duration
+	P99Latency     time.Duration
+}
+
+// Schedule is how closely requests sent over a duration kept to the arrival process
+type Schedule struct {
+	Arrival  string
+	Requests int64
+	// AverageLag and MaxLag are how late requests were sent after their scheduled time
+	AverageLag time.Duration
+	MaxLag     time.Duration
+}
+
+// VirtualUsers are the iterations completed by virtual users, an iteration is a request and the think time after it
+type VirtualUsers struct {
+	VUs int
+	// Iterations are the requests sent by each virtual user
+	Iterations    []int64
+	MinIterations int64
+	MaxIterations int64
+	AvgIterations float64
+	// ArrivalRate is the requests per second sent by all virtual users, failed requests included
+	ArrivalRate float64
+}
+
+// Backpressure is how often workers were held back by the in-flight cap before sending the next request
+type Backpressure struct {
+	MaxInflight uint
+	Blocked     int64
+	Wait        time.Duration
+}
+
+// Retries are the outcomes of requests sent under the retry policy, a request succeeds if it gets a response that
+// isn't retried
+type Retries struct {
+	Policy       bool
+	FirstAttempt int64
+	AfterRetry   int64
+	Exhausted    int64
+	Retries      int64
+	// AverageLatency and MaxLatency are the end-to-end latency of retried requests, backoff included
+	AverageLatency time.Duration
+	MaxLatency     time.Duration
+	Attempts       []AttemptLatency
+}
+
+// Throttle is how the run was held back by honoring Retry-After, Time is summed over workers
+type Throttle struct {
+	Honored   bool
+	Responses int64
+	Time      time.Duration
+	// Share is the fraction of the run's worker time spent throttled
+	Share float64
+	// EffectiveRPS is the rate of requests completing without a 429 or 503 response
+	EffectiveRPS float64
+}
+
+// AttemptLatency is the latency of the Attempt'th attempt of requests, attempt 1 is the first
+type AttemptLatency struct {
+	Attempt  int
+	Requests int64
+	Average  time.Duration
+	Max      time.Duration
+}
+
+type RedirectLatency struct {
+	Hop      int
+	Requests int64
+	Average  time.Duration
+	Max      time.Duration
+}
+
+type QUICStats struct {
+	Handshakes       int64
+	Used0RTT         int64
+	AverageHandshake time.Duration
+	MaxHandshake     time.Duration
+	// PathChanges are the paths the server validated after the client's address changed
+	PathChanges int64
+}
+
+// MTLSIdentityStats are the results of connections using a single mTLS client identity
+type MTLSIdentityStats struct {
+	Conns         int64
+	CompletedReqs int64
+	FailedReqs    int64
 	Responses     map[worker.ResponseCode]int64
 	Errors        map[string]uint64
-	ReqByteSize   ByteSize
-	RespByteSize  ByteSize
 }
 
+type IPStats struct {
+	Conns      int64
+	DialErrors int64
+	Requests   int64
+	Errors     int64
+}
+
+type PipelineLatency struct {
+	Depth    int
+	Requests int64
+	Average  time.Duration
+	Max      time.Duration
+}
+
+// ByteSize are the bytes of requests sent or responses received including retries and redirects, Average is per
+// request or response. Percentiles and Max are only recorded for responses.
 type ByteSize struct {
-	Single    int64
+	// Estimated is set when the client doesn't expose the bytes it sends and receives, sizes are then those of the
+	// exchange written as HTTP/1.1
+	Estimated bool
+	Average   int64
 	Total     int64
 	PerSecond int64
+	P50       int64
+	P90       int64
+	P95       int64
+	P99       int64
+	Max       int64
 }
 
 type RPS struct {
@@ -65,15 +232,41 @@ type RPS struct {
 	Min     int64
 }
 
+// ErrorLatency is how long requests failing with an error category took to fail
+type ErrorLatency struct {
+	Average time.Duration
+	Max     time.Duration
+}
+
 type Latency struct {
 	Average time.Duration
 	Max     time.Duration
 	Min     time.Duration
 	Total   time.Duration
+	P50     time.Duration
+	P90     time.Duration
+	P95     time.Duration
+	P99     time.Duration
+}
+
+// statsShard holds the histograms a shard of workers record into without locking. Workers have a shard each until
+// there are statsShardsPerCPU per CPU, beyond that they share shards to bound memory.
+type statsShard struct {
+	latencies *histogram.Atomic
+	failed    *histogram.Atomic
+	respSizes *histogram.Atomic
+}
+
+func newStatsShards(workers int) []statsShard {
+	shards := make([]statsShard, min(workers, statsShardsPerCPU*runtime.GOMAXPROCS(0)))
+	for i := range shards {
+		shards[i] = statsShard{latencies: histogram.NewAtomic(), failed: histogram.NewAtomic(), respSizes: histogram.NewAtomic()}
+	}
+	return shards
 }
 
 func NewPayLoader(config *config.Config) *PayLoader {
-	return &PayLoader{config: config}
+	return &PayLoader{config: config, latencies: histogram.New(), failedLatencies: histogram.New(), respSizes: histogram.New()}
 }
 
 func (p *PayLoader) startTimer() {
@@ -88,11 +281,32 @@ func (p *PayLoader) startWorkers(wg *sync.WaitGroup) {
 	wg.Done()
 }
 
+// setConnConfig sets the settings assigned per connection, conn is the connection's index
+func (p *PayLoader) setConnConfig(c *http_clients.Config, conn int) {
+	if len(p.config.MTLSIdentities) > 0 {
+		id := p.config.MTLSIdentities[conn%len(p.config.MTLSIdentities)]
+		c.MTLSCert = id.Cert
+		c.MTLSKey = id.Key
+		c.MTLSName = id.Name
+	}
+
+	if p.resolver != nil {
+		var sourceIP net.IP
+		if len(p.config.SourceIPs) > 0 {
+			sourceIP = net.ParseIP(p.config.SourceIPs[conn%len(p.config.SourceIPs)])
+		}
+		c.Dialer = dialer.New(p.resolver, p.config.ReadTimeout, sourceIP)
+	}
+}
+
 func (p *PayLoader) handleReqs() (*GoPayloaderResults, error) {
 	var jwtErr <-chan error
 	var jwtStream <-chan string
 
 	if p.config.SendJWT && p.config.ReqTarget != 0 {
+		// warm-up requests are sent by the same workers so take their jwts from the same stream
+		jwts := p.config.ReqTarget + p.config.WarmupRequests
+
 		if JwtCacheDir == "" {
 			pterm.Error.Println("Can't save jwts if no cache directory")
 			return nil, errors.New("cache directory couldn't be determined")
@@ -104,7 +318,7 @@ func (p *PayLoader) handleReqs() (*GoPayloaderResults, error) {
 		pterm.Info.Printf("Sending jwts with requests\n")
 		if p.config.JwtsFilename != "" {
 			pterm.Info.Printf("Using JWTs from %s \n", p.config.JwtsFilename)
-			jwtStream, jwtErr = jwt_generator.GetUserSuppliedJWTs(p.config.JwtsFilename, p.config.ReqTarget)
+			jwtStream, jwtErr = jwt_generator.GetUserSuppliedJWTs(p.config.JwtsFilename, jwts)
 		} else {
 			pterm.Info.Printf("Checking for JWTs in cache\n")
 			jwt := jwt_generator.NewJWTGenerator(&jwt_generator.Config{
@@ -117,32 +331,72 @@ func (p *PayLoader) handleReqs() (*GoPayloaderResults, error) {
 				JwtAud:              p.config.JwtAud,
 			})
 
-			if err := jwt.Generate(p.config.ReqTarget, JwtCacheDir, false); err != nil {
+			if err := jwt.Generate(jwts, JwtCacheDir, false); err != nil {
 				return nil, err
 			}
-			jwtStream, jwtErr = jwt.JWTS(p.config.ReqTarget)
+			jwtStream, jwtErr = jwt.JWTS(jwts)
 		}
 	}
 
-	reqsPerWorker := p.config.ReqTarget / int64(p.config.Conns)
-	remainderReqs := p.config.ReqTarget % int64(p.config.Conns)
+	numWorkers := p.config.Workers()
+	reqsPerWorker := p.config.ReqTarget / int64(numWorkers)
+	remainderReqs := p.config.ReqTarget % int64(numWorkers)
 
 	workersComplete := &sync.WaitGroup{}
-	workersComplete.Add(int(p.config.Conns))
+	workersComplete.Add(int(numWorkers))
 
 	startTrigger := &sync.WaitGroup{}
 	startTrigger.Add(1)
 
-	var reqEvery time.Duration
+	// workers warm up their connections then wait to be measured while their stats are reset
+	var warmup *http_clients.Warmup
+	if p.config.Warming() {
+		warmup = &http_clients.Warmup{Until: p.config.Warmup, Done: &sync.WaitGroup{}, Measure: &sync.WaitGroup{}}
+		warmup.Done.Add(int(numWorkers))
+		warmup.Measure.Add(1)
+	}
+	warmupPerWorker := p.config.WarmupRequests / int64(numWorkers)
+	remainderWarmup := p.config.WarmupRequests % int64(numWorkers)
+
 	printer := message.NewPrinter(language.English)
 
+	if p.config.Handshake {
+		pterm.Info.Printf("Benchmarking TLS handshakes only, no HTTP requests will be sent\n")
+	}
+
+	if len(p.config.MTLSIdentities) > 1 {
+		pterm.Info.Printf("Spreading %d mTLS identities across connections\n", len(p.config.MTLSIdentities))
+	}
+
+	if p.config.Pipeline > 1 {
+		pterm.Info.Printf("Pipelining up to %d requests per connection\n", p.config.Pipeline)
+	}
+
+	if p.config.MaxInflight > 0 {
+		pterm.Info.Printf("Sending up to %d requests in flight per connection\n", p.config.MaxInflight)
+	}
+
+	if p.config.Concurrency > 0 {
+		pterm.Info.Printf("Sharing %d connection/s between %d concurrent request/s\n", p.config.Conns, p.config.Concurrency)
+	}
+
+	if p.config.VUs > 0 {
+		pterm.Info.Printf("Sharing %d connection/s between %d virtual user/s\n", p.config.Conns, p.config.VUs)
+	}
+
+	if p.config.ThinkTimeDist != nil {
+		pterm.Info.Printf("Pausing for %s think time between each virtual user's requests\n", p.config.ThinkTime)
+	}
+
 	if p.config.Duration != 0 && p.config.ReqTarget != 0 {
-		reqEvery = time.Duration(float64(p.config.Duration) / (float64(p.config.ReqTarget) / float64(p.config.Conns)))
-		msg := printer.Sprintf("Running requests every %s for every %d connection/s for total %d request/s against %s\n",
-			reqEvery.String(), int(p.config.Conns), p.config.ReqTarget, p.config.ReqURI)
+		arrival := http_clients.ArrivalUniform
+		if p.config.Arrival != "" {
+			arrival = p.config.Arrival
+		}
+		msg := printer.Sprintf("Running %d request/s over %s with %s arrivals for %d worker/s against %s\n",
+			p.config.ReqTarget, p.config.Duration, arrival, int(numWorkers), p.config.ReqURI)
 		pterm.Info.Printf(msg)
 	} else if p.config.Duration != 0 && p.config.ReqTarget == 0 {
-		reqEvery = time.Duration(float64(p.config.Duration) / (float64(p.config.ReqTarget) / float64(p.config.Conns)))
 		msg := printer.Sprintf("Running requests for %s for %d connection/s against %s\n",
 			p.config.Duration, int(p.config.Conns), p.config.ReqURI)
 		pterm.Info.Printf(msg)
@@ -151,34 +405,92 @@ func (p *PayLoader) handleReqs() (*GoPayloaderResults, error) {
 		pterm.Info.Printf(msg)
 	}
 
-	workers := make([]worker.Worker, p.config.Conns)
-	reqStats := make(chan time.Duration, 1000000)
-
-	var conn uint
-	for conn = 0; conn < p.config.Conns; conn++ {
-
-		c := &http_clients.Config{
-			ReqURI:           p.config.ReqURI,
-			DisableKeepAlive: p.config.DisableKeepAlive,
-			SkipVerify:       p.config.SkipVerify,
-			MTLSKey:          p.config.MTLSKey,
-			MTLSCert:         p.config.MTLSCert,
-			ReqTarget:        reqsPerWorker,
-			Ctx:              p.config.Ctx,
-			StartTrigger:     startTrigger,
-			Until:            p.config.Duration,
-			ReqEvery:         reqEvery,
-			ReadTimeout:      p.config.ReadTimeout,
-			WriteTimeout:     p.config.WriteTimeout,
-			Method:           p.config.Method,
-			Verbose:          p.config.Verbose,
-			Headers:          p.config.Headers,
-			Body:             p.config.Body,
-			BodyFile:         p.config.BodyFile,
-			ReqStats:         reqStats,
-			Client:           p.config.Client,
-			Parallel:         p.config.Parallel,
+	if p.config.CustomDialer() {
+		resolver, err := dialer.NewResolver(p.config.Resolve, p.config.Resolver)
+		if err != nil {
+			return nil, err
 		}
+		p.resolver = resolver
+	}
+
+	// the body file is opened once and shared by every worker, it's closed once they complete
+	var bodyFile *http_clients.BodyFile
+	if p.config.BodyFile != "" {
+		var err error
+		if bodyFile, err = http_clients.OpenBodyFile(p.config.BodyFile); err != nil {
+			return nil, fmt.Errorf("failed to read body file %v", err)
+		}
+		defer bodyFile.Close()
+	}
+
+	runCtx, abort := context.WithCancelCause(p.config.Ctx)
+	defer abort(nil)
+	p.aborter, p.abort = newAborter(p.config), abort
+
+	p.shards = newStatsShards(int(numWorkers))
+
+	newClientConfig := func() *http_clients.Config {
+		return &http_clients.Config{
+			ReqURI:                 p.config.ReqURI,
+			DisableKeepAlive:       p.config.DisableKeepAlive,
+			SkipVerify:             p.config.SkipVerify,
+			ReqTarget:              reqsPerWorker,
+			Ctx:                    runCtx,
+			StartTrigger:           startTrigger,
+			Until:                  p.config.Duration,
+			ReadTimeout:            p.config.ReadTimeout,
+			WriteTimeout:           p.config.WriteTimeout,
+			Method:                 p.config.Method,
+			Verbose:                p.config.Verbose,
+			Headers:                p.config.Headers,
+			Body:                   p.config.Body,
+			BodyFile:               bodyFile,
+			Client:                 p.config.Client,
+			Parallel:               p.config.Parallel || (p.config.Pipeline > 1 && !p.config.SharesConns()),
+			Pipeline:               int(p.config.Pipeline),
+			Handshake:              p.config.Handshake,
+			SessionResumption:      p.config.SessionResumption,
+			CACert:                 p.config.CACert,
+			SNI:                    p.config.SNI,
+			TLSMin:                 p.config.TLSMin,
+			TLSMax:                 p.config.TLSMax,
+			TLSCiphers:             p.config.TLSCiphers,
+			TLSCurves:              p.config.TLSCurves,
+			ALPN:                   p.config.ALPN,
+			H2MaxStreams:           int(p.config.H2MaxStreams),
+			H2Conns:                int(p.config.H2Conns),
+			H2MaxHeaderListSize:    p.config.H2MaxHeaderListSize,
+			H2MaxFrameSize:         p.config.H2MaxFrameSize,
+			H2StreamWindow:         p.config.H2StreamWindow,
+			H2ConnWindow:           p.config.H2ConnWindow,
+			H2ReadIdleTimeout:      p.config.H2ReadIdleTimeout,
+			H2PingTimeout:          p.config.H2PingTimeout,
+			QUICHandshakeTimeout:   p.config.QUICHandshakeTimeout,
+			QUICIdleTimeout:        p.config.QUICIdleTimeout,
+			QUICKeepAlive:          p.config.QUICKeepAlive,
+			QUICMaxIncomingStreams: p.config.QUICMaxIncomingStreams,
+			QUIC0RTT:               p.config.QUIC0RTT,
+			MaxRedirects:           int(p.config.MaxRedirects),
+			MaxInflight:            int(p.config.MaxInflight),
+			ThinkTime:              p.config.ThinkTimeDist,
+			Arrival:                p.config.ArrivalProcess,
+			Retry:                  p.config.RetryPolicy,
+			HonorRetryAfter:        p.config.HonorRetryAfter,
+		}
+	}
+
+	var gate *http_clients.Gate
+	if p.config.ControlAddr != "" {
+		gate = http_clients.NewGate(int(numWorkers), p.config.ReqTarget != 0)
+	}
+
+	workerConfigs := make([]*http_clients.Config, numWorkers)
+	for i := range workerConfigs {
+		c := newClientConfig()
+		c.Gate = gate
+		c.WorkerID = i
+		shard := p.shards[i%len(p.shards)]
+		c.ReqStats, c.ErrStats, c.RespSizes = shard.latencies, shard.failed, shard.respSizes
 
 		// evenly distribute remainder reqs
 		if remainderReqs > 0 {
@@ -186,21 +498,90 @@ func (p *PayLoader) handleReqs() (*GoPayloaderResults, error) {
 			remainderReqs--
 		}
 
+		if warmup != nil {
+			w := *warmup
+			w.ReqTarget = warmupPerWorker
+			if remainderWarmup > 0 {
+				w.ReqTarget++
+				remainderWarmup--
+			}
+			c.Warmup = &w
+		}
+
 		if p.config.SendJWT {
 			c.JwtStreamReceiver = jwtStream
 			c.JWTHeader = p.config.JwtHeader
 		}
+		workerConfigs[i] = c
+	}
+
+	var workers []worker.Worker
+	closeConns := func() {}
+	if p.config.SharesConns() {
+		connConfigs := make([]*http_clients.Config, p.config.Conns)
+		for conn := range connConfigs {
+			c := newClientConfig()
+			p.setConnConfig(c, conn)
+			connConfigs[conn] = c
+		}
+
+		var err error
+		if workers, closeConns, err = worker.NewPool(workerConfigs, connConfigs); err != nil {
+			return nil, err
+		}
+	} else {
+		for conn, c := range workerConfigs {
+			p.setConnConfig(c, conn)
+			w, err := worker.NewWorker(c)
+			if err != nil {
+				// nothing has been sent yet, abandoning only closes the connections of the workers already created
+				for _, w := range workers {
+					w.Abandon()
+				}
+				return nil, err
+			}
+			workers = append(workers, w)
+		}
+	}
 
-		w, err := worker.NewWorker(c)
+	// connections of requests still in flight once the grace period passes are closed so they can't outlive the run
+	abandonConns := func() {
+		for _, w := range workers {
+			w.Abandon()
+		}
+		closeConns()
+	}
+
+	var controlServer *control.Server
+	if gate != nil {
+		var err error
+		controlServer, err = control.Listen(p.config.ControlAddr, gate, func() control.Snapshot {
+			return p.snapshot(workers)
+		})
 		if err != nil {
+			closeConns()
 			return nil, err
 		}
+		defer controlServer.Close()
 
-		workers[conn] = w
+		// served from the start so ctl commands sent during the warm-up are answered rather than left waiting
+		pterm.Info.Printf("Control API listening on %s\n", controlServer.Addr())
+		go func() {
+			if err := controlServer.Serve(); err != nil {
+				pterm.Error.Printf("Control API stopped; %v\n", err)
+			}
+		}()
+	}
+
+	for _, w := range workers {
 		go w.Run(workersComplete)
 	}
 
 	p.startWorkers(startTrigger)
+	var warmupResults *Warmup
+	if warmup != nil {
+		warmupResults = p.warmup(runCtx, workers, warmup, abandonConns)
+	}
 	p.startTimer()
 
 	ctx, stopStatsCalc := context.WithCancel(context.Background())
@@ -209,8 +590,9 @@ func (p *PayLoader) handleReqs() (*GoPayloaderResults, error) {
 		go p.displayProgress(ctx, workers, int(p.config.ReqTarget), p.config.Duration)
 	}
 
-	results := &GoPayloaderResults{}
-	go p.calcReqStats(ctx, reqStats, results)
+	results := &GoPayloaderResults{Warmup: warmupResults}
+	statsDone := make(chan struct{})
+	go p.calcReqStats(ctx, results, statsDone, workers)
 
 	if jwtErr != nil {
 		err, _ := <-jwtErr
@@ -220,50 +602,164 @@ func (p *PayLoader) handleReqs() (*GoPayloaderResults, error) {
 		}
 	}
 
-	workersComplete.Wait()
+	p.waitForWorkers(runCtx, workersComplete, abandonConns)
+	closeConns()
 	pterm.Success.Printf("Payload complete, calculating results\n")
 
 	p.stopTimer()
 	stopStatsCalc()
+	<-statsDone
 
+	var aborted *abortError
+	if errors.As(context.Cause(runCtx), &aborted) {
+		results.Aborted = true
+		results.AbortReason = aborted.reason
+	}
+	results.Interrupted = p.config.Ctx.Err() != nil
 	return p.ComputeResults(workers, results)
 }
 
-func (p *PayLoader) calcReqStats(ctx context.Context, recv <-chan time.Duration, result *GoPayloaderResults) {
-	var t time.Duration
-	var rps int64 = 0
+// snapshot sums the workers' live stats for the control API
+func (p *PayLoader) snapshot(workers []worker.Worker) control.Snapshot {
+	s := control.Snapshot{
+		Elapsed:   time.Since(p.startTime),
+		Responses: make(map[int]int64),
+		Errors:    make(map[string]uint64),
+		Examples:  make(map[string]string),
+	}
+	for _, w := range workers {
+		stats := w.Stats()
+		s.Completed += stats.CompletedReqs
+		s.Failed += stats.FailedReqs
+		s.Inflight += stats.Inflight
+		stats.Responses.Range(func(key, value any) bool {
+			s.Responses[int(key.(worker.ResponseCode))] += value.(int64)
+			return true
+		})
+		stats.Errors.Range(func(key, value any) bool {
+			s.Errors[key.(string)] += value.(uint64)
+			return true
+		})
+		stats.ErrorExamples.Range(func(key, value any) bool {
+			if _, ok := s.Examples[key.(string)]; !ok {
+				s.Examples[key.(string)] = value.(string)
+			}
+			return true
+		})
+	}
+	if secs := s.Elapsed.Seconds(); secs > 0 {
+		s.RPS = float64(s.Completed) / secs
+	}
+	return s
+}
+
+// waitForWorkers waits for the workers to complete, once the run is cancelled in-flight requests are given the grace
+// period to complete before abandonConns closes their connections
+func (p *PayLoader) waitForWorkers(ctx context.Context, workersComplete *sync.WaitGroup, abandonConns func()) {
+	done := make(chan struct{})
+	go func() {
+		workersComplete.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	pterm.Info.Printf("Stopping; waiting up to %s for in-flight requests to complete\n", p.config.GracePeriod)
+	grace := time.NewTimer(p.config.GracePeriod)
+	defer grace.Stop()
+
+	select {
+	case <-done:
+	case <-grace.C:
+		pterm.Warning.Printf("Grace period passed, requests still in flight are missing from results\n")
+		abandonConns()
+	}
+
+	// abandoned requests fail as soon as their connections are closed
+	closed := time.NewTimer(abandonWait)
+	defer closed.Stop()
+	select {
+	case <-done:
+	case <-closed.C:
+	}
+}
+
+// calcReqStats reads the workers' counters every second for the RPS and abort conditions, workers record stats
+// themselves so nothing is sent per request
+func (p *PayLoader) calcReqStats(ctx context.Context, result *GoPayloaderResults, done chan<- struct{}, workers []worker.Worker) {
+	var prevCompleted int64
 	timer := time.NewTicker(time.Second)
+	defer timer.Stop()
+	defer close(done)
+	abortCheck := time.NewTicker(abortCheckEvery)
+	defer abortCheck.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			// req finished
 			return
 		case <-timer.C:
+			var completed int64
+			for _, w := range workers {
+				c, _ := w.Counts()
+				completed += c
+			}
 			// new RPS
+			rps := completed - prevCompleted
+			prevCompleted = completed
 			if rps > result.RPS.Max {
 				result.RPS.Max = rps
 			}
 			if rps < result.RPS.Min || result.RPS.Min == 0 {
 				result.RPS.Min = rps
 			}
-			rps = 0
-		case t = <-recv:
-			rps++
-			if t > result.Latency.Max {
-				result.Latency.Max = t
+
+			if p.aborter != nil {
+				p.checkAbort(p.aborter.tick(workers, p.mergeLatencies))
 			}
-			if t < result.Latency.Min || result.Latency.Min == 0 {
-				result.Latency.Min = t
+		case <-abortCheck.C:
+			if p.aborter != nil {
+				p.checkAbort(p.aborter.checkErrors(workers))
 			}
-			result.Latency.Total += t
 		}
 	}
 }
 
+// mergeLatencies adds the latencies recorded so far by all workers to h
+func (p *PayLoader) mergeLatencies(h *histogram.Histogram) {
+	for _, s := range p.shards {
+		h.MergeAtomic(s.latencies)
+	}
+}
+
+// mergeShards merges the workers' shards once the run completes
+func (p *PayLoader) mergeShards() {
+	p.latencies.Reset()
+	p.failedLatencies.Reset()
+	p.respSizes.Reset()
+	for _, s := range p.shards {
+		p.latencies.MergeAtomic(s.latencies)
+		p.failedLatencies.MergeAtomic(s.failed)
+		p.respSizes.MergeAtomic(s.respSizes)
+	}
+}
+
+// checkAbort cancels the run if an abort condition was met
+func (p *PayLoader) checkAbort(err error) {
+	if err == nil {
+		return
+	}
+	pterm.Warning.Printf("Aborting; %v\n", err)
+	p.abort(err)
+	p.aborter = nil
+}
+
 func (p *PayLoader) displayProgress(ctx context.Context, workers []worker.Worker, reqTarget int, endTime time.Duration) {
 	tick := time.NewTicker(p.config.VerboseTicker)
-	var stats worker.Stats
 	var prevSuccess, prevError int64 = 0, 0
 	var progress *pterm.ProgressbarPrinter
 
@@ -294,9 +790,9 @@ func (p *PayLoader) displayProgress(ctx context.Context, workers []worker.Worker
 			var success int64 = 0
 
 			for _, w := range workers {
-				stats = w.Stats()
-				errs += stats.FailedReqs
-				success += stats.CompletedReqs
+				completed, failed := w.Counts()
+				errs += failed
+				success += completed
 			}
 
 			displayStats.Update(
@@ -339,8 +835,58 @@ func (p *PayLoader) getProgressBar(endTime time.Duration, reqTarget int) (*pterm
 }
 
 func (p *PayLoader) Run() (*GoPayloaderResults, error) {
+	if err := p.config.Parse(); err != nil {
+		return nil, err
+	}
 	if err := p.config.Validate(); err != nil {
 		return nil, err
 	}
+
 	return p.handleReqs()
 }
+
+// warmup waits for the workers to warm up their connections, summarising the warm-up before resetting its stats so
+// none of its requests reach the measured run's results, then lets the workers start the measured run
+func (p *PayLoader) warmup(ctx context.Context, workers []worker.Worker, warmup *http_clients.Warmup, abandonConns func()) *Warmup {
+	defer warmup.Measure.Done()
+	if p.config.WarmupRequests != 0 {
+		pterm.Info.Printf("Warming up with %d request/s, excluded from results\n", p.config.WarmupRequests)
+	} else {
+		pterm.Info.Printf("Warming up for %s, excluded from results\n", p.config.Warmup)
+	}
+
+	p.startTimer()
+	p.waitForWorkers(ctx, warmup.Done, abandonConns)
+	p.stopTimer()
+
+	var completed, failed int64
+	for _, w := range workers {
+		c, f := w.Counts()
+		completed += c
+		failed += f
+	}
+	p.mergeShards()
+	summary := &Warmup{
+		Total:          p.stopTime.Sub(p.startTime),
+		CompletedReqs:  completed,
+		FailedReqs:     failed,
+		AverageLatency: time.Duration(p.latencies.Mean()),
+		P99Latency:     time.Duration(p.latencies.Percentile(99)),
+	}
+	if summary.Total > 0 {
+		summary.RPS = float64(completed) / summary.Total.Seconds()
+	}
+
+	for _, w := range workers {
+		w.ResetStats()
+	}
+	for _, s := range p.shards {
+		s.latencies.Reset()
+		s.failed.Reset()
+		s.respSizes.Reset()
+	}
+
+	pterm.Success.Printf("Warm-up complete; %d completed, %d failed requests in %s, %.2f req/s, avg latency %s\n",
+		summary.CompletedReqs, summary.FailedReqs, summary.Total, summary.RPS, summary.AverageLatency)
+	return summary
+}
@@ -1,21 +1,38 @@
 package payloader
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"github.com/domsolutions/gopayloader/config"
+	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
+	"github.com/domsolutions/gopayloader/pkgs/http-clients/nethttp"
+	"github.com/domsolutions/gopayloader/pkgs/payloader/control"
+	"github.com/domsolutions/gopayloader/pkgs/payloader/histogram"
 	"github.com/domsolutions/gopayloader/pkgs/payloader/worker"
 	"github.com/quic-go/quic-go"
 	httpv3server "github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/logging"
 	"github.com/valyala/fasthttp"
 	golanghttp2 "golang.org/x/net/http2"
+	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -459,6 +476,33 @@ func testPayLoader_Run(t *testing.T, addr, client string, cleanup func()) {
 		})
 	}
 
+	if client == "fasthttp" {
+		tests = append(tests, tcase{
+			name: "PIPELINE - GET 10 connections with depth 8 for 210 requests",
+			fields: fields{config: &config.Config{
+				Pipeline:      8,
+				Ctx:           context.Background(),
+				ReqURI:        addr,
+				ReqTarget:     210,
+				Conns:         10,
+				ReadTimeout:   5 * time.Second,
+				WriteTimeout:  5 * time.Second,
+				Method:        "GET",
+				Client:        client,
+				VerboseTicker: time.Second,
+				SkipVerify:    true,
+			}},
+			want: &GoPayloaderResults{
+				CompletedReqs: 210,
+				FailedReqs:    0,
+				Responses: map[worker.ResponseCode]int64{
+					200: 210,
+				},
+				Errors: nil,
+			},
+		})
+	}
+
 	if cleanup != nil {
 		t.Cleanup(cleanup)
 	}
@@ -512,3 +556,2215 @@ func testPayLoader_Run(t *testing.T, addr, client string, cleanup func()) {
 	}
 
 }
+
+// testConfig fills in the fields c leaves unset with those most tests share, a background context, 5s timeouts, GET
+// requests over fasthttp and a 1s verbose ticker
+func testConfig(c *config.Config) *config.Config {
+	if c.Ctx == nil {
+		c.Ctx = context.Background()
+	}
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = 5 * time.Second
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = 5 * time.Second
+	}
+	if c.Method == "" {
+		c.Method = "GET"
+	}
+	if c.Client == "" {
+		c.Client = "fasthttp"
+	}
+	if c.VerboseTicker == 0 {
+		c.VerboseTicker = time.Second
+	}
+	return c
+}
+
+func TestPayLoader_RunHandshake(t *testing.T) {
+	// session resumption needs a server cert which hasn't expired
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+	noTicketsServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	noTicketsServer.TLS = &tls.Config{SessionTicketsDisabled: true}
+	noTicketsServer.StartTLS()
+	t.Cleanup(noTicketsServer.Close)
+
+	tests := []struct {
+		name              string
+		addr              string
+		sessionResumption bool
+		wantResumed       int64
+	}{
+		{name: "handshake 5 connections for 50 handshakes", addr: "https://localhost:8889"},
+		{name: "handshake 5 connections for 50 handshakes with session resumption", addr: server.URL, sessionResumption: true, wantResumed: 45},
+		{name: "handshake with session resumption to server without tickets", addr: noTicketsServer.URL, sessionResumption: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPayLoader(testConfig(&config.Config{
+				ReqURI:            tt.addr,
+				ReqTarget:         50,
+				Conns:             5,
+				SkipVerify:        true,
+				Handshake:         true,
+				SessionResumption: tt.sessionResumption,
+			}))
+			got, err := p.Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+			if got.CompletedReqs != 50 {
+				t.Errorf("wanted completed handshakes 50 got %d", got.CompletedReqs)
+			}
+			if got.TLSVersions["TLS 1.3"] != 50 {
+				t.Errorf("wanted 50 TLS 1.3 handshakes got %v", got.TLSVersions)
+			}
+			if len(got.Responses) != 0 {
+				t.Errorf("wanted no response codes in handshake mode got %v", got.Responses)
+			}
+			// first handshake per connection can't be resumed
+			if got.ResumedSessions != tt.wantResumed {
+				t.Errorf("wanted %d resumed sessions got %d", tt.wantResumed, got.ResumedSessions)
+			}
+			// waiting up to 250ms for a session ticket isn't part of the handshake latency
+			if got.Latency.Max >= 250*time.Millisecond {
+				t.Errorf("wanted handshake latency under the ticket wait got max %s", got.Latency.Max)
+			}
+		})
+	}
+}
+
+func TestPayLoader_RunResolve(t *testing.T) {
+	var lock sync.Mutex
+	remoteIPs := make(map[string]int)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		lock.Lock()
+		remoteIPs[host]++
+		lock.Unlock()
+	})
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	t.Cleanup(tlsServer.Close)
+	localhost := func(uri string) string {
+		return strings.Replace(uri, "127.0.0.1", "localhost", 1)
+	}
+
+	tests := []struct {
+		addr      string
+		client    string
+		sourceIPs []string
+		// category is the error category requests fail under when they can't be sent from the source ip
+		category string
+	}{
+		{addr: "http://gopayloader.test:8888", client: "fasthttp"},
+		{addr: "https://gopayloader.test:8889", client: "nethttp"},
+		{addr: "https://gopayloader.test:8889", client: "nethttp2"},
+		{addr: localhost(server.URL), client: "fasthttp", sourceIPs: []string{"127.0.0.2"}},
+		{addr: localhost(tlsServer.URL), client: "nethttp2", sourceIPs: []string{"127.0.0.2"}},
+		// TEST-NET-1 address isn't assigned to any interface
+		{addr: localhost(server.URL), client: "fasthttp", sourceIPs: []string{"192.0.2.1"}, category: http_clients.ErrCategorySourceAddr},
+		{addr: localhost(tlsServer.URL), client: "nethttp2", sourceIPs: []string{"192.0.2.1"}, category: http_clients.ErrCategorySourceAddr},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.client+" resolve "+tt.addr+" from "+strings.Join(tt.sourceIPs, ","), func(t *testing.T) {
+			u, err := url.Parse(tt.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lock.Lock()
+			clear(remoteIPs)
+			lock.Unlock()
+
+			p := NewPayLoader(testConfig(&config.Config{
+				ReqURI:     tt.addr,
+				ReqTarget:  50,
+				Conns:      5,
+				Client:     tt.client,
+				SkipVerify: true,
+				Resolve:    []string{u.Host + ":127.0.0.1"},
+				SourceIPs:  tt.sourceIPs,
+			}))
+			got, err := p.Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+
+			if tt.category != "" {
+				if got.FailedReqs != 50 || got.Errors[tt.category] != 50 {
+					t.Errorf("wanted 50 requests failed with %s got %d failed, errors %v", tt.category, got.FailedReqs, got.ErrorExamples)
+				}
+				return
+			}
+			if got.CompletedReqs != 50 {
+				t.Errorf("wanted completed reqs 50 got %d", got.CompletedReqs)
+			}
+			ip := got.IPs["127.0.0.1"]
+			if ip.Requests != 50 || ip.Conns != 5 || ip.Errors != 0 {
+				t.Errorf("wanted 50 requests over 5 conns to 127.0.0.1 got %+v", got.IPs)
+			}
+			if len(tt.sourceIPs) > 0 {
+				lock.Lock()
+				defer lock.Unlock()
+				if remoteIPs[tt.sourceIPs[0]] != 50 || len(remoteIPs) != 1 {
+					t.Errorf("wanted 50 requests from %s got %v", tt.sourceIPs[0], remoteIPs)
+				}
+			}
+		})
+	}
+}
+
+func TestPayLoader_RunResolveRoundRobin(t *testing.T) {
+	// the same port on two loopback addresses so a host can resolve to both
+	reqs := make(map[string]*atomic.Int64)
+	var port string
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2"} {
+		l, err := net.Listen("tcp", net.JoinHostPort(ip, port))
+		if err != nil {
+			t.Skipf("can't listen on %s; %v", ip, err)
+		}
+		_, port, _ = net.SplitHostPort(l.Addr().String())
+
+		count := &atomic.Int64{}
+		reqs[ip] = count
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count.Add(1)
+		}))
+		server.Listener.Close()
+		server.Listener = l
+		server.EnableHTTP2 = true
+		server.StartTLS()
+		t.Cleanup(server.Close)
+	}
+
+	tests := []struct {
+		client  string
+		conns   uint
+		h2Conns uint
+	}{
+		// a single dialer dials both connections
+		{client: "nethttp2", conns: 1, h2Conns: 2},
+		{client: "fasthttp", conns: 4},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.client, func(t *testing.T) {
+			for _, count := range reqs {
+				count.Store(0)
+			}
+			got, err := NewPayLoader(testConfig(&config.Config{
+				ReqURI:     "https://gopayloader.test:" + port,
+				ReqTarget:  40,
+				Conns:      tt.conns,
+				H2Conns:    tt.h2Conns,
+				Client:     tt.client,
+				SkipVerify: true,
+				Resolve:    []string{"gopayloader.test:" + port + ":127.0.0.1,127.0.0.2"},
+			})).Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+			if got.CompletedReqs != 40 {
+				t.Fatalf("wanted 40 completed requests got %d, errors %v", got.CompletedReqs, got.ErrorExamples)
+			}
+
+			// connections alternate between the IPs and requests are counted against the IP they were sent to
+			conns := int64(max(tt.conns, tt.h2Conns)) / 2
+			for ip, count := range reqs {
+				stats := got.IPs[ip]
+				if stats.Conns != conns || stats.Requests != 20 || count.Load() != 20 || stats.Errors != 0 {
+					t.Errorf("wanted 20 requests over %d conns to %s got %+v with %d received", conns, ip, stats, count.Load())
+				}
+			}
+		})
+	}
+}
+
+func TestPayLoader_RunTLSConfig(t *testing.T) {
+	const cipher = "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"
+
+	tests := []struct {
+		client string
+		alpn   string
+	}{
+		{client: "fasthttp"},
+		{client: "nethttp", alpn: "http/1.1"},
+		{client: "nethttp2", alpn: "h2"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.client+" TLS 1.2 "+cipher, func(t *testing.T) {
+			var alpn []string
+			if tt.alpn != "" {
+				alpn = []string{tt.alpn}
+			}
+			p := NewPayLoader(testConfig(&config.Config{
+				ReqURI:     "https://localhost:8889",
+				ReqTarget:  50,
+				Conns:      5,
+				Client:     tt.client,
+				SkipVerify: true,
+				TLSMin:     "1.2",
+				TLSMax:     "1.2",
+				TLSCiphers: []string{cipher},
+				TLSCurves:  []string{"X25519"},
+				ALPN:       alpn,
+			}))
+			got, err := p.Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+			if got.CompletedReqs != 50 {
+				t.Errorf("wanted completed reqs 50 got %d", got.CompletedReqs)
+			}
+			if got.TLSVersions["TLS 1.2"] == 0 || len(got.TLSVersions) != 1 {
+				t.Errorf("wanted only TLS 1.2 handshakes got %v", got.TLSVersions)
+			}
+			if got.CipherSuites[cipher] == 0 || len(got.CipherSuites) != 1 {
+				t.Errorf("wanted only %s handshakes got %v", cipher, got.CipherSuites)
+			}
+			if tt.alpn != "" && got.ALPNProtocols[tt.alpn] == 0 {
+				t.Errorf("wanted ALPN protocol %s got %v", tt.alpn, got.ALPNProtocols)
+			}
+		})
+	}
+
+	alpnTests := []struct {
+		client    string
+		alpn      []string
+		handshake bool
+		err       string
+	}{
+		{client: "fasthttp", alpn: []string{"h2"}, err: "config: fasthttp client can't speak ALPN protocol h2, only http/1.1"},
+		{client: "nethttp", alpn: []string{"http/1.1", "h2"}, err: "config: nethttp client can't speak ALPN protocol h2, only http/1.1"},
+		{client: "nethttp2", alpn: []string{"http/1.1"}, err: "config: nethttp2 client can't speak ALPN protocol http/1.1, only h2"},
+		{client: "nethttp3", alpn: []string{"h2"}, err: "config: nethttp3 client can't speak ALPN protocol h2, only h3"},
+		{client: "fasthttp", alpn: []string{"h2", "http/1.1"}, handshake: true},
+	}
+	for _, tt := range alpnTests {
+		c := testConfig(&config.Config{
+			ReqURI:    "https://localhost:8889",
+			ReqTarget: 10,
+			Conns:     1,
+			Client:    tt.client,
+			ALPN:      tt.alpn,
+			Handshake: tt.handshake,
+		})
+		err := c.Validate()
+		if tt.err == "" && err != nil {
+			t.Errorf("Validate() error = %v, wanted no error for %s offering %v", err, tt.client, tt.alpn)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("Validate() error = %v, wanted %s", err, tt.err)
+		}
+	}
+}
+
+func TestPayLoader_RunMTLSIdentities(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	crt, err := os.ReadFile(crtPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// paths can contain commas
+	dir := filepath.Join(t.TempDir(), "clients,eu")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"alice.crt": crt, "alice.key": key, "bob.pem": crt, "bob-key.pem": key} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := NewPayLoader(testConfig(&config.Config{
+		ReqURI:     server.URL,
+		ReqTarget:  40,
+		Conns:      4,
+		SkipVerify: true,
+		MTLSDir:    dir,
+	}))
+	got, err := p.Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v, wanted no error", err)
+	}
+	if got.Responses[200] != 40 {
+		t.Errorf("wanted 40 200 responses got %v", got.Responses)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		id, ok := got.MTLSIdentities[name]
+		if !ok {
+			t.Fatalf("wanted results for mTLS identity %s got %v", name, got.MTLSIdentities)
+		}
+		if id.Conns != 2 || id.CompletedReqs != 20 || id.Responses[200] != 20 {
+			t.Errorf("wanted 20 reqs over 2 conns for mTLS identity %s got %+v", name, id)
+		}
+	}
+
+	c := testConfig(&config.Config{
+		ReqURI:    server.URL,
+		ReqTarget: 40,
+		Conns:     4,
+		MTLSCerts: []string{filepath.Join(dir, "alice.crt")},
+		MTLSKeys:  []string{filepath.Join(dir, "alice.key")},
+		MTLSDir:   dir,
+	})
+	if err := c.Validate(); err != nil || c.MTLSIdentities != nil {
+		t.Errorf("wanted Validate() to only check the config got error %v, identities %v", err, c.MTLSIdentities)
+	}
+	if err := c.Parse(); err != nil {
+		t.Fatalf("Parse() error = %v, wanted no error", err)
+	}
+	if len(c.MTLSIdentities) != 3 || c.MTLSIdentities[0].Name != "alice" || c.MTLSIdentities[1].Name != c.MTLSIdentities[1].Cert {
+		t.Errorf("wanted alice then identities from dir with duplicate names replaced by the cert path got %+v", c.MTLSIdentities)
+	}
+}
+
+func TestPayLoader_RunFixedTimeRequestsParallel(t *testing.T) {
+	var reqs atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs.Add(1)
+		// still in flight when the duration passes
+		time.Sleep(200 * time.Millisecond)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	got, err := NewPayLoader(testConfig(&config.Config{
+		Ctx:        ctx,
+		ReqURI:     server.URL,
+		ReqTarget:  20,
+		Duration:   100 * time.Millisecond,
+		Conns:      2,
+		Client:     "nethttp2",
+		Parallel:   true,
+		SkipVerify: true,
+	})).Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v, wanted no error", err)
+	}
+	// requests in flight at the deadline were sent so aren't sent again
+	if got.CompletedReqs != 20 || reqs.Load() != 20 {
+		t.Errorf("wanted 20 requests sent and completed got %d sent, %d completed", reqs.Load(), got.CompletedReqs)
+	}
+}
+
+func TestPayLoader_RunHTTP2Tuning(t *testing.T) {
+	body := bytes.Repeat([]byte("a"), 256<<10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	p := NewPayLoader(testConfig(&config.Config{
+		ReqURI:            server.URL,
+		ReqTarget:         100,
+		Conns:             5,
+		Client:            "nethttp2",
+		Parallel:          true,
+		SkipVerify:        true,
+		H2Conns:           2,
+		H2MaxStreams:      2,
+		H2StreamWindow:    16 << 10,
+		H2ConnWindow:      128 << 10,
+		H2MaxFrameSize:    1 << 15,
+		H2ReadIdleTimeout: time.Second,
+		H2PingTimeout:     time.Second,
+	}))
+	got, err := p.Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v, wanted no error", err)
+	}
+	if got.CompletedReqs != 100 || got.Responses[200] != 100 {
+		t.Errorf("wanted 100 200 responses got %d completed %v", got.CompletedReqs, got.Responses)
+	}
+	// one handshake per HTTP/2 connection
+	if got.ALPNProtocols["h2"] != 10 {
+		t.Errorf("wanted 10 h2 connections got %v", got.ALPNProtocols)
+	}
+}
+
+func TestPayLoader_RunQUIC(t *testing.T) {
+	// borrow httptest's cert, session tickets aren't issued for the expired test cert
+	certServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	cert := certServer.TLS.Certificates[0]
+	certServer.Close()
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httpv3server.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				time.Sleep(2 * time.Second)
+			}
+		}),
+		TLSConfig:  httpv3server.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		QUICConfig: &quic.Config{Allow0RTT: true},
+	}
+	go server.Serve(udpConn)
+	t.Cleanup(func() {
+		server.Close()
+		udpConn.Close()
+	})
+	addr := "https://" + udpConn.LocalAddr().String()
+
+	tests := []struct {
+		name       string
+		config     *config.Config
+		completed  int64
+		handshakes int64
+		used0RTT   bool
+	}{
+		{
+			name:       "5 connections for 50 requests",
+			config:     &config.Config{ReqURI: addr, ReqTarget: 50, Conns: 5},
+			completed:  50,
+			handshakes: 5,
+		},
+		{
+			name:       "requests time out",
+			config:     &config.Config{ReqURI: addr + "/slow", ReqTarget: 2, Conns: 1, ReadTimeout: 250 * time.Millisecond, WriteTimeout: 250 * time.Millisecond},
+			completed:  0,
+			handshakes: 1,
+		},
+		{
+			name:       "0-RTT after idle timeout",
+			config:     &config.Config{ReqURI: addr, ReqTarget: 2, Conns: 1, Duration: 2 * time.Second, QUICIdleTimeout: 250 * time.Millisecond, QUIC0RTT: true},
+			completed:  2,
+			handshakes: 2,
+			used0RTT:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.config
+			c.Ctx = context.Background()
+			c.Method = "GET"
+			c.Client = "nethttp3"
+			c.VerboseTicker = time.Second
+			c.SkipVerify = true
+			if c.ReadTimeout == 0 {
+				c.ReadTimeout = 5 * time.Second
+				c.WriteTimeout = 5 * time.Second
+			}
+
+			got, err := NewPayLoader(c).Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+			if got.CompletedReqs != tt.completed || got.CompletedReqs+got.FailedReqs != c.ReqTarget {
+				t.Errorf("wanted %d completed reqs got %d, %d failed %v", tt.completed, got.CompletedReqs, got.FailedReqs, got.Errors)
+			}
+			if got.QUIC.Handshakes != tt.handshakes {
+				t.Errorf("wanted %d QUIC handshakes got %d", tt.handshakes, got.QUIC.Handshakes)
+			}
+			if tt.used0RTT && got.QUIC.Used0RTT == 0 {
+				t.Errorf("wanted 0-RTT handshakes got %+v", got.QUIC)
+			}
+			// the client's address doesn't change
+			if got.QUIC.PathChanges != 0 {
+				t.Errorf("wanted no QUIC path changes got %d", got.QUIC.PathChanges)
+			}
+		})
+	}
+
+	t.Run("path challenges are counted as path changes", func(t *testing.T) {
+		var changes int
+		tracer := nethttp.QUICTracer(func() { changes++ })(context.Background(), logging.PerspectiveClient, quic.ConnectionID{})
+		for _, frames := range [][]logging.Frame{
+			{&logging.PingFrame{}},
+			{&logging.PathChallengeFrame{}, &logging.PingFrame{}},
+			{&logging.PathResponseFrame{}},
+			// a packet validating a path is one change
+			{&logging.PathChallengeFrame{}, &logging.PathChallengeFrame{}},
+		} {
+			tracer.ReceivedShortHeaderPacket(&logging.ShortHeader{}, 1200, logging.ECNUnsupported, frames)
+		}
+		if changes != 2 {
+			t.Errorf("wanted 2 path changes got %d", changes)
+		}
+	})
+}
+
+func TestPayLoader_RunRedirects(t *testing.T) {
+	var lock sync.Mutex
+	// final hop requests by method and body
+	finalReqs := make(map[string]int)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+		case "/see-other":
+			http.Redirect(w, r, "/b", http.StatusSeeOther)
+		case "/away":
+			http.Redirect(w, r, "http://example.com/", http.StatusFound)
+		case "/secure":
+			http.Redirect(w, r, "https://"+r.Host+"/c", http.StatusMovedPermanently)
+		case "/c":
+			body, _ := io.ReadAll(r.Body)
+			lock.Lock()
+			finalReqs[r.Method+" "+string(body)]++
+			lock.Unlock()
+		}
+	})
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	t.Cleanup(tlsServer.Close)
+
+	tests := []struct {
+		client       string
+		addr         string
+		path         string
+		maxRedirects uint
+		method       string
+		body         string
+		code         worker.ResponseCode
+		chains       int64
+		err          string
+		// final is the method and body of requests reaching the end of the chain
+		final string
+	}{
+		{client: "fasthttp", addr: server.URL, path: "/a", maxRedirects: 10, code: 200, chains: 20},
+		{client: "nethttp", addr: server.URL, path: "/a", maxRedirects: 10, code: 200, chains: 20},
+		{client: "nethttp2", addr: tlsServer.URL, path: "/a", maxRedirects: 10, code: 200, chains: 20},
+		{client: "fasthttp", addr: server.URL, path: "/a", method: "PUT", body: "x", maxRedirects: 10, code: 200, chains: 20, final: "GET "},
+		{client: "nethttp", addr: server.URL, path: "/a", method: "PUT", body: "x", maxRedirects: 10, code: 200, chains: 20, final: "GET "},
+		{client: "fasthttp", addr: server.URL, path: "/see-other", method: "HEAD", maxRedirects: 10, code: 200, chains: 20, final: "HEAD "},
+		{client: "nethttp", addr: server.URL, path: "/see-other", method: "HEAD", maxRedirects: 10, code: 200, chains: 20, final: "HEAD "},
+		{client: "fasthttp", addr: server.URL, path: "/a", maxRedirects: 0, code: 302},
+		{client: "nethttp", addr: server.URL, path: "/a", maxRedirects: 0, code: 302},
+		{client: "fasthttp", addr: server.URL, path: "/a", maxRedirects: 1, err: "stopped after 1 redirects"},
+		{client: "nethttp", addr: server.URL, path: "/a", maxRedirects: 1, err: "stopped after 1 redirects"},
+		// redirects to other hosts or schemes are reported as is under the default --follow-redirects
+		{client: "fasthttp", addr: server.URL, path: "/away", maxRedirects: 10, code: 302},
+		{client: "nethttp", addr: server.URL, path: "/away", maxRedirects: 10, code: 302},
+		{client: "fasthttp", addr: server.URL, path: "/secure", maxRedirects: 10, code: 301},
+		{client: "nethttp", addr: server.URL, path: "/secure", maxRedirects: 10, code: 301},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		if tt.method == "" {
+			tt.method = "GET"
+		}
+		t.Run(fmt.Sprintf("%s %s %s max %d redirects", tt.client, tt.method, tt.path, tt.maxRedirects), func(t *testing.T) {
+			lock.Lock()
+			clear(finalReqs)
+			lock.Unlock()
+
+			p := NewPayLoader(testConfig(&config.Config{
+				ReqURI:       tt.addr + tt.path,
+				ReqTarget:    20,
+				Conns:        2,
+				Method:       tt.method,
+				Body:         tt.body,
+				Client:       tt.client,
+				SkipVerify:   true,
+				MaxRedirects: tt.maxRedirects,
+			}))
+			got, err := p.Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+			if tt.err != "" {
+				if got.Errors[http_clients.ErrCategoryOther] != 20 || got.ErrorExamples[http_clients.ErrCategoryOther] != tt.err {
+					t.Errorf("wanted 20 errors %q got %v, %v", tt.err, got.Errors, got.ErrorExamples)
+				}
+				return
+			}
+			if got.Responses[tt.code] != 20 {
+				t.Errorf("wanted 20 %d responses got %v, errors %v", tt.code, got.Responses, got.Errors)
+			}
+			if got.RedirectChains != tt.chains {
+				t.Errorf("wanted %d redirect chains got %d", tt.chains, got.RedirectChains)
+			}
+			if tt.chains > 0 && (len(got.RedirectLatency) != 3 || got.RedirectLatency[2].Requests != tt.chains) {
+				t.Errorf("wanted 3 hops for %d chains got %+v", tt.chains, got.RedirectLatency)
+			}
+			if tt.final != "" {
+				lock.Lock()
+				defer lock.Unlock()
+				if finalReqs[tt.final] != 20 || len(finalReqs) != 1 {
+					t.Errorf("wanted 20 %q requests at the end of the chain got %v", tt.final, finalReqs)
+				}
+			}
+		})
+	}
+}
+
+func TestPayLoader_RunConcurrency(t *testing.T) {
+	var inflight, maxInflight, conns atomic.Int64
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			max := maxInflight.Load()
+			if n <= max || maxInflight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	})
+	countConns := func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.ConnState = countConns
+	server.Start()
+	t.Cleanup(server.Close)
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.Config.ConnState = countConns
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	t.Cleanup(tlsServer.Close)
+
+	tests := []struct {
+		client      string
+		addr        string
+		conns       uint
+		concurrency uint
+		pipeline    uint
+		minInflight int64
+		maxInflight int64
+	}{
+		{client: "fasthttp", addr: server.URL, conns: 2, concurrency: 40, minInflight: 2, maxInflight: 2},
+		{client: "nethttp", addr: server.URL, conns: 2, concurrency: 40, minInflight: 2, maxInflight: 2},
+		{client: "fasthttp", addr: server.URL, conns: 8, concurrency: 2, minInflight: 1, maxInflight: 2},
+		{client: "fasthttp", addr: server.URL, conns: 2, concurrency: 8, pipeline: 4, minInflight: 2, maxInflight: 2},
+		{client: "nethttp2", addr: tlsServer.URL, conns: 2, concurrency: 40, minInflight: 3, maxInflight: 40},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(fmt.Sprintf("%s %d concurrent over %d connections", tt.client, tt.concurrency, tt.conns), func(t *testing.T) {
+			inflight.Store(0)
+			maxInflight.Store(0)
+			conns.Store(0)
+
+			p := NewPayLoader(testConfig(&config.Config{
+				ReqURI:      tt.addr,
+				ReqTarget:   200,
+				Conns:       tt.conns,
+				Concurrency: tt.concurrency,
+				Pipeline:    tt.pipeline,
+				Client:      tt.client,
+				SkipVerify:  true,
+			}))
+			got, err := p.Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+			if got.Responses[200] != 200 {
+				t.Errorf("wanted 200 responses got %v, errors %v", got.Responses, got.Errors)
+			}
+			if conns.Load() != int64(tt.conns) {
+				t.Errorf("wanted %d connections got %d", tt.conns, conns.Load())
+			}
+			if max := maxInflight.Load(); max < tt.minInflight || max > tt.maxInflight {
+				t.Errorf("wanted between %d and %d requests in flight got %d", tt.minInflight, tt.maxInflight, max)
+			}
+		})
+	}
+
+	t.Run("concurrency more than requests", func(t *testing.T) {
+		c := testConfig(&config.Config{
+			ReqURI:      server.URL,
+			ReqTarget:   10,
+			Conns:       1,
+			Concurrency: 20,
+		})
+		if err := c.Validate(); err == nil || err.Error() != "config: concurrency can't be more than requests" {
+			t.Errorf("Validate() error = %v, wanted concurrency error", err)
+		}
+	})
+}
+
+func TestPayLoader_RunMaxInflight(t *testing.T) {
+	var inflight, maxInflight atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			max := maxInflight.Load()
+			if n <= max || maxInflight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	c := testConfig(&config.Config{
+		ReqURI:      server.URL,
+		ReqTarget:   100,
+		Conns:       2,
+		Parallel:    true,
+		MaxInflight: 4,
+		Client:      "nethttp2",
+		SkipVerify:  true,
+	})
+	got, err := NewPayLoader(c).Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v, wanted no error", err)
+	}
+	if got.Responses[200] != 100 {
+		t.Errorf("wanted 100 responses got %v, errors %v", got.Responses, got.Errors)
+	}
+	if max := maxInflight.Load(); max > 8 {
+		t.Errorf("wanted at most 8 requests in flight over 2 connections got %d", max)
+	}
+	if got.Backpressure.MaxInflight != 4 || got.Backpressure.Blocked == 0 || got.Backpressure.Wait == 0 {
+		t.Errorf("wanted requests blocked by the in-flight cap got %+v", got.Backpressure)
+	}
+
+	c.Parallel = false
+	if err := c.Validate(); err == nil || err.Error() != "config: max in-flight only applies when running --parallel" {
+		t.Errorf("Validate() error = %v, wanted max in-flight error", err)
+	}
+}
+
+// parseAndValidate returns the first error parsing then validating c, as Run does
+func parseAndValidate(c *config.Config) error {
+	if err := c.Parse(); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+func TestPayLoader_RunVirtualUsers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	newConfig := func(thinkTime string) *config.Config {
+		return testConfig(&config.Config{
+			ReqURI:    server.URL,
+			Conns:     2,
+			VUs:       5,
+			ThinkTime: thinkTime,
+		})
+	}
+
+	for _, thinkTime := range []string{"2ms", "constant:2ms", "uniform:1ms,3ms", "exponential:2ms", "normal:2ms,1ms"} {
+		thinkTime := thinkTime
+		t.Run("requests with think time "+thinkTime, func(t *testing.T) {
+			c := newConfig(thinkTime)
+			c.ReqTarget = 50
+			got, err := NewPayLoader(c).Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+			if got.Responses[200] != 50 {
+				t.Errorf("wanted 50 responses got %v, errors %v", got.Responses, got.Errors)
+			}
+			vus := got.VirtualUsers
+			if vus.VUs != 5 || vus.MinIterations != 10 || vus.MaxIterations != 10 || vus.AvgIterations != 10 {
+				t.Errorf("wanted 10 iterations for each of 5 virtual users got %+v", vus)
+			}
+			if vus.ArrivalRate <= 0 {
+				t.Errorf("wanted an arrival rate got %f", vus.ArrivalRate)
+			}
+		})
+	}
+
+	t.Run("duration with think time", func(t *testing.T) {
+		c := newConfig("constant:50ms")
+		c.Duration = 500 * time.Millisecond
+		got, err := NewPayLoader(c).Run()
+		if err != nil {
+			t.Fatalf("Run() error = %v, wanted no error", err)
+		}
+		// each virtual user sends a request roughly every 50ms
+		if vus := got.VirtualUsers; vus.MinIterations < 5 || vus.MaxIterations > 11 {
+			t.Errorf("wanted 5 to 11 iterations per virtual user got %+v", vus)
+		}
+		if rate := got.VirtualUsers.ArrivalRate; rate < 50 || rate > 110 {
+			t.Errorf("wanted an arrival rate around 100 req/s got %f", rate)
+		}
+	})
+
+	for _, tt := range []struct {
+		thinkTime string
+		err       string
+	}{
+		{thinkTime: "normal:2ms", err: "config: think time: normal takes 2 duration/s, got 2ms"},
+		{thinkTime: "gamma:2ms", err: "config: think time: unknown distribution gamma, expected one of constant, uniform, exponential, normal"},
+		{thinkTime: "uniform:3ms,1ms", err: "config: think time: uniform min 3ms is more than max 1ms"},
+	} {
+		c := newConfig(tt.thinkTime)
+		c.ReqTarget = 50
+		if err := parseAndValidate(c); err == nil || err.Error() != tt.err {
+			t.Errorf("parseAndValidate() error = %v, wanted %s", err, tt.err)
+		}
+	}
+}
+
+func TestPayLoader_RunArrivals(t *testing.T) {
+	var mu sync.Mutex
+	var arrivals []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	curve := filepath.Join(t.TempDir(), "curve.csv")
+	if err := os.WriteFile(curve, []byte("timestamp,rps\n0,100\n500ms,300\n1s,0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(t *testing.T, arrival string, reqs int64, duration time.Duration) (*GoPayloaderResults, []time.Duration) {
+		mu.Lock()
+		arrivals = nil
+		mu.Unlock()
+
+		got, err := NewPayLoader(testConfig(&config.Config{
+			ReqURI:    server.URL,
+			ReqTarget: reqs,
+			Duration:  duration,
+			Conns:     2,
+			Arrival:   arrival,
+		})).Run()
+		if err != nil {
+			t.Fatalf("Run() error = %v, wanted no error", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		offsets := make([]time.Duration, len(arrivals))
+		for i, a := range arrivals {
+			offsets[i] = a.Sub(got.Start)
+		}
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+		return got, offsets
+	}
+
+	t.Run("poisson", func(t *testing.T) {
+		got, offsets := run(t, "poisson", 400, time.Second)
+		if got.Responses[200] != 400 || got.Schedule.Requests != 400 || got.Schedule.Arrival != "poisson" {
+			t.Fatalf("wanted 400 scheduled poisson requests got %v %+v", got.Responses, got.Schedule)
+		}
+		// gaps between poisson arrivals are exponential, their standard deviation is about their mean
+		var sum, sumSquares float64
+		for i := 1; i < len(offsets); i++ {
+			gap := float64(offsets[i] - offsets[i-1])
+			sum += gap
+			sumSquares += gap * gap
+		}
+		n := float64(len(offsets) - 1)
+		mean := sum / n
+		if cv := math.Sqrt(sumSquares/n-mean*mean) / mean; cv < 0.6 || cv > 1.4 {
+			t.Errorf("wanted gaps with a coefficient of variation around 1 got %f", cv)
+		}
+	})
+
+	t.Run("bursty", func(t *testing.T) {
+		got, offsets := run(t, "bursty:100ms,100ms", 100, time.Second)
+		if got.Responses[200] != 100 {
+			t.Fatalf("wanted 100 responses got %v, errors %v", got.Responses, got.Errors)
+		}
+		for _, o := range offsets {
+			if o%(200*time.Millisecond) > 120*time.Millisecond {
+				t.Errorf("wanted requests in bursts only got one %s from the start", o)
+			}
+		}
+	})
+
+	t.Run("csv rate curve", func(t *testing.T) {
+		got, offsets := run(t, "csv:"+curve, 0, 0)
+		if got.Responses[200] != 200 {
+			t.Fatalf("wanted 200 responses from the curve got %v, errors %v", got.Responses, got.Errors)
+		}
+		firstHalf := sort.Search(len(offsets), func(i int) bool { return offsets[i] >= 500*time.Millisecond })
+		if firstHalf < 40 || firstHalf > 60 {
+			t.Errorf("wanted about 50 requests at 100 rps in the first 500ms got %d", firstHalf)
+		}
+	})
+
+	for _, tt := range []struct {
+		arrival  string
+		reqs     int64
+		duration time.Duration
+		err      string
+	}{
+		{arrival: "poisson", reqs: 10, err: "config: arrival process needs both requests and duration to schedule requests"},
+		{arrival: "bursty:1s", reqs: 10, duration: time.Second, err: "config: arrival: bursty needs on and off durations i.e. bursty:1s,4s, got bursty:1s"},
+		{arrival: "csv:" + curve, reqs: 10, duration: time.Second, err: "config: can't set requests with a rate curve, the curve sets the requests sent"},
+		{arrival: "gamma", reqs: 10, duration: time.Second, err: "config: arrival: unknown process gamma, expected one of uniform, poisson, bursty:<on>,<off>, csv:<path>"},
+	} {
+		c := &config.Config{ReqURI: server.URL, ReqTarget: tt.reqs, Duration: tt.duration, Conns: 1, Arrival: tt.arrival}
+		if err := parseAndValidate(c); err == nil || err.Error() != tt.err {
+			t.Errorf("parseAndValidate() error = %v, wanted %s", err, tt.err)
+		}
+	}
+
+	// only parsing sets the requests and duration from the curve
+	c := &config.Config{ReqURI: server.URL, Conns: 1, Arrival: "csv:" + curve}
+	_ = c.Validate()
+	if c.ReqTarget != 0 || c.Duration != 0 || c.ArrivalProcess != nil {
+		t.Errorf("wanted Validate() to leave the config as is got %d requests over %s", c.ReqTarget, c.Duration)
+	}
+	if err := c.Parse(); err != nil || c.ReqTarget == 0 || c.Duration == 0 {
+		t.Errorf("wanted Parse() to set the requests and duration from the curve got %d requests over %s, error %v", c.ReqTarget, c.Duration, err)
+	}
+}
+
+func TestPayLoader_RunWarmup(t *testing.T) {
+	var reqs, conns atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs.Add(1)
+	}))
+	// the measured run reuses the warm-up's connections rather than opening its own
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	newConfig := func() *config.Config {
+		return testConfig(&config.Config{
+			ReqURI:    server.URL,
+			ReqTarget: 50,
+			Conns:     2,
+		})
+	}
+
+	t.Run("warm-up requests", func(t *testing.T) {
+		reqs.Store(0)
+		conns.Store(0)
+		c := newConfig()
+		c.WarmupRequests = 30
+		got, err := NewPayLoader(c).Run()
+		if err != nil {
+			t.Fatalf("Run() error = %v, wanted no error", err)
+		}
+		if reqs.Load() != 80 {
+			t.Errorf("wanted server to receive 80 requests got %d", reqs.Load())
+		}
+		if got.CompletedReqs != 50 || got.Responses[200] != 50 {
+			t.Errorf("wanted 50 measured requests got %d, responses %v", got.CompletedReqs, got.Responses)
+		}
+		if got.Warmup == nil || got.Warmup.CompletedReqs != 30 || got.Warmup.RPS == 0 {
+			t.Errorf("wanted a warm-up of 30 requests got %+v", got.Warmup)
+		}
+		if conns.Load() != 2 {
+			t.Errorf("wanted the warm-up and measured run to share 2 connections got %d", conns.Load())
+		}
+	})
+
+	t.Run("warm-up duration", func(t *testing.T) {
+		reqs.Store(0)
+		conns.Store(0)
+		c := newConfig()
+		c.Warmup = 200 * time.Millisecond
+		got, err := NewPayLoader(c).Run()
+		if err != nil {
+			t.Fatalf("Run() error = %v, wanted no error", err)
+		}
+		if got.CompletedReqs != 50 {
+			t.Errorf("wanted 50 measured requests got %d", got.CompletedReqs)
+		}
+		if got.Warmup == nil || got.Warmup.Total < 200*time.Millisecond || reqs.Load() != 50+got.Warmup.CompletedReqs {
+			t.Errorf("wanted a 200ms warm-up excluded from results got %+v, server received %d", got.Warmup, reqs.Load())
+		}
+		if conns.Load() != 2 {
+			t.Errorf("wanted the warm-up and measured run to share 2 connections got %d", conns.Load())
+		}
+	})
+
+	t.Run("control API during warm-up", func(t *testing.T) {
+		c := newConfig()
+		c.Warmup = time.Second
+		c.ControlAddr = freeAddr(t)
+		start := time.Now()
+		runControlled(t, c, func(client *control.Client) {
+			if answered := time.Since(start); answered >= c.Warmup {
+				t.Errorf("wanted the control API answered during the warm-up got first answer after %s", answered)
+			}
+		})
+	})
+
+	t.Run("warm-up handshakes", func(t *testing.T) {
+		tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		t.Cleanup(tlsServer.Close)
+		c := newConfig()
+		c.ReqURI = tlsServer.URL
+		c.SkipVerify = true
+		c.Handshake = true
+		c.WarmupRequests = 30
+		got, err := NewPayLoader(c).Run()
+		if err != nil {
+			t.Fatalf("Run() error = %v, wanted no error", err)
+		}
+		var handshakes int64
+		for _, n := range got.TLSVersions {
+			handshakes += n
+		}
+		if handshakes != 50 {
+			t.Errorf("wanted only the 50 measured handshakes counted got %v", got.TLSVersions)
+		}
+	})
+
+	t.Run("no warm-up", func(t *testing.T) {
+		got, err := NewPayLoader(newConfig()).Run()
+		if err != nil {
+			t.Fatalf("Run() error = %v, wanted no error", err)
+		}
+		if got.Warmup != nil {
+			t.Errorf("wanted no warm-up got %+v", got.Warmup)
+		}
+	})
+
+	c := newConfig()
+	c.WarmupRequests = 1
+	if err := c.Validate(); err == nil || err.Error() != "config: warm-up requests can't be fewer than connections" {
+		t.Errorf("Validate() error = %v, wanted warm-up requests error", err)
+	}
+}
+
+func TestPayLoader_RunAbort(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		case "/slow":
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		name        string
+		path        string
+		errorRate   string
+		p99         time.Duration
+		afterErrors uint64
+		reqs        int64
+		reason      string
+	}{
+		{name: "after errors", path: "/fail", afterErrors: 20, reason: "errors reached limit of 20"},
+		{name: "error rate", path: "/fail", errorRate: "50%", reason: "over last 1s reached limit of 50.00%"},
+		{name: "p99 latency", path: "/slow", p99: 10 * time.Millisecond, reason: "over last 1s reached limit of 10ms"},
+		{name: "healthy", path: "/", errorRate: "50%", afterErrors: 1, reqs: 50},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig(&config.Config{
+				ReqURI:           server.URL + tt.path,
+				ReqTarget:        tt.reqs,
+				Conns:            2,
+				AbortErrorRate:   tt.errorRate,
+				AbortP99:         tt.p99,
+				AbortAfterErrors: tt.afterErrors,
+				AbortWindow:      time.Second,
+				Client:           "nethttp",
+			})
+			if tt.reqs == 0 {
+				c.Duration = 30 * time.Second
+			}
+			got, err := NewPayLoader(c).Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+			if tt.reason == "" {
+				if got.Aborted || got.CompletedReqs != tt.reqs {
+					t.Errorf("wanted %d requests without aborting got %d, aborted %s", tt.reqs, got.CompletedReqs, got.AbortReason)
+				}
+				return
+			}
+			if !got.Aborted || !strings.Contains(got.AbortReason, tt.reason) {
+				t.Errorf("wanted run aborted with %q got aborted %t %q", tt.reason, got.Aborted, got.AbortReason)
+			}
+			if got.Total > 10*time.Second {
+				t.Errorf("wanted the run stopped early got %s", got.Total)
+			}
+		})
+	}
+
+	c := &config.Config{ReqURI: server.URL, ReqTarget: 10, Conns: 1, AbortErrorRate: "120%", AbortWindow: time.Second}
+	if err := parseAndValidate(c); err == nil || err.Error() != "config: invalid abort error rate 120%, expected a percentage above 0 up to 100 i.e. 20%" {
+		t.Errorf("parseAndValidate() error = %v, wanted abort error rate error", err)
+	}
+}
+
+func TestPayLoader_RunInterrupted(t *testing.T) {
+	tests := []struct {
+		name        string
+		client      string
+		parallel    bool
+		delay       time.Duration
+		gracePeriod time.Duration
+		abandoned   bool
+	}{
+		{name: "drained", client: "nethttp", delay: 200 * time.Millisecond, gracePeriod: 5 * time.Second},
+		{name: "grace period passed", client: "nethttp", delay: 3 * time.Second, gracePeriod: 100 * time.Millisecond, abandoned: true},
+		{name: "grace period passed http2 parallel", client: "nethttp2", parallel: true, delay: 3 * time.Second, gracePeriod: 100 * time.Millisecond, abandoned: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// the server sees the connections of abandoned requests closed rather than left open after the run
+			var open atomic.Int64
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.delay):
+				case <-r.Context().Done():
+				}
+			}))
+			server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+				switch state {
+				case http.StateNew:
+					open.Add(1)
+				case http.StateClosed, http.StateHijacked:
+					open.Add(-1)
+				}
+			}
+			if tt.client == "nethttp2" {
+				server.EnableHTTP2 = true
+				server.StartTLS()
+			} else {
+				server.Start()
+			}
+			t.Cleanup(server.Close)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			time.AfterFunc(500*time.Millisecond, cancel)
+
+			c := testConfig(&config.Config{
+				Ctx:         ctx,
+				ReqURI:      server.URL,
+				Duration:    30 * time.Second,
+				Conns:       4,
+				GracePeriod: tt.gracePeriod,
+				Client:      tt.client,
+				Parallel:    tt.parallel,
+				SkipVerify:  true,
+			})
+			got, err := NewPayLoader(c).Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+			if !got.Interrupted {
+				t.Errorf("wanted results marked interrupted")
+			}
+			if got.Total > 10*time.Second {
+				t.Errorf("wanted the run stopped early got %s", got.Total)
+			}
+			if tt.abandoned {
+				if got.Abandoned == 0 || got.FailedReqs != 0 {
+					t.Errorf("wanted in-flight requests abandoned rather than failed got %d abandoned, %d failed", got.Abandoned, got.FailedReqs)
+				}
+				for start := time.Now(); open.Load() != 0 && time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+				}
+				if open.Load() != 0 {
+					t.Errorf("wanted the abandoned requests' connections closed got %d open", open.Load())
+				}
+				return
+			}
+			if got.Abandoned != 0 || got.FailedReqs != 0 || got.CompletedReqs == 0 {
+				t.Errorf("wanted in-flight requests drained without errors got %d completed, %d failed, %d abandoned",
+					got.CompletedReqs, got.FailedReqs, got.Abandoned)
+			}
+		})
+	}
+}
+
+// freeAddr returns a local address nothing is listening on
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// runControlled runs c calling drive once its control API is reachable, returning the results once both are done
+func runControlled(t *testing.T, c *config.Config, drive func(client *control.Client)) *GoPayloaderResults {
+	controlled := make(chan struct{})
+	go func() {
+		defer close(controlled)
+		client := control.NewClient(c.ControlAddr)
+
+		var err error
+		for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+			if _, err = client.Stats(); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			t.Errorf("control API not reachable; %v", err)
+			return
+		}
+		drive(client)
+	}()
+
+	got, err := NewPayLoader(c).Run()
+	<-controlled
+	if err != nil {
+		t.Fatalf("Run() error = %v, wanted no error", err)
+	}
+	return got
+}
+
+func TestPayLoader_RunControl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	addr := freeAddr(t)
+	c := testConfig(&config.Config{
+		ReqURI:      server.URL,
+		Duration:    5 * time.Second,
+		Conns:       2,
+		ControlAddr: addr,
+		Client:      "nethttp",
+	})
+
+	controlled := make(chan struct{})
+	go func() {
+		defer close(controlled)
+		client := control.NewClient(addr)
+
+		var err error
+		for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(20 * time.Millisecond) {
+			if _, err = client.Stats(); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			t.Errorf("control API not reachable; %v", err)
+			return
+		}
+
+		if _, err := client.Pause(); err != nil {
+			t.Errorf("Pause() error = %v", err)
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+		before, _ := client.Stats()
+		time.Sleep(300 * time.Millisecond)
+		after, _ := client.Stats()
+		if !after.Paused || after.Completed != before.Completed {
+			t.Errorf("wanted no requests while paused got %d then %d, paused %t", before.Completed, after.Completed, after.Paused)
+		}
+
+		if _, err := client.SetConns(0); err == nil || !strings.Contains(err.Error(), "n must be between 1 and 2") {
+			t.Errorf("SetConns(0) error = %v, wanted out of range error", err)
+		}
+		if _, err := client.SetConns(1); err != nil {
+			t.Errorf("SetConns() error = %v", err)
+		}
+		if _, err := client.SetRate(50); err != nil {
+			t.Errorf("SetRate() error = %v", err)
+		}
+		if _, err := client.Resume(); err != nil {
+			t.Errorf("Resume() error = %v", err)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+		before, _ = client.Stats()
+		time.Sleep(time.Second)
+		after, _ = client.Stats()
+		if sent := after.Completed - before.Completed; sent < 35 || sent > 65 {
+			t.Errorf("wanted about 50 requests a second at the set rate got %d", sent)
+		}
+		if after.Paused || after.Active != 1 || after.Workers != 2 || after.Rate != 50 {
+			t.Errorf("wanted running with 1 of 2 connections at 50 RPS got %+v", after)
+		}
+	}()
+
+	got, err := NewPayLoader(c).Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v, wanted no error", err)
+	}
+	<-controlled
+	if got.CompletedReqs == 0 || got.FailedReqs != 0 {
+		t.Errorf("wanted requests completed without errors got %d completed, %d failed", got.CompletedReqs, got.FailedReqs)
+	}
+
+	t.Run("request target", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(5 * time.Millisecond)
+		}))
+		t.Cleanup(slow.Close)
+
+		c := testConfig(&config.Config{
+			ReqURI:      slow.URL,
+			ReqTarget:   200,
+			Conns:       2,
+			ControlAddr: freeAddr(t),
+			Client:      "nethttp",
+		})
+		got := runControlled(t, c, func(client *control.Client) {
+			// idle connections' share of the requests would never be sent
+			if _, err := client.SetConns(1); err == nil || !strings.Contains(err.Error(), http_clients.ErrReqTargetConns.Error()) {
+				t.Errorf("SetConns() error = %v, wanted request target error", err)
+			}
+			if _, err := client.Pause(); err != nil {
+				t.Errorf("Pause() error = %v", err)
+			}
+			time.Sleep(300 * time.Millisecond)
+			if _, err := client.Resume(); err != nil {
+				t.Errorf("Resume() error = %v", err)
+			}
+		})
+		if got.CompletedReqs != 200 || got.FailedReqs != 0 {
+			t.Errorf("wanted all 200 requests completed got %d completed, %d failed", got.CompletedReqs, got.FailedReqs)
+		}
+	})
+
+	t.Run("request target over duration", func(t *testing.T) {
+		c := testConfig(&config.Config{
+			ReqURI:      server.URL,
+			ReqTarget:   20,
+			Duration:    time.Second,
+			Conns:       2,
+			ControlAddr: freeAddr(t),
+			Client:      "nethttp",
+		})
+		got := runControlled(t, c, func(client *control.Client) {
+			if _, err := client.SetConns(1); err == nil || !strings.Contains(err.Error(), http_clients.ErrReqTargetConns.Error()) {
+				t.Errorf("SetConns() error = %v, wanted request target error", err)
+			}
+			time.Sleep(200 * time.Millisecond)
+			if _, err := client.Pause(); err != nil {
+				t.Errorf("Pause() error = %v", err)
+			}
+			time.Sleep(400 * time.Millisecond)
+			if _, err := client.Resume(); err != nil {
+				t.Errorf("Resume() error = %v", err)
+			}
+		})
+		if got.CompletedReqs != 20 || got.FailedReqs != 0 {
+			t.Errorf("wanted all 20 requests completed got %d completed, %d failed", got.CompletedReqs, got.FailedReqs)
+		}
+		// the paused time shifts the schedule so the requests missed while paused aren't sent in a burst on resume
+		if got.Schedule.MaxLag > 100*time.Millisecond || got.Total < 1300*time.Millisecond {
+			t.Errorf("wanted the schedule shifted by the pause got max lag %s over %s", got.Schedule.MaxLag, got.Total)
+		}
+	})
+
+	c = &config.Config{ReqURI: server.URL, ReqTarget: 10, Conns: 1, ControlAddr: "9999"}
+	if err := c.Validate(); err == nil || err.Error() != "config: invalid control address 9999, expected host:port i.e. localhost:9999" {
+		t.Errorf("Validate() error = %v, wanted control address error", err)
+	}
+}
+
+func TestPayLoader_RunRetries(t *testing.T) {
+	var flaky atomic.Int64
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/flaky":
+			// every other attempt fails so each request succeeds on its retry
+			if flaky.Add(1)%2 == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/reset":
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		}
+	})
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	const reqs = 20
+	tests := []struct {
+		name         string
+		path         string
+		firstAttempt int64
+		afterRetry   int64
+		exhausted    int64
+		retries      int64
+		failed       int64
+		attempts     int
+	}{
+		{name: "first attempt", path: "/", firstAttempt: reqs, attempts: 1},
+		{name: "after retry", path: "/flaky", afterRetry: reqs, retries: reqs, attempts: 2},
+		{name: "exhausted on status", path: "/unavailable", exhausted: reqs, retries: 2 * reqs, attempts: 3},
+		{name: "exhausted on reset", path: "/reset", exhausted: reqs, retries: 2 * reqs, failed: reqs, attempts: 3},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig(&config.Config{
+				ReqURI:          server.URL + tt.path,
+				ReqTarget:       reqs,
+				Conns:           1,
+				RetryAttempts:   3,
+				RetryOn:         []string{"503", "reset"},
+				RetryBackoff:    time.Millisecond,
+				RetryMaxBackoff: 10 * time.Millisecond,
+				Client:          "nethttp",
+			})
+			got, err := NewPayLoader(c).Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+
+			r := got.Retries
+			if r.FirstAttempt != tt.firstAttempt || r.AfterRetry != tt.afterRetry || r.Exhausted != tt.exhausted || r.Retries != tt.retries {
+				t.Errorf("wanted %d first attempt, %d after retry, %d exhausted, %d retries got %d, %d, %d, %d", tt.firstAttempt,
+					tt.afterRetry, tt.exhausted, tt.retries, r.FirstAttempt, r.AfterRetry, r.Exhausted, r.Retries)
+			}
+			if got.FailedReqs != tt.failed || got.CompletedReqs+got.FailedReqs != reqs {
+				t.Errorf("wanted %d requests with %d failed got %d completed, %d failed", reqs, tt.failed, got.CompletedReqs, got.FailedReqs)
+			}
+			if len(r.Attempts) != tt.attempts || r.Attempts[0].Requests != reqs {
+				t.Errorf("wanted latency of %d attempts with %d first attempts got %+v", tt.attempts, reqs, r.Attempts)
+			}
+			if tt.retries > 0 && (r.AverageLatency == 0 || r.MaxLatency < r.AverageLatency) {
+				t.Errorf("wanted end-to-end latency of retried requests got avg %s max %s", r.AverageLatency, r.MaxLatency)
+			}
+		})
+	}
+
+	c := &config.Config{ReqURI: server.URL, ReqTarget: 10, Conns: 1, RetryAttempts: 2, RetryOn: []string{"teapot"}, RetryMaxBackoff: time.Second}
+	if err := parseAndValidate(c); err == nil || err.Error() != "config: retry: invalid condition teapot, expected a response code, 5xx, reset or timeout" {
+		t.Errorf("parseAndValidate() error = %v, wanted retry condition error", err)
+	}
+	c = &config.Config{ReqURI: server.URL, ReqTarget: 10, Conns: 1, RetryAttempts: 1, RetryOn: []string{"503"}, RetryMaxBackoff: time.Second}
+	if err := parseAndValidate(c); err == nil || err.Error() != "config: retry attempts include the first attempt, at least 2 are needed to retry" {
+		t.Errorf("parseAndValidate() error = %v, wanted retry attempts error", err)
+	}
+}
+
+func TestPayLoader_RunHonorRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		honor    bool
+		attempts uint
+		duration time.Duration
+	}{
+		{name: "honored", honor: true},
+		{name: "honored on retry", honor: true, attempts: 2},
+		{name: "honored over duration", honor: true, duration: 500 * time.Millisecond},
+		{name: "ignored"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var reqs atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if reqs.Add(1) == 1 {
+					w.Header().Set("Retry-After", "1")
+					w.WriteHeader(http.StatusTooManyRequests)
+				}
+			}))
+			t.Cleanup(server.Close)
+
+			c := testConfig(&config.Config{
+				ReqURI:          server.URL,
+				ReqTarget:       5,
+				Duration:        tt.duration,
+				Conns:           1,
+				HonorRetryAfter: tt.honor,
+				Client:          "nethttp",
+			})
+			if tt.attempts > 0 {
+				c.RetryAttempts = tt.attempts
+				c.RetryOn = []string{"429"}
+				c.RetryMaxBackoff = time.Millisecond
+			}
+			got, err := NewPayLoader(c).Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+
+			if !tt.honor {
+				if got.Throttle.Responses != 0 || got.Total >= time.Second {
+					t.Errorf("wanted Retry-After ignored got %d throttled responses over %s", got.Throttle.Responses, got.Total)
+				}
+				return
+			}
+			if got.Throttle.Responses != 1 || got.Throttle.Time < 900*time.Millisecond || got.Total < 900*time.Millisecond {
+				t.Errorf("wanted 1 throttled response held back for 1s got %d for %s over %s", got.Throttle.Responses,
+					got.Throttle.Time, got.Total)
+			}
+			// the throttled time shifts the schedule so the requests held back aren't sent in a burst once it expires
+			if tt.duration != 0 && (got.Schedule.MaxLag > 100*time.Millisecond || got.Total < 1400*time.Millisecond) {
+				t.Errorf("wanted the schedule shifted by the throttled time got max lag %s over %s", got.Schedule.MaxLag, got.Total)
+			}
+			wantOK := int64(4)
+			if tt.attempts > 0 {
+				wantOK = 5
+			}
+			if got.Responses[http.StatusOK] != wantOK {
+				t.Errorf("wanted %d 200 responses got %v", wantOK, got.Responses)
+			}
+			if want := float64(wantOK) / got.Total.Seconds(); math.Abs(got.Throttle.EffectiveRPS-want) > 0.01 {
+				t.Errorf("wanted effective RPS %.2f got %.2f", want, got.Throttle.EffectiveRPS)
+			}
+		})
+	}
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if d, ok := http_clients.ParseRetryAfter("Thu, 01 Jan 2026 00:00:30 GMT", now); !ok || d != 30*time.Second {
+		t.Errorf("ParseRetryAfter() = %s %t, wanted 30s from HTTP date", d, ok)
+	}
+}
+
+func TestPayLoader_RunErrorCategories(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(time.Second)
+		case "/close":
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		}
+	})
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.Config.ErrorLog = log.New(io.Discard, "", 0)
+	tlsServer.StartTLS()
+	t.Cleanup(tlsServer.Close)
+
+	tests := []struct {
+		name     string
+		client   string
+		uri      string
+		category string
+	}{
+		{name: "refused", client: "nethttp", uri: "http://" + closedAddr, category: http_clients.ErrCategoryRefused},
+		{name: "refused fasthttp", client: "fasthttp", uri: "http://" + closedAddr, category: http_clients.ErrCategoryRefused},
+		{name: "read timeout", client: "nethttp", uri: server.URL + "/slow", category: http_clients.ErrCategoryReadTimeout},
+		// fasthttp doesn't report whether a timeout was reading or writing
+		{name: "timeout fasthttp", client: "fasthttp", uri: server.URL + "/slow", category: http_clients.ErrCategoryTimeout},
+		{name: "EOF", client: "nethttp", uri: server.URL + "/close", category: http_clients.ErrCategoryEOF},
+		{name: "EOF fasthttp", client: "fasthttp", uri: server.URL + "/close", category: http_clients.ErrCategoryEOF},
+		{name: "TLS handshake", client: "nethttp", uri: tlsServer.URL, category: http_clients.ErrCategoryTLSHandshake},
+		{name: "DNS", client: "nethttp", uri: "http://gopayloader.invalid:80", category: http_clients.ErrCategoryDNS},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig(&config.Config{
+				ReqURI:       tt.uri,
+				ReqTarget:    10,
+				Conns:        2,
+				ReadTimeout:  100 * time.Millisecond,
+				WriteTimeout: 100 * time.Millisecond,
+				Client:       tt.client,
+			})
+			got, err := NewPayLoader(c).Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+			if len(got.Errors) != 1 || got.Errors[tt.category] != 10 || got.ErrorExamples[tt.category] == "" {
+				t.Errorf("wanted 10 %q errors with an example got %v, %v", tt.category, got.Errors, got.ErrorExamples)
+			}
+
+			failed := got.FailedLatency
+			if failed.Min == 0 || failed.Min > failed.Average || failed.Average > failed.Max || failed.P99 == 0 {
+				t.Errorf("wanted time to failure of failed requests got %+v", failed)
+			}
+			l := got.ErrorLatency[tt.category]
+			if l.Average != failed.Average || l.Max != failed.Max {
+				t.Errorf("wanted %q time to failure to match all failed requests got %+v, %+v", tt.category, l, failed)
+			}
+			if strings.Contains(tt.category, "timeout") && l.Average < 90*time.Millisecond {
+				t.Errorf("wanted timeouts to take the 100ms timeout to fail got %s", l.Average)
+			}
+		})
+	}
+}
+
+func TestPayLoader_RunByteAccounting(t *testing.T) {
+	reqBody := strings.Repeat("b", 500)
+
+	tests := []struct {
+		name    string
+		client  string
+		headers []string
+	}{
+		{name: "nethttp chunked", client: "nethttp"},
+		{name: "fasthttp chunked", client: "fasthttp"},
+		{name: "nethttp gzip", client: "nethttp", headers: []string{"Accept-Encoding: gzip"}},
+		{name: "fasthttp gzip", client: "fasthttp", headers: []string{"Accept-Encoding: gzip"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var reqs, bodyBytes, badBodies atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if body, _ := io.ReadAll(r.Body); string(body) != reqBody {
+					badBodies.Add(1)
+				}
+				// each response is bigger than the last and flushed before the end so it's chunked
+				body := strings.Repeat("a", int(reqs.Add(1))*1000)
+				if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+					w.Header().Set("Content-Encoding", "gzip")
+					gz := gzip.NewWriter(w)
+					gz.Write([]byte(body))
+					gz.Close()
+					return
+				}
+				bodyBytes.Add(int64(len(body)))
+				w.Write([]byte(body[:10]))
+				w.(http.Flusher).Flush()
+				w.Write([]byte(body[10:]))
+			}))
+			t.Cleanup(server.Close)
+
+			got, err := NewPayLoader(testConfig(&config.Config{
+				ReqURI:    server.URL,
+				ReqTarget: 10,
+				Conns:     1,
+				Method:    "POST",
+				Body:      reqBody,
+				Headers:   tt.headers,
+				Client:    tt.client,
+			})).Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+			if got.CompletedReqs != 10 || badBodies.Load() != 0 {
+				t.Fatalf("wanted 10 completed requests with bodies got %d, %d without", got.CompletedReqs, badBodies.Load())
+			}
+
+			if min := 10 * int64(len(reqBody)); got.ReqByteSize.Total < min || got.ReqByteSize.Total > min+10*500 {
+				t.Errorf("wanted request bytes of 10 bodies plus headers got %d", got.ReqByteSize.Total)
+			}
+			if got.ReqByteSize.Average != got.ReqByteSize.Total/10 {
+				t.Errorf("wanted average request size %d got %d", got.ReqByteSize.Total/10, got.ReqByteSize.Average)
+			}
+			// nethttp sizes are estimated as HTTP/1.1, fasthttp's are the bytes it wrote and read
+			if estimated := tt.client == "nethttp"; got.ReqByteSize.Estimated != estimated || got.RespByteSize.Estimated != estimated {
+				t.Errorf("wanted sizes estimated %t got %t, %t", estimated, got.ReqByteSize.Estimated, got.RespByteSize.Estimated)
+			}
+
+			resp := got.RespByteSize
+			if tt.headers != nil {
+				// 55KB of a's compresses to well under 1KB per response
+				if resp.Total > 10*1000 || resp.Max > 1000 {
+					t.Errorf("wanted compressed response bytes counted got %d total, %d max", resp.Total, resp.Max)
+				}
+				return
+			}
+			if want := bodyBytes.Load(); resp.Total < want || resp.Total > want+10*500 {
+				t.Errorf("wanted response bytes of %d body bytes plus headers got %d", want, resp.Total)
+			}
+			// percentiles are within the histogram's ~3% precision
+			if resp.Max < 10000 || resp.P50 < 4800 || resp.P50 > 6000 || resp.P99 < resp.P90 || resp.P90 < resp.P50 {
+				t.Errorf("wanted response size percentiles from 1KB to 10KB got p50 %d, p90 %d, p99 %d, max %d",
+					resp.P50, resp.P90, resp.P99, resp.Max)
+			}
+		})
+	}
+}
+
+func TestPayLoader_RunByteAccountingFailed(t *testing.T) {
+	reqBody := strings.Repeat("b", 500)
+
+	tests := []struct {
+		name   string
+		client string
+	}{
+		{name: "nethttp", client: "nethttp"},
+		{name: "fasthttp", client: "fasthttp"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// requests are read then the connection is closed without a response
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.ReadAll(r.Body)
+				if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+					conn.Close()
+				}
+			}))
+			t.Cleanup(server.Close)
+
+			got, err := NewPayLoader(testConfig(&config.Config{
+				ReqURI:    server.URL,
+				ReqTarget: 10,
+				Conns:     1,
+				Method:    "POST",
+				Body:      reqBody,
+				Client:    tt.client,
+			})).Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+			if got.FailedReqs != 10 {
+				t.Fatalf("wanted 10 failed requests got %d", got.FailedReqs)
+			}
+			if min := 10 * int64(len(reqBody)); got.ReqByteSize.Total < min || got.ReqByteSize.Average != got.ReqByteSize.Total/10 {
+				t.Errorf("wanted the bytes of 10 failed requests counted got %d, average %d", got.ReqByteSize.Total,
+					got.ReqByteSize.Average)
+			}
+		})
+	}
+
+	// requests failing to connect were never sent
+	got, err := NewPayLoader(testConfig(&config.Config{
+		ReqURI:    "http://" + freeAddr(t),
+		ReqTarget: 5,
+		Conns:     1,
+		Client:    "nethttp",
+	})).Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v, wanted no error", err)
+	}
+	if got.FailedReqs != 5 || got.ReqByteSize.Total != 0 {
+		t.Errorf("wanted 5 refused requests without bytes sent got %d failed, %d bytes", got.FailedReqs, got.ReqByteSize.Total)
+	}
+}
+
+func TestPayLoader_RunReleasesFailedResponses(t *testing.T) {
+	client := &fakeClient{err: errors.New("connection reset")}
+	got := runWorkers(t, NewPayLoader(&config.Config{ReqURI: "http://localhost:8080/path", Method: "GET"}), 2, 10, client)
+	if got.FailedReqs != 10 {
+		t.Errorf("wanted 10 failed requests got %d", got.FailedReqs)
+	}
+	if acquired, released := client.acquired.Load(), client.released.Load(); acquired != released {
+		t.Errorf("wanted every response released got %d of %d", released, acquired)
+	}
+}
+
+// BenchmarkStatsRecording records the stats of requests sent in parallel by every worker to a fake client, into the
+// shards and the responses map each worker's requests share, merging them as results are computed. The channel
+// baseline records a latency and response code per request as stats were recorded before the shards, sending each
+// latency over a channel to one goroutine and counting response codes under a mutex, to compare with sharded which
+// records the same into the shards.
+func BenchmarkStatsRecording(b *testing.B) {
+	b.Run("channel baseline", func(b *testing.B) {
+		recv := make(chan time.Duration, 1000000)
+		done := make(chan struct{})
+		go func() {
+			h := histogram.New()
+			for t := range recv {
+				h.Record(int64(t))
+			}
+			close(done)
+		}()
+
+		lock := &sync.Mutex{}
+		responses := &sync.Map{}
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for t := time.Duration(0); pb.Next(); t++ {
+				recv <- t
+				lock.Lock()
+				val, ok := responses.Load(worker.ResponseCode(200))
+				if !ok {
+					val = int64(0)
+				}
+				responses.Store(worker.ResponseCode(200), val.(int64)+1)
+				lock.Unlock()
+			}
+		})
+		close(recv)
+		<-done
+	})
+
+	b.Run("sharded", func(b *testing.B) {
+		shards := newStatsShards(runtime.GOMAXPROCS(0))
+		var next atomic.Int64
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			shard := shards[int(next.Add(1))%len(shards)]
+			responses := &sync.Map{}
+			for t := int64(0); pb.Next(); t++ {
+				shard.latencies.Record(t)
+				val, ok := responses.Load(worker.ResponseCode(200))
+				if !ok {
+					val, _ = responses.LoadOrStore(worker.ResponseCode(200), &atomic.Int64{})
+				}
+				val.(*atomic.Int64).Add(1)
+			}
+		})
+		h := histogram.New()
+		for _, s := range shards {
+			h.MergeAtomic(s.latencies)
+		}
+	})
+
+	b.Run("workers", func(b *testing.B) {
+		p := NewPayLoader(&config.Config{ReqURI: "http://localhost:8080/path", Method: "GET", Parallel: true, MaxInflight: 64})
+		b.ReportAllocs()
+		got := runWorkers(b, p, runtime.GOMAXPROCS(0), int64(b.N), &fakeClient{})
+		if got.CompletedReqs != int64(b.N) || got.Responses[200] != int64(b.N) {
+			b.Fatalf("wanted %d requests completed with 200 got %d completed, %d with 200", b.N, got.CompletedReqs, got.Responses[200])
+		}
+	})
+}
+
+// BenchmarkRequests sends requests with headers and a body through a worker to a fake client, acquiring requests the
+// worker reuses and releasing their responses
+func BenchmarkRequests(b *testing.B) {
+	dir := b.TempDir()
+	bodyFile := filepath.Join(dir, "body.json")
+	if err := os.WriteFile(bodyFile, []byte(`{"key": "value"}`), 0600); err != nil {
+		b.Fatal(err)
+	}
+	streamedFile := filepath.Join(dir, "upload.bin")
+	if err := os.WriteFile(streamedFile, bytes.Repeat([]byte("0"), 2<<20), 0600); err != nil {
+		b.Fatal(err)
+	}
+	headers := []string{"content-type: application/json", "x-request-source: benchmark"}
+
+	tests := []struct {
+		name     string
+		body     string
+		bodyFile string
+	}{
+		{name: "body", body: `{"key": "value"}`},
+		{name: "body file", bodyFile: bodyFile},
+		{name: "streamed body file", bodyFile: streamedFile},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			p := NewPayLoader(&config.Config{
+				ReqURI:   "http://localhost:8080/path",
+				Method:   "POST",
+				Headers:  headers,
+				Body:     tt.body,
+				BodyFile: tt.bodyFile,
+			})
+			b.ReportAllocs()
+			got := runWorkers(b, p, 1, int64(b.N), &fakeClient{})
+			if got.CompletedReqs != int64(b.N) {
+				b.Fatalf("wanted %d requests completed got %d", b.N, got.CompletedReqs)
+			}
+		})
+	}
+}
+
+// runWorkers runs workers sending reqs requests between them over client, recording into p's shards as handleReqs
+// does, and computes the results once they complete. Benchmarks are timed from when the workers start.
+func runWorkers(tb testing.TB, p *PayLoader, workers int, reqs int64, client http_clients.GoPayLoaderClient) *GoPayloaderResults {
+	workers = int(min(int64(workers), reqs))
+	p.shards = newStatsShards(workers)
+	var bodyFile *http_clients.BodyFile
+	if p.config.BodyFile != "" {
+		var err error
+		if bodyFile, err = http_clients.OpenBodyFile(p.config.BodyFile); err != nil {
+			tb.Fatal(err)
+		}
+		defer bodyFile.Close()
+	}
+	startTrigger := &sync.WaitGroup{}
+	startTrigger.Add(1)
+	workersComplete := &sync.WaitGroup{}
+	workersComplete.Add(workers)
+
+	ws := make([]worker.Worker, workers)
+	for i := range ws {
+		shard := p.shards[i%len(p.shards)]
+		c := &http_clients.Config{
+			ReqURI:       p.config.ReqURI,
+			Method:       p.config.Method,
+			Headers:      p.config.Headers,
+			Body:         p.config.Body,
+			BodyFile:     bodyFile,
+			ReqTarget:    reqs / int64(workers),
+			Ctx:          context.Background(),
+			StartTrigger: startTrigger,
+			Parallel:     p.config.Parallel,
+			MaxInflight:  int(p.config.MaxInflight),
+			ReqStats:     shard.latencies,
+			ErrStats:     shard.failed,
+			RespSizes:    shard.respSizes,
+			HTTPClient:   client,
+		}
+		if int64(i) < reqs%int64(workers) {
+			c.ReqTarget++
+		}
+		w, err := worker.NewWorker(c)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		ws[i] = w
+		go w.Run(workersComplete)
+	}
+
+	ctx, stopStatsCalc := context.WithCancel(context.Background())
+	results := &GoPayloaderResults{}
+	statsDone := make(chan struct{})
+	go p.calcReqStats(ctx, results, statsDone, ws)
+
+	if b, ok := tb.(*testing.B); ok {
+		b.ResetTimer()
+	}
+	p.startTimer()
+	p.startWorkers(startTrigger)
+	workersComplete.Wait()
+	p.stopTimer()
+	stopStatsCalc()
+	<-statsDone
+
+	results, err := p.ComputeResults(ws, results)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return results
+}
+
+// fakeClient answers every request with a 200 without sending it, or fails them with err, so benchmarks measure the
+// workers alone. Responses are pooled as the real clients' are.
+type fakeClient struct {
+	err      error
+	resps    sync.Pool
+	acquired atomic.Int64
+	released atomic.Int64
+}
+
+type fakeReq struct {
+	headers map[string]string
+	size    int64
+}
+
+type fakeResp struct {
+	client *fakeClient
+}
+
+func (c *fakeClient) Do(req http_clients.Request, resp http_clients.Response) error {
+	return c.err
+}
+
+func (c *fakeClient) NewReq(method, url string) (http_clients.Request, error) {
+	return &fakeReq{headers: make(map[string]string)}, nil
+}
+
+func (c *fakeClient) NewResponse() http_clients.Response {
+	c.acquired.Add(1)
+	if resp := c.resps.Get(); resp != nil {
+		return resp.(*fakeResp)
+	}
+	return &fakeResp{client: c}
+}
+
+func (c *fakeClient) CloseConns() {}
+
+func (c *fakeClient) HTTP2() bool {
+	return false
+}
+
+func (r *fakeReq) SetHeader(key, val string) {
+	r.headers[key] = val
+}
+
+func (r *fakeReq) Header(key string) string {
+	return r.headers[key]
+}
+
+func (r *fakeReq) SetBody(body []byte) {
+	r.size = int64(len(body))
+}
+
+func (r *fakeReq) SetBodyStream(open func() io.Reader, size int64) {
+	r.size = size
+}
+
+func (r *fakeReq) Size() int64 {
+	return r.size
+}
+
+func (r *fakeResp) StatusCode() int {
+	return 200
+}
+
+func (r *fakeResp) Header(key string) string {
+	return ""
+}
+
+func (r *fakeResp) Size() int64 {
+	return 0
+}
+
+func (r *fakeResp) Close() {}
+
+func (r *fakeResp) RemoteIP() string {
+	return ""
+}
+
+func (r *fakeResp) Release() {
+	r.client.released.Add(1)
+	r.client.resps.Put(r)
+}
+
+func TestPayLoader_RunStreamedBodyFile(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789abcdef"), 3<<20/16)
+	bodyFile := filepath.Join(t.TempDir(), "upload.bin")
+	if err := os.WriteFile(bodyFile, body, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, client := range []string{"nethttp", "fasthttp"} {
+		client := client
+		t.Run(client, func(t *testing.T) {
+			var reqs, badBodies, maxOpen atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// the body file is opened once and shared by the workers streaming it
+				if open := int64(openFiles(t, bodyFile)); open > maxOpen.Load() {
+					maxOpen.Store(open)
+				}
+				got, _ := io.ReadAll(r.Body)
+				if !bytes.Equal(got, body) || r.ContentLength != int64(len(body)) {
+					badBodies.Add(1)
+				}
+				// the retry resends the body from the start
+				if reqs.Add(1) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			t.Cleanup(server.Close)
+
+			got, err := NewPayLoader(testConfig(&config.Config{
+				ReqURI:          server.URL,
+				ReqTarget:       4,
+				Conns:           2,
+				ReadTimeout:     10 * time.Second,
+				WriteTimeout:    10 * time.Second,
+				Method:          "POST",
+				BodyFile:        bodyFile,
+				Client:          client,
+				RetryAttempts:   2,
+				RetryOn:         []string{"503"},
+				RetryBackoff:    time.Millisecond,
+				RetryMaxBackoff: time.Millisecond,
+			})).Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v, wanted no error", err)
+			}
+
+			if got.Responses[http.StatusOK] != 4 || reqs.Load() != 5 || badBodies.Load() != 0 {
+				t.Fatalf("wanted 4 responses to 5 requests with the full body got %v to %d, %d bodies differed, errors %v",
+					got.Responses, reqs.Load(), badBodies.Load(), got.Errors)
+			}
+			if got.ReqByteSize.Total < 5*int64(len(body)) {
+				t.Errorf("wanted 5 bodies of %d bytes counted got %d bytes", len(body), got.ReqByteSize.Total)
+			}
+			if maxOpen.Load() != 1 || openFiles(t, bodyFile) != 0 {
+				t.Errorf("wanted the body file opened once and closed after got %d open while running, %d after",
+					maxOpen.Load(), openFiles(t, bodyFile))
+			}
+		})
+	}
+}
+
+// openFiles counts the descriptors the process has open for path
+func openFiles(t *testing.T, path string) int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("open files can't be listed")
+	}
+	var open int
+	for _, fd := range fds {
+		if target, _ := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); target == path {
+			open++
+		}
+	}
+	return open
+}
+
+func TestPayLoader_RunPipelineDepths(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// slow enough for requests to queue in the pipeline
+		time.Sleep(2 * time.Millisecond)
+	}))
+	t.Cleanup(server.Close)
+
+	got, err := NewPayLoader(testConfig(&config.Config{
+		ReqURI:    server.URL,
+		ReqTarget: 100,
+		Conns:     2,
+		Pipeline:  4,
+	})).Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v, wanted no error", err)
+	}
+	if got.Responses[200] != 100 {
+		t.Fatalf("wanted 100 responses got %v, errors %v", got.Responses, got.Errors)
+	}
+
+	var requests int64
+	var deepest int
+	for _, d := range got.PipelineLatency {
+		if d.Depth < 1 || d.Depth > 4 {
+			t.Errorf("wanted depths between 1 and 4 got %d", d.Depth)
+		}
+		if d.Requests == 0 || d.Average <= 0 || d.Max < d.Average {
+			t.Errorf("wanted latency recorded for depth %d got %+v", d.Depth, d)
+		}
+		requests += d.Requests
+		deepest = max(deepest, d.Depth)
+	}
+	if requests != 100 {
+		t.Errorf("wanted all 100 requests recorded by depth got %d", requests)
+	}
+	if deepest < 2 {
+		t.Errorf("wanted requests recorded at depths above 1 got %+v", got.PipelineLatency)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"fmt"
 	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
 	"github.com/domsolutions/gopayloader/pkgs/http-clients/fasthttp"
+	"github.com/domsolutions/gopayloader/pkgs/http-clients/handshake"
 	"github.com/domsolutions/gopayloader/pkgs/http-clients/nethttp"
-	"os"
+	"math/rand/v2"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -22,66 +24,166 @@ type TotalRequestsComplete int64
 type ResponseCode int
 
 type Stats struct {
-	CompletedReqs int64
-	FailedReqs    int64
-	Responses     *sync.Map
-	Errors        *sync.Map
+	MTLSIdentity    string
+	CompletedReqs   int64
+	FailedReqs      int64
+	ResumedSessions int64
+	RedirectChains  int64
+	InflightBlocked int64
+	InflightWait    time.Duration
+	ScheduledReqs   int64
+	ScheduleLag     time.Duration
+	MaxScheduleLag  time.Duration
+	Inflight        int64
+	// FirstAttemptReqs succeeded on the first attempt, RetriedReqs succeeded after retrying and RetriesExhausted ran
+	// out of retries, RetriedLatency is the end-to-end latency of retried requests
+	FirstAttemptReqs  int64
+	RetriedReqs       int64
+	RetriesExhausted  int64
+	Retries           int64
+	RetriedLatency    time.Duration
+	MaxRetriedLatency time.Duration
+	RetryAttempts     *sync.Map
+	Throttled         int64
+	ThrottledTime     time.Duration
+	SentBytes         int64
+	ReceivedBytes     int64
+	SentReqs          int64
+	Abandoned         int64
+	Responses         *sync.Map
+	// Errors are counted by category, ErrorExamples holds an error of each category
+	Errors         *sync.Map
+	ErrorExamples  *sync.Map
+	ErrorLatencies *sync.Map
+	TLSVersions    *sync.Map
+	CipherSuites   *sync.Map
+	ALPNs          *sync.Map
+	PipelineDepths *sync.Map
+	IPs            *sync.Map
+	RedirectHops   *sync.Map
+	QUIC           QUICHandshakes
+}
+
+// QUICHandshakes are the QUIC handshakes completed by a worker, and the path changes of its connections
+type QUICHandshakes struct {
+	Count       int64
+	Used0RTT    int64
+	Total       time.Duration
+	Max         time.Duration
+	PathChanges int64
+}
+
+// ErrorLatency is how long requests failing with an error category took to fail
+type ErrorLatency struct {
+	Requests int64
+	Total    time.Duration
+	Max      time.Duration
+}
+
+// IPRequests are the requests sent over connections to a single resolved IP
+type IPRequests struct {
+	Requests int64
+	Errors   int64
+}
+
+// DepthLatency is the latency of requests sent when the pipeline held Depth pending requests
+type DepthLatency struct {
+	Depth    int
+	Requests int64
+	Total    time.Duration
+	Max      time.Duration
 }
 
 func NewWorker(config *http_clients.Config) (Worker, error) {
+	base := baseConfig(config)
+	config.OnTLSHandshake = base.updateTLSStats
+	config.OnQUICHandshake = base.updateQUICStats
+	config.OnQUICPathChange = base.updateQUICPathChanges
+
 	client, err := http(config)
 	if err != nil {
 		return nil, err
 	}
+	base.pool = &connPool{conns: []*conn{newConn(client, config.Dialer)}}
+	return newWorker(base), nil
+}
 
+func newWorker(base *WorkerBase) Worker {
+	config := base.config
 	if config.ReqLimitedOnly() {
 		if config.JwtStreamReceiver != nil {
-			w := &WorkerFixedReqs{baseConfig(config, client)}
+			w := &WorkerFixedReqs{base}
 			w.middleware = jwtMiddleware
-			return w, nil
+			return w
 		}
-		return &WorkerFixedReqs{baseConfig(config, client)}, nil
+		return &WorkerFixedReqs{base}
 	}
 
 	if config.UnlimitedReqs() {
-		return &WorkerFixedTime{baseConfig(config, client)}, nil
+		return &WorkerFixedTime{base}
 	}
 
-	w := &WorkerFixedTimeRequests{baseConfig(config, client)}
+	w := &WorkerFixedTimeRequests{base}
 	if config.JwtStreamReceiver != nil {
 		w.middleware = jwtMiddleware
 	}
-	return w, nil
+	return w
 }
 
-func newReq(client http_clients.GoPayLoaderClient, config *http_clients.Config) (http_clients.Request, error) {
-	req, err := client.NewReq(config.Method, config.ReqURI)
-	if err != nil {
-		return nil, err
-	}
+// reqTemplate holds the configured headers and body, parsed once per worker rather than for every request. Body
+// files are shared by every worker and streamed if too large to hold in memory.
+type reqTemplate struct {
+	headers  [][2]string
+	body     []byte
+	bodyFile *http_clients.BodyFile
+}
 
+func newReqTemplate(config *http_clients.Config) *reqTemplate {
+	t := &reqTemplate{}
 	if config.DisableKeepAlive {
-		req.SetHeader("Connection", "close")
+		t.headers = append(t.headers, [2]string{"Connection", "close"})
 	}
-	if len(config.Headers) > 0 {
-		for _, h := range config.Headers {
-			header := strings.Split(h, ":")
-			req.SetHeader(header[0], header[1])
-		}
+	for _, h := range config.Headers {
+		header := strings.SplitN(h, ":", 2)
+		t.headers = append(t.headers, [2]string{header[0], header[1]})
 	}
 
 	if len(config.Body) > 0 {
-		req.SetBody([]byte(config.Body))
+		t.body = []byte(config.Body)
 	}
-
-	if len(config.BodyFile) > 0 {
-		bb, err := os.ReadFile(config.BodyFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read body file %v", err)
+	if config.BodyFile != nil {
+		if config.BodyFile.Streamed() {
+			t.bodyFile = config.BodyFile
+		} else {
+			t.body = config.BodyFile.Body
 		}
-		req.SetBody(bb)
 	}
+	return t
+}
 
+// newReq creates a request to the configured URI, requests are reused so only a worker's first requests are created
+// through newReq
+func (w *WorkerBase) newReq(client http_clients.GoPayLoaderClient) (http_clients.Request, error) {
+	return w.newReqTo(client, w.method, w.url, true)
+}
+
+// newReqTo creates a request with the configured headers to uri, the configured body is only set if withBody
+func (w *WorkerBase) newReqTo(client http_clients.GoPayLoaderClient, method, uri string, withBody bool) (http_clients.Request, error) {
+	req, err := client.NewReq(method, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range w.template.headers {
+		req.SetHeader(h[0], h[1])
+	}
+	switch {
+	case !withBody:
+	case w.template.bodyFile != nil:
+		req.SetBodyStream(w.template.bodyFile.Open, w.template.bodyFile.Size)
+	case len(w.template.body) > 0:
+		req.SetBody(w.template.body)
+	}
 	return req, nil
 }
 
@@ -92,25 +194,65 @@ func jwtMiddleware(w *WorkerBase, req http_clients.Request) {
 	}
 }
 
-func baseConfig(config *http_clients.Config, client http_clients.GoPayLoaderClient) *WorkerBase {
+func baseConfig(config *http_clients.Config) *WorkerBase {
 	return &WorkerBase{
 		config:     config,
-		client:     client,
+		template:   newReqTemplate(config),
+		reqs:       &sync.Pool{},
+		hopReqs:    &sync.Map{},
 		parallel:   config.Parallel,
+		handshake:  config.Handshake,
 		parallelWg: &sync.WaitGroup{},
+		inflight:   inflightLimit(config),
+		thinkTime:  config.ThinkTime,
+		rand:       rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
 		reqStats:   config.ReqStats,
+		errStats:   config.ErrStats,
 		method:     config.Method,
 		url:        config.ReqURI,
+		respSizes:  config.RespSizes,
+		responses:  &sync.Map{},
 		stats: Stats{
-			Responses: &sync.Map{},
-			Errors:    &sync.Map{},
+			MTLSIdentity:   config.MTLSName,
+			Errors:         &sync.Map{},
+			ErrorExamples:  &sync.Map{},
+			ErrorLatencies: &sync.Map{},
+			TLSVersions:    &sync.Map{},
+			CipherSuites:   &sync.Map{},
+			ALPNs:          &sync.Map{},
+			PipelineDepths: &sync.Map{},
+			IPs:            &sync.Map{},
+			RedirectHops:   &sync.Map{},
+			RetryAttempts:  &sync.Map{},
 		},
 		statsSuccessLock: &sync.Mutex{},
 		statsErrorLock:   &sync.Mutex{},
+		statsTLSLock:     &sync.Mutex{},
 	}
 }
 
+// inflightLimit caps how many requests a worker has in flight when running in parallel
+func inflightLimit(config *http_clients.Config) chan struct{} {
+	if config.Pipeline > 1 {
+		return make(chan struct{}, config.Pipeline)
+	}
+	if config.MaxInflight > 0 {
+		return make(chan struct{}, config.MaxInflight)
+	}
+	return nil
+}
+
 func http(config *http_clients.Config) (http_clients.GoPayLoaderClient, error) {
+	if config.HTTPClient != nil {
+		return config.HTTPClient, nil
+	}
+	if config.Handshake {
+		return handshake.GetHandshakeClient(config)
+	}
+	if config.Pipeline > 1 {
+		return fasthttp.GetFastHTTPPipelineClient(config)
+	}
+
 	switch config.Client {
 	case HttpClientNetHTTP:
 		return nethttp.GetNetHTTPClient(config)
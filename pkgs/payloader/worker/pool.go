@@ -0,0 +1,90 @@
+package worker
+
+import (
+	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
+	"github.com/domsolutions/gopayloader/pkgs/http-clients/dialer"
+	"sync/atomic"
+	"time"
+)
+
+// conn is a client bound to a single connection along with the dialer it connects through
+type conn struct {
+	client    http_clients.GoPayLoaderClient
+	pipeliner http_clients.Pipeliner
+	dialer    *dialer.Dialer
+	// throttledUntil is when requests may be sent again after a Retry-After, in unix nanoseconds
+	throttledUntil atomic.Int64
+}
+
+func newConn(client http_clients.GoPayLoaderClient, d *dialer.Dialer) *conn {
+	c := &conn{client: client, dialer: d}
+	if p, ok := client.(http_clients.Pipeliner); ok {
+		c.pipeliner = p
+	}
+	return c
+}
+
+// throttle holds back requests over the connection until until, extending any current hold
+func (c *conn) throttle(until time.Time) {
+	for {
+		current := c.throttledUntil.Load()
+		if until.UnixNano() <= current || c.throttledUntil.CompareAndSwap(current, until.UnixNano()) {
+			return
+		}
+	}
+}
+
+// connPool spreads requests round-robin across its connections
+type connPool struct {
+	conns []*conn
+	next  atomic.Uint64
+}
+
+func (p *connPool) pick() *conn {
+	if len(p.conns) == 1 {
+		return p.conns[0]
+	}
+	return p.conns[(p.next.Add(1)-1)%uint64(len(p.conns))]
+}
+
+func (p *connPool) close() {
+	for _, c := range p.conns {
+		c.client.CloseConns()
+	}
+}
+
+// NewPool creates a worker per workerConfigs sharing a client per connConfigs, each worker sends a request at a time
+// over the next connection round-robin so concurrency is bounded by the number of workers rather than connections.
+// Workers don't close the shared connections, call the returned close once all workers are complete.
+func NewPool(workerConfigs, connConfigs []*http_clients.Config) ([]Worker, func(), error) {
+	bases := make([]*WorkerBase, len(workerConfigs))
+	for i, config := range workerConfigs {
+		base := baseConfig(config)
+		base.shared = true
+		bases[i] = base
+	}
+
+	pool := &connPool{}
+	for i, config := range connConfigs {
+		// handshakes are counted against the worker sharing the connection's index
+		owner := bases[i%len(bases)]
+		config.OnTLSHandshake = owner.updateTLSStats
+		config.OnQUICHandshake = owner.updateQUICStats
+		config.OnQUICPathChange = owner.updateQUICPathChanges
+		config.Shared = true
+
+		client, err := http(config)
+		if err != nil {
+			pool.close()
+			return nil, nil, err
+		}
+		pool.conns = append(pool.conns, newConn(client, config.Dialer))
+	}
+
+	workers := make([]Worker, len(bases))
+	for i, base := range bases {
+		base.pool = pool
+		workers[i] = newWorker(base)
+	}
+	return workers, pool.close, nil
+}
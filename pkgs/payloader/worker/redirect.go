@@ -0,0 +1,158 @@
+package worker
+
+import (
+	"fmt"
+	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
+	stdhttp "net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HopLatency is the latency of a single hop in redirect chains, hop 0 is the original request
+type HopLatency struct {
+	Hop      int
+	Requests int64
+	Total    time.Duration
+	Max      time.Duration
+}
+
+// maxHopReqPools caps the redirect locations hop requests are pooled for, locations unique to each request aren't worth
+// pooling and would grow the pools without bound
+const maxHopReqPools = 64
+
+// hopKey identifies redirect hop requests which can be reused for each other
+type hopKey struct {
+	method   string
+	uri      string
+	withBody bool
+}
+
+func isRedirect(code int) bool {
+	switch code {
+	case stdhttp.StatusMovedPermanently, stdhttp.StatusFound, stdhttp.StatusSeeOther, stdhttp.StatusTemporaryRedirect, stdhttp.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// followRedirects follows the redirects in resp up to config.MaxRedirects, returning the final response and the time
+// it was received. Only redirects to the same scheme and host are followed as clients are bound to a single host, others
+// such as http to https redirects are returned as the final response.
+func (w *WorkerBase) followRedirects(c *conn, req http_clients.Request, resp http_clients.Response, begin, end int64) (http_clients.Response, int64, error) {
+	current, err := url.Parse(w.url)
+	if err != nil {
+		w.closeResp(resp)
+		return nil, end, err
+	}
+
+	method := w.method
+	withBody := true
+	hops := []time.Duration{time.Duration(end - begin)}
+
+	for isRedirect(resp.StatusCode()) {
+		location := resp.Header("Location")
+		if location == "" {
+			// nothing to follow, treat as the final response
+			break
+		}
+		if len(hops) > w.config.MaxRedirects {
+			w.closeResp(resp)
+			return nil, end, fmt.Errorf("stopped after %d redirects", w.config.MaxRedirects)
+		}
+
+		next, err := current.Parse(location)
+		if err != nil {
+			w.closeResp(resp)
+			return nil, end, fmt.Errorf("failed to parse redirect location %s; %v", location, err)
+		}
+		if next.Scheme != current.Scheme || next.Host != current.Host {
+			break
+		}
+
+		method, withBody = redirectBehavior(method, resp.StatusCode())
+		w.closeResp(resp)
+
+		hopReq, pool, err := w.acquireHopReq(c.client, method, next.String(), withBody)
+		if err != nil {
+			return nil, end, err
+		}
+		if w.config.JWTHeader != "" {
+			hopReq.SetHeader(w.config.JWTHeader, req.Header(w.config.JWTHeader))
+		}
+
+		hopBegin := time.Now().UnixNano()
+		resp = c.client.NewResponse()
+		err = c.client.Do(hopReq, resp)
+		end = time.Now().UnixNano()
+		w.countSent(hopReq, err)
+		if err != nil {
+			resp.Release()
+			return nil, end, err
+		}
+
+		if pool != nil {
+			// only completed requests are reused, as with reqs
+			pool.Put(hopReq)
+		}
+		hops = append(hops, time.Duration(end-hopBegin))
+		current = next
+	}
+
+	if len(hops) > 1 {
+		w.updateRedirectStats(hops)
+	}
+	return resp, end, nil
+}
+
+// redirectBehavior returns the method and whether the body is sent when following a redirect with code, the same as
+// net/http. 301, 302 and 303 redirects drop the body and change every method except GET and HEAD to GET.
+func redirectBehavior(method string, code int) (string, bool) {
+	switch code {
+	case stdhttp.StatusMovedPermanently, stdhttp.StatusFound, stdhttp.StatusSeeOther:
+		if method != "GET" && method != "HEAD" {
+			method = "GET"
+		}
+		return method, false
+	}
+	return method, true
+}
+
+// acquireHopReq returns a request for a redirect hop, reusing one from a previous chain to the same location. The pool
+// to put it back in once completed is nil when the locations pooled are capped.
+func (w *WorkerBase) acquireHopReq(client http_clients.GoPayLoaderClient, method, uri string, withBody bool) (http_clients.Request, *sync.Pool, error) {
+	key := hopKey{method: method, uri: uri, withBody: withBody}
+	val, ok := w.hopReqs.Load(key)
+	if !ok && w.hopReqPools.Add(1) <= maxHopReqPools {
+		val, _ = w.hopReqs.LoadOrStore(key, &sync.Pool{})
+	}
+
+	var pool *sync.Pool
+	if val != nil {
+		pool = val.(*sync.Pool)
+		if req := pool.Get(); req != nil {
+			return req.(http_clients.Request), pool, nil
+		}
+	}
+	req, err := w.newReqTo(client, method, uri, withBody)
+	return req, pool, err
+}
+
+func (w *WorkerBase) updateRedirectStats(hops []time.Duration) {
+	w.statsSuccessLock.Lock()
+	defer w.statsSuccessLock.Unlock()
+
+	w.RedirectChains.Add(1)
+	for i, latency := range hops {
+		h := HopLatency{Hop: i}
+		if val, ok := w.stats.RedirectHops.Load(i); ok {
+			h = val.(HopLatency)
+		}
+		h.Requests++
+		h.Total += latency
+		if latency > h.Max {
+			h.Max = latency
+		}
+		w.stats.RedirectHops.Store(i, h)
+	}
+}
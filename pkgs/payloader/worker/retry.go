@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"errors"
+	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
+	"time"
+)
+
+var errRetryCancelled = errors.New("cancelled while backing off before retry")
+
+// AttemptLatency is the latency of the Attempt'th attempt of requests sent under the retry policy, attempt 1 is the
+// first
+type AttemptLatency struct {
+	Attempt  int
+	Requests int64
+	Total    time.Duration
+	Max      time.Duration
+}
+
+// retry sends req until the response or error isn't retryable or the retries run out, backing off between attempts.
+// The time returned is when the last attempt completed so latency is end-to-end.
+func (w *WorkerBase) retry(c *conn, req http_clients.Request, begin int64) (http_clients.Response, int64, error) {
+	policy := w.config.Retry
+	var attempts []time.Duration
+
+	for {
+		attemptBegin := time.Now().UnixNano()
+		resp, end, err := w.send(c, req, attemptBegin)
+		attempts = append(attempts, time.Duration(end-attemptBegin))
+
+		var status int
+		if err == nil {
+			status = resp.StatusCode()
+		}
+		if !policy.Retryable(status, err) {
+			w.updateRetryStats(attempts, time.Duration(end-begin), err == nil, false)
+			return resp, end, err
+		}
+
+		if len(attempts) == policy.Attempts || w.config.Ctx.Err() != nil {
+			w.updateRetryStats(attempts, time.Duration(end-begin), false, true)
+			return resp, end, err
+		}
+
+		if resp != nil {
+			w.closeResp(resp)
+		}
+		if !w.backoff(policy.Delay(len(attempts))) || (w.config.HonorRetryAfter && !w.waitThrottle(w.config.Ctx, c)) {
+			end = time.Now().UnixNano()
+			w.updateRetryStats(attempts, time.Duration(end-begin), false, true)
+			return nil, end, errRetryCancelled
+		}
+	}
+}
+
+// backoff waits d before the next retry, returning false if cancelled
+func (w *WorkerBase) backoff(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-w.config.Ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// updateRetryStats records the outcome of a request sent under the retry policy and the latency of each attempt
+func (w *WorkerBase) updateRetryStats(attempts []time.Duration, total time.Duration, succeeded, exhausted bool) {
+	w.statsSuccessLock.Lock()
+	defer w.statsSuccessLock.Unlock()
+
+	retries := int64(len(attempts) - 1)
+	w.Retries.Add(retries)
+	switch {
+	case exhausted:
+		w.RetriesExhausted.Add(1)
+	case succeeded && retries == 0:
+		w.FirstAttemptReqs.Add(1)
+	case succeeded:
+		w.RetriedReqs.Add(1)
+	}
+
+	if retries > 0 {
+		w.RetriedLatency.Add(int64(total))
+		if int64(total) > w.MaxRetriedLatency.Load() {
+			w.MaxRetriedLatency.Store(int64(total))
+		}
+	}
+
+	for i, latency := range attempts {
+		a := AttemptLatency{Attempt: i + 1}
+		if val, ok := w.stats.RetryAttempts.Load(a.Attempt); ok {
+			a = val.(AttemptLatency)
+		}
+		a.Requests++
+		a.Total += latency
+		if latency > a.Max {
+			a.Max = latency
+		}
+		w.stats.RetryAttempts.Store(a.Attempt, a)
+	}
+}
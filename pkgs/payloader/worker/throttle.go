@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"context"
+	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
+	stdhttp "net/http"
+	"time"
+)
+
+// honorRetryAfter throttles the connection for the Retry-After of a 429 or 503 response
+func (w *WorkerBase) honorRetryAfter(c *conn, resp http_clients.Response) {
+	code := resp.StatusCode()
+	if code != stdhttp.StatusTooManyRequests && code != stdhttp.StatusServiceUnavailable {
+		return
+	}
+
+	now := time.Now()
+	d, ok := http_clients.ParseRetryAfter(resp.Header("Retry-After"), now)
+	if !ok {
+		return
+	}
+	w.Throttled.Add(1)
+	c.throttle(now.Add(d))
+}
+
+// waitThrottle waits until the connection is no longer throttled, counting the time spent waiting. Returns false if
+// ctx is done first.
+func (w *WorkerBase) waitThrottle(ctx context.Context, c *conn) bool {
+	wait := time.Until(time.Unix(0, c.throttledUntil.Load()))
+	if wait <= 0 {
+		return true
+	}
+
+	begin := time.Now()
+	defer func() {
+		w.ThrottledTime.Add(int64(time.Since(begin)))
+	}()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
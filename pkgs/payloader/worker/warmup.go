@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"context"
+	"sync"
+)
+
+// warmup runs the warm-up phase over the worker's connections as fast as possible, then waits for its stats to be
+// reset before the measured run starts. It returns false if the run was cancelled.
+func (w *WorkerBase) warmup() bool {
+	warmup := w.config.Warmup
+	if warmup == nil {
+		return true
+	}
+
+	if warmup.Until != 0 {
+		deadline, c := context.WithTimeout(w.config.Ctx, warmup.Until)
+		for deadline.Err() == nil {
+			w.run(deadline)
+		}
+		c()
+	} else {
+		for i := int64(0); i < warmup.ReqTarget && w.config.Ctx.Err() == nil; i++ {
+			w.run(w.config.Ctx)
+		}
+	}
+	w.drain()
+
+	warmup.Done.Done()
+	warmup.Measure.Wait()
+	return w.config.Ctx.Err() == nil
+}
+
+// ResetStats discards the stats of the requests sent and handshakes made so far, it's called between the warm-up and
+// measured run while the worker has no requests in flight. Handshakes are discarded too as every request is a handshake
+// in handshake mode.
+func (w *WorkerBase) ResetStats() {
+	w.statsSuccessLock.Lock()
+	defer w.statsSuccessLock.Unlock()
+	w.statsErrorLock.Lock()
+	defer w.statsErrorLock.Unlock()
+	w.statsTLSLock.Lock()
+	defer w.statsTLSLock.Unlock()
+
+	w.CompletedReqs.Store(0)
+	w.FailedReqs.Store(0)
+	w.RedirectChains.Store(0)
+	w.InflightBlocked.Store(0)
+	w.InflightWait.Store(0)
+	w.ScheduledReqs.Store(0)
+	w.ScheduleLag.Store(0)
+	w.MaxScheduleLag.Store(0)
+	w.FirstAttemptReqs.Store(0)
+	w.RetriedReqs.Store(0)
+	w.RetriesExhausted.Store(0)
+	w.Retries.Store(0)
+	w.RetriedLatency.Store(0)
+	w.MaxRetriedLatency.Store(0)
+	w.Throttled.Store(0)
+	w.ThrottledTime.Store(0)
+	w.SentBytes.Store(0)
+	w.ReceivedBytes.Store(0)
+	w.SentReqs.Store(0)
+	w.Abandoned.Store(0)
+	w.ResumedSessions.Store(0)
+
+	w.responses = &sync.Map{}
+	w.stats.Errors = &sync.Map{}
+	w.stats.ErrorExamples = &sync.Map{}
+	w.stats.ErrorLatencies = &sync.Map{}
+	w.stats.PipelineDepths = &sync.Map{}
+	w.stats.IPs = &sync.Map{}
+	w.stats.RedirectHops = &sync.Map{}
+	w.stats.RetryAttempts = &sync.Map{}
+	w.stats.TLSVersions = &sync.Map{}
+	w.stats.CipherSuites = &sync.Map{}
+	w.stats.ALPNs = &sync.Map{}
+	w.stats.QUIC = QUICHandshakes{}
+}
@@ -10,9 +10,13 @@ type WorkerFixedReqs struct {
 
 func (w *WorkerFixedReqs) Run(wg *sync.WaitGroup) {
 	defer wg.Done()
-	defer w.client.CloseConns()
+	defer w.closeConns()
+	defer w.drain()
 
 	w.config.StartTrigger.Wait()
+	if !w.warmup() {
+		return
+	}
 
 	var i int64
 	for i = 0; i < w.config.ReqTarget; i++ {
@@ -21,11 +25,13 @@ func (w *WorkerFixedReqs) Run(wg *sync.WaitGroup) {
 			// user cancelled
 			return
 		default:
-			w.run()
+			if i > 0 {
+				w.think(nil)
+			}
+			if !w.wait(w.config.Ctx) {
+				return
+			}
+			w.run(w.config.Ctx)
 		}
 	}
-
-	if w.parallel {
-		w.parallelWg.Wait()
-	}
 }
@@ -1,7 +1,7 @@
 package worker
 
 import (
-	"context"
+	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
 	"sync"
 	"time"
 )
@@ -12,32 +12,53 @@ type WorkerFixedTimeRequests struct {
 
 func (w *WorkerFixedTimeRequests) Run(wg *sync.WaitGroup) {
 	defer wg.Done()
-	defer w.client.CloseConns()
+	defer w.closeConns()
+	defer w.drain()
 
-	w.config.StartTrigger.Wait()
-	deadline, c := context.WithTimeout(context.Background(), w.config.Until)
-	defer c()
-	newReq := time.NewTicker(w.config.ReqEvery)
+	arrival := w.config.Arrival
+	if arrival == nil {
+		arrival = &http_clients.Arrival{Process: http_clients.ArrivalUniform}
+	}
+	next := arrival.Schedule(w.config.ReqTarget, w.config.Until, w.rand)
 
-	for {
-		select {
-		case <-w.config.Ctx.Done():
-			// user cancelled
-			return
-		case <-deadline.Done():
-			// required reqs were not completed in time period, finish reqs
-			if w.config.ReqTarget != w.CompletedReqs.Load()+w.FailedReqs.Load() {
-				w.run()
-				continue
-			}
+	w.config.StartTrigger.Wait()
+	if !w.warmup() {
+		return
+	}
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
 
-			if w.parallel {
-				w.parallelWg.Wait()
+	// requests are scheduled at offsets from the start rather than after each other so the schedule doesn't drift,
+	// requests behind schedule are sent straight away. Time held back by the control API or a Retry-After shifts the
+	// schedule rather than bursting the missed requests once released.
+	var sent int64
+	for sent < w.config.ReqTarget {
+		at := start.Add(next())
+		if wait := time.Until(at); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-w.config.Ctx.Done():
+				// user cancelled
+				return
+			case <-timer.C:
 			}
+		} else if w.config.Ctx.Err() != nil {
 			return
-		case <-newReq.C:
-			w.run()
 		}
-	}
+		held := time.Now()
+		if !w.wait(w.config.Ctx) {
+			return
+		}
+		if w.config.Gate != nil {
+			shift := time.Since(held)
+			start = start.Add(shift)
+			at = at.Add(shift)
+		}
 
+		w.updateScheduleStats(time.Since(at))
+		sent++
+		start = start.Add(w.run(w.config.Ctx))
+	}
 }
@@ -1,8 +1,8 @@
 package worker
 
 import (
+	"context"
 	"sync"
-	"time"
 )
 
 type WorkerFixedTime struct {
@@ -11,23 +11,29 @@ type WorkerFixedTime struct {
 
 func (w *WorkerFixedTime) Run(wg *sync.WaitGroup) {
 	defer wg.Done()
-	defer w.client.CloseConns()
+	defer w.closeConns()
+	defer w.drain()
 
 	w.config.StartTrigger.Wait()
-	ticker := time.NewTicker(w.config.Until)
+	if !w.warmup() {
+		return
+	}
+	deadline, c := context.WithTimeout(w.config.Ctx, w.config.Until)
+	defer c()
 
 	for {
 		select {
 		case <-w.config.Ctx.Done():
 			// user cancelled
 			return
-		case <-ticker.C:
-			if w.parallel {
-				w.parallelWg.Wait()
-			}
+		case <-deadline.Done():
 			return
 		default:
-			w.run()
+			if !w.wait(deadline) {
+				return
+			}
+			w.run(deadline)
+			w.think(deadline.Done())
 		}
 	}
 
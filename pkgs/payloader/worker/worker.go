@@ -1,7 +1,11 @@
 package worker
 
 import (
+	"context"
+	"crypto/tls"
 	http_clients "github.com/domsolutions/gopayloader/pkgs/http-clients"
+	"github.com/domsolutions/gopayloader/pkgs/payloader/histogram"
+	"math/rand/v2"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -10,87 +14,290 @@ import (
 type Worker interface {
 	Run(wg *sync.WaitGroup)
 	Stats() Stats
-	ReqSize() int64
-	RespSize() int64
+	// Counts returns the completed and failed requests without locking, for stats read every tick
+	Counts() (completed, failed int64)
+	// ResetStats discards the stats of the warm-up requests
+	ResetStats()
+	// Abandon gives up on the requests still in flight, closing the worker's connections unless they're shared
+	Abandon()
 }
 
 type WorkerBase struct {
 	statsSuccessLock *sync.Mutex
 	statsErrorLock   *sync.Mutex
+	statsTLSLock     *sync.Mutex
 	config           *http_clients.Config
-	client           http_clients.GoPayLoaderClient
+	pool             *connPool
+	shared           bool
 	stats            Stats
 	middleware       func(w *WorkerBase, req http_clients.Request)
-	reqStats         chan<- time.Duration
+	reqStats         *histogram.Atomic
+	errStats         *histogram.Atomic
 	parallel         bool
+	handshake        bool
 	method           string
 	url              string
-	reqSize          int64
-	respSize         int64
-	parallelWg       *sync.WaitGroup
-	CompletedReqs    atomic.Int64
-	FailedReqs       atomic.Int64
+	template         *reqTemplate
+	// reqs holds requests built by newReq to be reused, requests in flight are never in the pool
+	reqs *sync.Pool
+	// hopReqs holds pools of redirect hop requests by hopKey, hopReqPools counts the pools created
+	hopReqs     *sync.Map
+	hopReqPools atomic.Int64
+	respSizes   *histogram.Atomic
+	// responses counts each response code, values are *atomic.Int64 so codes already seen are counted without locking
+	responses       *sync.Map
+	parallelWg      *sync.WaitGroup
+	inflight        chan struct{}
+	thinkTime       *http_clients.ThinkTime
+	rand            *rand.Rand
+	CompletedReqs   atomic.Int64
+	FailedReqs      atomic.Int64
+	ResumedSessions atomic.Int64
+	RedirectChains  atomic.Int64
+	InflightBlocked atomic.Int64
+	InflightWait    atomic.Int64
+	ScheduledReqs   atomic.Int64
+	ScheduleLag     atomic.Int64
+	MaxScheduleLag  atomic.Int64
+	Inflight        atomic.Int64
+	// FirstAttemptReqs, RetriedReqs and RetriesExhausted are the outcomes of requests sent under the retry policy
+	FirstAttemptReqs  atomic.Int64
+	RetriedReqs       atomic.Int64
+	RetriesExhausted  atomic.Int64
+	Retries           atomic.Int64
+	RetriedLatency    atomic.Int64
+	MaxRetriedLatency atomic.Int64
+	// Throttled are responses whose Retry-After was honored, ThrottledTime the time spent waiting them out
+	Throttled     atomic.Int64
+	ThrottledTime atomic.Int64
+	// SentBytes and ReceivedBytes are the bytes of every request sent and response received, including retries and
+	// redirects
+	SentBytes     atomic.Int64
+	ReceivedBytes atomic.Int64
+	// SentReqs are the requests SentBytes were counted for, failed ones included
+	SentReqs atomic.Int64
+	// Abandoned are the requests whose connections were closed under them once the grace period passed
+	Abandoned atomic.Int64
+	abandoned atomic.Bool
 }
 
-func (w *WorkerBase) ReqSize() int64 {
-	return w.reqSize
+// countSent counts the bytes of a request once sent, clients only add some headers as they send it. Requests failing
+// once written are counted, those failing to connect weren't sent.
+func (w *WorkerBase) countSent(req http_clients.Request, err error) {
+	if err != nil && http_clients.Unsent(err) {
+		return
+	}
+	w.SentBytes.Add(req.Size())
+	w.SentReqs.Add(1)
+}
+
+// closeResp closes resp, counting the bytes received, and releases it. The size is taken once closed as bodies are
+// read on close.
+func (w *WorkerBase) closeResp(resp http_clients.Response) {
+	resp.Close()
+	defer resp.Release()
+	if w.handshake {
+		// no HTTP exchange
+		return
+	}
+
+	size := resp.Size()
+	w.ReceivedBytes.Add(size)
+	w.respSizes.Record(size)
+}
+
+// drain waits for requests sent in parallel to complete, requests are drained when the run ends or is cancelled
+func (w *WorkerBase) drain() {
+	if w.parallel {
+		w.parallelWg.Wait()
+	}
+}
+
+// closeConns closes the worker's connections once its requests are drained, unless they're shared with other workers
+func (w *WorkerBase) closeConns() {
+	if w.shared {
+		return
+	}
+	w.pool.close()
 }
 
-func (w *WorkerBase) RespSize() int64 {
-	return w.respSize
+// Abandon closes the worker's connections once the grace period passed, requests failing after are counted as abandoned
+// rather than failed. Shared connections are closed by the pool.
+func (w *WorkerBase) Abandon() {
+	w.abandoned.Store(true)
+	w.closeConns()
 }
 
-func (w *WorkerBase) updateErrStats(err error) {
+// updateErrStats counts err and how long the request took to fail under its category, keeping the first error of
+// each category as an example
+func (w *WorkerBase) updateErrStats(err error, took time.Duration) {
+	category := http_clients.ClassifyError(err)
+	w.errStats.Record(int64(took))
+
 	w.statsErrorLock.Lock()
 	defer w.statsErrorLock.Unlock()
 
-	val, ok := w.stats.Errors.Load(err.Error())
+	val, ok := w.stats.Errors.Load(category)
 	if ok {
-		w.stats.Errors.Store(err.Error(), val.(uint64)+1)
+		w.stats.Errors.Store(category, val.(uint64)+1)
 	} else {
-		w.stats.Errors.Store(err.Error(), uint64(1))
+		w.stats.Errors.Store(category, uint64(1))
+		w.stats.ErrorExamples.Store(category, err.Error())
 	}
 
+	l := ErrorLatency{}
+	if val, ok := w.stats.ErrorLatencies.Load(category); ok {
+		l = val.(ErrorLatency)
+	}
+	l.Requests++
+	l.Total += took
+	if took > l.Max {
+		l.Max = took
+	}
+	w.stats.ErrorLatencies.Store(category, l)
+
 	w.FailedReqs.Add(1)
 }
 
-func (w *WorkerBase) run() {
+// run sends a request over the next connection, once it's no longer throttled if Retry-After is honored, returning how
+// long the request was held back for
+func (w *WorkerBase) run(ctx context.Context) (throttled time.Duration) {
+	c := w.pool.pick()
+	if w.config.HonorRetryAfter {
+		throttled = max(time.Until(time.Unix(0, c.throttledUntil.Load())), 0)
+		if !w.waitThrottle(ctx, c) {
+			return throttled
+		}
+	}
+
 	if w.parallel {
+		if w.inflight != nil {
+			w.acquireInflight()
+		}
 		w.parallelWg.Add(1)
 		go func() {
 			defer w.parallelWg.Done()
-
-			err := w.process()
-			if err != nil {
-				w.updateErrStats(err)
+			if w.inflight != nil {
+				defer func() {
+					<-w.inflight
+				}()
 			}
+
+			w.handle(c)
 		}()
+		return throttled
+	}
+
+	w.handle(c)
+	return throttled
+}
+
+// acquireInflight takes an in-flight slot, counting the times the cap held back the next request and for how long
+func (w *WorkerBase) acquireInflight() {
+	select {
+	case w.inflight <- struct{}{}:
+		return
+	default:
+	}
+
+	w.InflightBlocked.Add(1)
+	begin := time.Now()
+	w.inflight <- struct{}{}
+	w.InflightWait.Add(int64(time.Since(begin)))
+}
+
+// wait holds the worker back while the control API has paused it, made it inactive or capped the rate, returning false
+// if ctx is done first
+func (w *WorkerBase) wait(ctx context.Context) bool {
+	if w.config.Gate == nil {
+		return true
+	}
+	return w.config.Gate.Wait(ctx, w.config.WorkerID)
+}
+
+// think pauses for the next think time, returning early if cancelled or done is closed
+func (w *WorkerBase) think(done <-chan struct{}) {
+	if w.thinkTime == nil {
 		return
 	}
 
-	err := w.process()
+	pause := time.NewTimer(w.thinkTime.Next(w.rand))
+	defer pause.Stop()
+
+	select {
+	case <-w.config.Ctx.Done():
+	case <-done:
+	case <-pause.C:
+	}
+}
+
+// updateScheduleStats records how late a scheduled request was sent, only the scheduling goroutine calls it
+func (w *WorkerBase) updateScheduleStats(lag time.Duration) {
+	w.ScheduledReqs.Add(1)
+	w.ScheduleLag.Add(int64(lag))
+	if int64(lag) > w.MaxScheduleLag.Load() {
+		w.MaxScheduleLag.Store(int64(lag))
+	}
+}
+
+func (w *WorkerBase) handle(c *conn) {
+	w.Inflight.Add(1)
+	defer w.Inflight.Add(-1)
+
+	begin := time.Now()
+	err := w.process(c)
+	if err != nil && w.abandoned.Load() {
+		w.Abandoned.Add(1)
+		return
+	}
 	if err != nil {
-		w.updateErrStats(err)
+		w.updateErrStats(err, time.Since(begin))
 	}
 }
 
-func (w *WorkerBase) process() error {
+func (w *WorkerBase) updateIPStats(ip string, err error) {
+	if ip == "" {
+		// failed before a connection was attempted
+		return
+	}
+
+	w.statsErrorLock.Lock()
+	defer w.statsErrorLock.Unlock()
+
+	var r IPRequests
+	if val, ok := w.stats.IPs.Load(ip); ok {
+		r = val.(IPRequests)
+	}
+	r.Requests++
+	if err != nil {
+		r.Errors++
+	}
+	w.stats.IPs.Store(ip, r)
+}
+
+func (w *WorkerBase) process(c *conn) error {
 	begin := time.Now().UnixNano()
 	var end int64
 	var err error
 
-	req, err := newReq(w.client, w.config)
+	req, err := w.acquireReq(c.client)
 	if err != nil {
 		return err
 	}
 
-	resp := w.client.NewResponse()
+	var resp http_clients.Response
 
 	defer func() {
 		if err == nil {
-			w.reqStats <- time.Duration(end - begin)
+			w.reqStats.Record(end - begin)
+		}
+		if resp != nil {
 			// this frees up the connection to be used by other requests
-			resp.Close()
+			w.closeResp(resp)
+		}
+		if err == nil {
+			// clients may still be writing requests which failed so only those completed are reused
+			w.reqs.Put(req)
 		}
 	}()
 
@@ -98,41 +305,186 @@ func (w *WorkerBase) process() error {
 		w.middleware(w, req)
 	}
 
-	if err = w.client.Do(req, resp); err != nil {
-		end = time.Now().UnixNano()
+	var depth int
+	if c.pipeliner != nil {
+		depth = c.pipeliner.PendingRequests() + 1
+	}
+
+	if w.config.Retry != nil {
+		resp, end, err = w.retry(c, req, begin)
+	} else {
+		resp, end, err = w.send(c, req, begin)
+	}
+	if err != nil {
 		return err
 	}
-	end = time.Now().UnixNano()
 
-	w.updateRespStats(req, resp)
+	w.updateRespStats(resp)
+	if depth > 0 {
+		w.updatePipelineStats(depth, time.Duration(end-begin))
+	}
 	return nil
 }
 
-func (w *WorkerBase) updateRespStats(req http_clients.Request, resp http_clients.Response) {
+// acquireReq returns a request built earlier by the worker if there's one free, otherwise builds a new one. Headers set
+// per request such as the JWT are overwritten each time it's sent.
+func (w *WorkerBase) acquireReq(client http_clients.GoPayLoaderClient) (http_clients.Request, error) {
+	if req := w.reqs.Get(); req != nil {
+		return req.(http_clients.Request), nil
+	}
+	return w.newReq(client)
+}
+
+// send sends req following redirects, returning the final response and the time it was received
+func (w *WorkerBase) send(c *conn, req http_clients.Request, begin int64) (http_clients.Response, int64, error) {
+	resp := c.client.NewResponse()
+	err := c.client.Do(req, resp)
+	end := time.Now().UnixNano()
+	w.countSent(req, err)
+	if c.dialer != nil {
+		// counted against the connection the request was sent over, retries may be sent over another
+		w.updateIPStats(resp.RemoteIP(), err)
+	}
+	if err != nil {
+		resp.Release()
+		return nil, end, err
+	}
+
+	if w.config.MaxRedirects > 0 && isRedirect(resp.StatusCode()) {
+		if resp, end, err = w.followRedirects(c, req, resp, begin, end); err != nil {
+			return nil, end, err
+		}
+	}
+	if w.config.HonorRetryAfter {
+		w.honorRetryAfter(c, resp)
+	}
+	return resp, end, nil
+}
+
+func (w *WorkerBase) updatePipelineStats(depth int, latency time.Duration) {
 	w.statsSuccessLock.Lock()
 	defer w.statsSuccessLock.Unlock()
 
-	if w.reqSize == 0 {
-		w.reqSize = req.Size()
+	d := DepthLatency{Depth: depth}
+	if val, ok := w.stats.PipelineDepths.Load(depth); ok {
+		d = val.(DepthLatency)
 	}
-
-	if w.respSize == 0 {
-		w.respSize = resp.Size()
+	d.Requests++
+	d.Total += latency
+	if latency > d.Max {
+		d.Max = latency
 	}
+	w.stats.PipelineDepths.Store(depth, d)
+}
 
+func (w *WorkerBase) updateRespStats(resp http_clients.Response) {
 	w.CompletedReqs.Add(1)
 
-	val, ok := w.stats.Responses.Load(ResponseCode(resp.StatusCode()))
+	if w.handshake {
+		// no HTTP exchange so no response code
+		return
+	}
+
+	code := ResponseCode(resp.StatusCode())
+	val, ok := w.responses.Load(code)
+	if !ok {
+		val, _ = w.responses.LoadOrStore(code, &atomic.Int64{})
+	}
+	val.(*atomic.Int64).Add(1)
+}
+
+func (w *WorkerBase) updateQUICStats(took time.Duration, used0RTT bool) {
+	w.statsTLSLock.Lock()
+	defer w.statsTLSLock.Unlock()
+
+	w.stats.QUIC.Count++
+	w.stats.QUIC.Total += took
+	if took > w.stats.QUIC.Max {
+		w.stats.QUIC.Max = took
+	}
+	if used0RTT {
+		w.stats.QUIC.Used0RTT++
+	}
+}
+
+func (w *WorkerBase) updateQUICPathChanges() {
+	w.statsTLSLock.Lock()
+	defer w.statsTLSLock.Unlock()
+
+	w.stats.QUIC.PathChanges++
+}
+
+func (w *WorkerBase) updateTLSStats(state tls.ConnectionState) {
+	w.statsTLSLock.Lock()
+	defer w.statsTLSLock.Unlock()
+
+	if state.DidResume {
+		w.ResumedSessions.Add(1)
+	}
+
+	version := tls.VersionName(state.Version)
+	val, ok := w.stats.TLSVersions.Load(version)
 	if ok {
-		w.stats.Responses.Store(ResponseCode(resp.StatusCode()), val.(int64)+1)
+		w.stats.TLSVersions.Store(version, val.(int64)+1)
+	} else {
+		w.stats.TLSVersions.Store(version, int64(1))
+	}
+
+	cipher := tls.CipherSuiteName(state.CipherSuite)
+	val, ok = w.stats.CipherSuites.Load(cipher)
+	if ok {
+		w.stats.CipherSuites.Store(cipher, val.(int64)+1)
+	} else {
+		w.stats.CipherSuites.Store(cipher, int64(1))
+	}
+
+	if state.NegotiatedProtocol == "" {
 		return
 	}
+	val, ok = w.stats.ALPNs.Load(state.NegotiatedProtocol)
+	if ok {
+		w.stats.ALPNs.Store(state.NegotiatedProtocol, val.(int64)+1)
+	} else {
+		w.stats.ALPNs.Store(state.NegotiatedProtocol, int64(1))
+	}
+}
 
-	w.stats.Responses.Store(ResponseCode(resp.StatusCode()), int64(1))
+func (w *WorkerBase) Counts() (completed, failed int64) {
+	return w.CompletedReqs.Load(), w.FailedReqs.Load()
 }
 
 func (w *WorkerBase) Stats() Stats {
+	w.statsTLSLock.Lock()
+	defer w.statsTLSLock.Unlock()
+
 	w.stats.FailedReqs = w.FailedReqs.Load()
 	w.stats.CompletedReqs = w.CompletedReqs.Load()
+	w.stats.ResumedSessions = w.ResumedSessions.Load()
+	w.stats.RedirectChains = w.RedirectChains.Load()
+	w.stats.InflightBlocked = w.InflightBlocked.Load()
+	w.stats.InflightWait = time.Duration(w.InflightWait.Load())
+	w.stats.ScheduledReqs = w.ScheduledReqs.Load()
+	w.stats.ScheduleLag = time.Duration(w.ScheduleLag.Load())
+	w.stats.MaxScheduleLag = time.Duration(w.MaxScheduleLag.Load())
+	w.stats.Inflight = w.Inflight.Load()
+	w.stats.FirstAttemptReqs = w.FirstAttemptReqs.Load()
+	w.stats.RetriedReqs = w.RetriedReqs.Load()
+	w.stats.RetriesExhausted = w.RetriesExhausted.Load()
+	w.stats.Retries = w.Retries.Load()
+	w.stats.RetriedLatency = time.Duration(w.RetriedLatency.Load())
+	w.stats.MaxRetriedLatency = time.Duration(w.MaxRetriedLatency.Load())
+	w.stats.Throttled = w.Throttled.Load()
+	w.stats.ThrottledTime = time.Duration(w.ThrottledTime.Load())
+	w.stats.SentBytes = w.SentBytes.Load()
+	w.stats.ReceivedBytes = w.ReceivedBytes.Load()
+	w.stats.SentReqs = w.SentReqs.Load()
+	w.stats.Abandoned = w.Abandoned.Load()
+
+	// copied so callers get counts rather than the live counters
+	w.stats.Responses = &sync.Map{}
+	w.responses.Range(func(key, value any) bool {
+		w.stats.Responses.Store(key, value.(*atomic.Int64).Load())
+		return true
+	})
 	return w.stats
 }
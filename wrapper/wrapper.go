@@ -3,6 +3,7 @@ package wrapper
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/domsolutions/gopayloader/pkgs/payloader/output/cli"
 	"github.com/domsolutions/gopayloader/version"
 	"github.com/pterm/pterm"
@@ -15,14 +16,23 @@ import (
 	"github.com/domsolutions/gopayloader/pkgs/payloader"
 )
 
-func RunGoPayLoader(reqURI, mTLScert, mTLSKey string, disableKeepAlive bool, reqs int64, conns uint, totalTime time.Duration, skipVerify bool, readTimeout, writeTimeout time.Duration, method string, verbose bool, ticker time.Duration, jwtKID, jwtKey, jwtSub, jwtCustomClaimsJSON, jwtIss, jwtAud, jwtHeader, jwtsFilename string, headers []string, body, bodyFile string, client string, parallel bool) error {
+var (
+	// ErrAborted is returned when an abort condition stopped the run early, results are still displayed
+	ErrAborted = errors.New("run aborted")
+	// ErrInterrupted is returned when the user stopped the run, partial results are displayed unless the run was
+	// forced to exit
+	ErrInterrupted = errors.New("run interrupted")
+)
+
+func RunGoPayLoader(reqURI string, mTLSCerts, mTLSKeys []string, mTLSDir string, disableKeepAlive bool, reqs int64, conns uint, totalTime time.Duration, skipVerify bool, readTimeout, writeTimeout time.Duration, method string, verbose bool, ticker time.Duration, jwtKID, jwtKey, jwtSub, jwtCustomClaimsJSON, jwtIss, jwtAud, jwtHeader, jwtsFilename string, headers []string, body, bodyFile string, client string, parallel bool, pipeline uint, handshake, sessionResumption bool, resolve []string, resolver string, sourceIPs []string, caCert, sni, tlsMin, tlsMax string, tlsCiphers, tlsCurves, alpn []string, h2MaxStreams, h2Conns uint, h2MaxHeaderListSize, h2MaxFrameSize, h2StreamWindow, h2ConnWindow uint32, h2ReadIdleTimeout, h2PingTimeout time.Duration, quicHandshakeTimeout, quicIdleTimeout, quicKeepAlive time.Duration, quicMaxIncomingStreams int64, quic0RTT bool, maxRedirects, concurrency, maxInflight, vus uint, thinkTime, arrival string, warmup time.Duration, warmupRequests int64, abortErrorRate string, abortWindow, abortP99 time.Duration, abortAfterErrors uint64, gracePeriod time.Duration, controlAddr string, retryAttempts uint, retryOn []string, retryBackoff, retryMaxBackoff time.Duration, retryJitter float64, honorRetryAfter bool) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	conf := config.NewConfig(ctx,
 		reqURI,
-		mTLScert,
-		mTLSKey,
+		mTLSCerts,
+		mTLSKeys,
+		mTLSDir,
 		disableKeepAlive,
 		reqs,
 		conns,
@@ -33,7 +43,10 @@ func RunGoPayLoader(reqURI, mTLScert, mTLSKey string, disableKeepAlive bool, req
 		method,
 		verbose,
 		ticker,
-		jwtKID, jwtKey, jwtSub, jwtCustomClaimsJSON, jwtIss, jwtAud, jwtHeader, jwtsFilename, headers, body, bodyFile, client, parallel)
+		jwtKID, jwtKey, jwtSub, jwtCustomClaimsJSON, jwtIss, jwtAud, jwtHeader, jwtsFilename, headers, body, bodyFile, client, parallel, pipeline, handshake, sessionResumption, resolve, resolver, sourceIPs, caCert, sni, tlsMin, tlsMax, tlsCiphers, tlsCurves, alpn, h2MaxStreams, h2Conns, h2MaxHeaderListSize, h2MaxFrameSize, h2StreamWindow, h2ConnWindow, h2ReadIdleTimeout, h2PingTimeout, quicHandshakeTimeout, quicIdleTimeout, quicKeepAlive, quicMaxIncomingStreams, quic0RTT, maxRedirects, concurrency, maxInflight, vus, thinkTime, arrival, warmup, warmupRequests, abortErrorRate, abortWindow, abortP99, abortAfterErrors, gracePeriod, controlAddr, retryAttempts, retryOn, retryBackoff, retryMaxBackoff, retryJitter, honorRetryAfter)
+	if err := conf.Parse(); err != nil {
+		return err
+	}
 	if err := conf.Validate(); err != nil {
 		return err
 	}
@@ -43,7 +56,6 @@ func RunGoPayLoader(reqURI, mTLScert, mTLSKey string, disableKeepAlive bool, req
 
 	if verbose {
 		pterm.EnableDebugMessages()
-		pterm.Warning.Println("In verbose mode RPS will be slightly lower due to monitoring, more noticeable in longer running tests")
 	}
 
 	payload := payloader.NewPayLoader(conf)
@@ -64,30 +76,32 @@ func RunGoPayLoader(reqURI, mTLScert, mTLSKey string, disableKeepAlive bool, req
 
 	select {
 	case <-c:
-		// user pressed ctrl+c
+		// user pressed ctrl+c, in-flight requests are drained and partial results shown unless pressed again
 		cancel()
-		timeout := 5 * time.Second
-		deadline := time.Now().Add(timeout)
-
-		pterm.Info.Printf("User aborted; waiting %s for results before exiting \n", timeout)
-
-		ctx, c := context.WithDeadline(context.Background(), deadline)
-		defer c()
+		pterm.Info.Printf("Interrupted; finishing in-flight requests for up to %s, press Ctrl+C again to exit now\n", gracePeriod)
 
 		select {
 		case results := <-resPayLoader:
 			cli.Display(results)
+			return fmt.Errorf("%w; partial results shown", ErrInterrupted)
 		case err := <-errPayLoader:
 			// user may have cancelled during jwt generation, so there will be no results
-			return err
-		case <-ctx.Done():
-			return errors.New("timeout exceeded, failed to get payload results")
+			return fmt.Errorf("%w; %v", ErrInterrupted, err)
+		case <-c:
+			return fmt.Errorf("%w; forced exit without results", ErrInterrupted)
 		}
 	case err := <-errPayLoader:
 		return err
 	case results := <-resPayLoader:
 		cli.Display(results)
+		return aborted(results)
 	}
+}
 
+// aborted returns ErrAborted with the reason if an abort condition stopped the run
+func aborted(results *payloader.GoPayloaderResults) error {
+	if results.Aborted {
+		return fmt.Errorf("%w; %s", ErrAborted, results.AbortReason)
+	}
 	return nil
 }